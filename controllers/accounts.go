@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"stock/db"
+	"stock/models"
+	"stock/services"
+)
+
+// invitationErrorResponse maps invitation and password reset errors to HTTP errors
+func invitationErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrAccountTokenInvalid):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Link is invalid or expired"})
+	case errors.Is(err, services.ErrEmailRequired):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "An email address is required to invite a user"})
+	case errors.Is(err, services.ErrInvitationAccepted):
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Invitation has already been accepted"})
+	case errors.Is(err, services.ErrNotInvited):
+		return c.JSON(http.StatusConflict, echo.Map{"error": "User was not invited"})
+	case errors.Is(err, services.ErrUserInactive):
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "User is inactive"})
+	default:
+		log.Printf("Account email error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+}
+
+// invitationEmailFailed answers for an invitation that was saved but could not
+// be emailed; the admin can send a new one
+func invitationEmailFailed(c echo.Context, err error) error {
+	log.Printf("Error sending invitation email: %v", err)
+	return c.JSON(http.StatusBadGateway, echo.Map{"error": "The invitation was created but could not be emailed, send it again"})
+}
+
+// ForgotPassword emails a password reset link. It answers the same whether or
+// not the email belongs to a user.
+func ForgotPassword(c echo.Context) error {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Error decoding JSON"})
+	}
+
+	if err := services.ThrottleAccount("password_reset", input.Email); err != nil {
+		return tooManyRequests(c, err)
+	}
+
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	msg, err := services.RequestPasswordReset(tx, input.Email)
+	if err != nil {
+		return invitationErrorResponse(c, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	// A failed send is only logged, so the answer stays the same
+	if err := services.SendAccountEmail(msg); err != nil {
+		log.Printf("Error sending password reset email: %v", err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "If the email belongs to an account, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password with a reset link token
+func ResetPassword(c echo.Context) error {
+	return redeemAccountToken(c, models.AccountTokenPasswordReset, "Password reset successfully")
+}
+
+// AcceptInvitation sets the invited user's first password
+func AcceptInvitation(c echo.Context) error {
+	return redeemAccountToken(c, models.AccountTokenInvitation, "Invitation accepted, you can now log in")
+}
+
+func redeemAccountToken(c echo.Context, purpose, message string) error {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Error decoding JSON"})
+	}
+	if input.Password == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "password is required"})
+	}
+
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	user, err := services.RedeemAccountToken(tx, input.Token, purpose, input.Password)
+	if err != nil {
+		return invitationErrorResponse(c, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+
+	log.Printf("redeemAccountToken - UserID %d set their password (%s)", user.ID, purpose)
+	return c.JSON(http.StatusOK, echo.Map{"message": message})
+}
+
+// ResendInvitation sends a new invitation to a user who has not accepted one yet
+func ResendInvitation(c echo.Context) error {
+	var user models.User
+	if err := db.GetDB().First(&user, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
+	}
+	return resendInvitation(c, &user)
+}
+
+// OrganizationAdminResendInvitation sends a new invitation to a user of the
+// admin's organization who has not accepted one yet
+func OrganizationAdminResendInvitation(c echo.Context) error {
+	var user models.User
+	if err := db.GetDB().Scopes(orgScope(c)).First(&user, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
+	}
+	return resendInvitation(c, &user)
+}
+
+func resendInvitation(c echo.Context, user *models.User) error {
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	msg, err := services.RenewInvitation(tx, user, currentUserID(c))
+	if err != nil {
+		return invitationErrorResponse(c, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	if err := services.SendAccountEmail(msg); err != nil {
+		return invitationEmailFailed(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Invitation sent"})
+}
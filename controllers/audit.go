@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"stock/listing"
+	"stock/models"
+)
+
+// GetAuditEvents lists audit events, newest first. Organization users see
+// their organization's trail; platform users see every organization's and can
+// narrow it with organization_id.
+func GetAuditEvents(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	query := db.Model(&models.AuditEvent{})
+	if orgID := currentOrganizationID(c); orgID != 0 {
+		query = query.Scopes(orgScope(c))
+	} else if orgID := c.QueryParam("organization_id"); orgID != "" {
+		query = query.Where("organization_id = ?", orgID)
+	}
+
+	var events []models.AuditEvent
+	return listResponse(c, query, auditEventListing, &events)
+}
+
+var auditEventListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":          {Column: "id", Kind: listing.Int, Sort: true},
+		"actor_id":    {Column: "actor_id", Kind: listing.Int, Filter: true},
+		"action":      {Column: "action", Filter: true},
+		"entity_type": {Column: "entity_type", Filter: true},
+		"entity_id":   {Column: "entity_id", Kind: listing.Int, Filter: true},
+		"request_id":  {Column: "request_id", Filter: true},
+		"created_at":  {Column: "created_at", Kind: listing.Time, Range: true, Sort: true},
+	},
+	Key:         "id",
+	DefaultSort: "-id",
+}
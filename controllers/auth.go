@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"stock/db"
+	"stock/models"
+	"stock/ratelimit"
+	"stock/services"
+	"stock/utils"
+)
+
+// Login signs a user in with their username or email and returns a token
+// pair with a profile describing their role, organization and permissions
+func Login(c echo.Context) error {
+	var input struct {
+		Identifier string `json:"identifier"`
+		Username   string `json:"username"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Error decoding JSON"})
+	}
+
+	// Older clients send the identifier as username or email
+	identifier := input.Identifier
+	if identifier == "" {
+		identifier = input.Username
+	}
+	if identifier == "" {
+		identifier = input.Email
+	}
+
+	if err := services.ThrottleAccount("login", identifier); err != nil {
+		log.Printf("Login - Throttled attempts for %q", identifier)
+		return tooManyRequests(c, err)
+	}
+
+	result, err := services.Login(db.GetDB(), identifier, input.Password)
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		log.Printf("Login - Invalid credentials for %q", identifier)
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid username, email or password"})
+	case errors.Is(err, services.ErrAccountLocked):
+		log.Printf("Login - Refused locked account %q", identifier)
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "Account is temporarily locked after too many failed logins"})
+	case errors.Is(err, services.ErrUserInactive):
+		log.Printf("Login - Refused inactive user %q", identifier)
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "User is inactive"})
+	case errors.Is(err, services.ErrOrganizationInactive):
+		log.Printf("Login - Refused user %q of a deactivated organization", identifier)
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "Organization is deactivated"})
+	case err != nil:
+		log.Printf("Login - Error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not log in"})
+	}
+
+	log.Printf("Login - UserID %d logged in with role %d", result.Profile.ID, result.Profile.Role.ID)
+	return c.JSON(http.StatusOK, result)
+}
+
+// tooManyRequests answers a throttled request, telling the client when to retry
+func tooManyRequests(c echo.Context, err error) error {
+	var limited *ratelimit.LimitedError
+	if errors.As(err, &limited) {
+		c.Response().Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(limited.RetryAfter.Seconds()))))
+	}
+	return c.JSON(http.StatusTooManyRequests, echo.Map{"error": "Too many attempts, try again later"})
+}
+
+// GetProfile returns the authenticated user's role, organization and permissions
+func GetProfile(c echo.Context) error {
+	var user models.User
+	if err := db.GetDB().First(&user, currentUserID(c)).Error; err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
+	}
+
+	profile, err := services.LoadProfile(db.GetDB(), &user)
+	if err != nil {
+		log.Printf("GetProfile - Error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not load profile"})
+	}
+	return c.JSON(http.StatusOK, profile)
+}
+
+// RefreshToken exchanges a refresh token for a new access and refresh token
+func RefreshToken(c echo.Context) error {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil || input.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "refresh_token is required"})
+	}
+
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		log.Printf("RefreshToken - Begin error: %v", tx.Error)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	pair, err := services.RotateRefreshToken(tx, input.RefreshToken)
+	switch {
+	case errors.Is(err, services.ErrRefreshTokenReused):
+		// Keep the revocation of the user's other sessions
+		if err := tx.Commit().Error; err != nil {
+			log.Printf("RefreshToken - Commit error: %v", err)
+		}
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid refresh token"})
+	case errors.Is(err, services.ErrInvalidRefreshToken):
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid refresh token"})
+	case errors.Is(err, services.ErrUserInactive):
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "User is inactive"})
+	case errors.Is(err, services.ErrAccountLocked):
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "Account is temporarily locked after too many failed logins"})
+	case errors.Is(err, services.ErrOrganizationInactive):
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "Organization is deactivated"})
+	case errors.Is(err, services.ErrMFARequired):
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "Two-factor authentication is required, log in again to enroll"})
+	case err != nil:
+		log.Printf("RefreshToken - Rotate error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not refresh token"})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("RefreshToken - Commit error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not refresh token"})
+	}
+
+	return c.JSON(http.StatusOK, pair)
+}
+
+// GetJWKS publishes the public keys tokens are signed with so other services can verify them
+func GetJWKS(c echo.Context) error {
+	return c.JSON(http.StatusOK, echo.Map{"keys": utils.JWKS()})
+}
+
+// passwordCheckResponse answers a failed password check of a signed-in user
+func passwordCheckResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials):
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid password"})
+	case errors.Is(err, services.ErrAccountLocked):
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "Account is temporarily locked after too many failed logins"})
+	default:
+		log.Printf("Password check error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+}
+
+// ChangePassword changes the authenticated user's password, signs out every
+// existing session and returns a fresh token pair
+func ChangePassword(c echo.Context) error {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Error decoding JSON"})
+	}
+	if input.NewPassword == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "new_password is required"})
+	}
+
+	var user models.User
+	if err := db.GetDB().First(&user, currentUserID(c)).Error; err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
+	}
+	if err := services.CheckPassword(db.GetDB(), &user, input.CurrentPassword); err != nil {
+		return passwordCheckResponse(c, err)
+	}
+
+	hashedPassword, err := utils.HashPassword(input.NewPassword)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not hash password"})
+	}
+
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	if err := tx.Model(&user).Update("password", hashedPassword).Error; err != nil {
+		log.Printf("ChangePassword - Update error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not change password"})
+	}
+	if err := services.RevokeUserTokens(tx, user.ID); err != nil {
+		log.Printf("ChangePassword - Revoke error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not change password"})
+	}
+	pair, err := services.IssueTokens(tx, &user)
+	if err != nil {
+		log.Printf("ChangePassword - IssueTokens error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not generate token"})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not change password"})
+	}
+
+	log.Printf("ChangePassword - Password changed for UserID %d", user.ID)
+	return c.JSON(http.StatusOK, pair)
+}
+
+// Logout revokes the access token that authenticated the request and the
+// refresh token in the body, if one is sent
+func Logout(c echo.Context) error {
+	claims, ok := c.Get("claims").(*utils.Claims)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
+	}
+
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Error decoding JSON"})
+	}
+
+	if err := services.RevokeAccessToken(db.GetDB(), claims, input.RefreshToken); err != nil {
+		log.Printf("Logout - Revoke error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not log out"})
+	}
+	log.Printf("Logout - Revoked token for UserID %d", claims.UserID)
+	return c.JSON(http.StatusOK, echo.Map{"message": "Successfully logged out"})
+}
+
+// issueLoginTokens signs in a user who has just been created and returns the
+// same response as Login
+func issueLoginTokens(c echo.Context, user *models.User) error {
+	pair, err := services.IssueTokens(db.GetDB(), user)
+	if err != nil {
+		log.Printf("IssueTokens error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not generate token"})
+	}
+	profile, err := services.LoadProfile(db.GetDB(), user)
+	if err != nil {
+		log.Printf("LoadProfile error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not load profile"})
+	}
+	return c.JSON(http.StatusOK, services.LoginResult{TokenPair: pair, Profile: profile})
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"stock/db"
+	"stock/listing"
 	models "stock/models"
 )
 
@@ -17,18 +18,19 @@ func GetCategories(c echo.Context) error {
 	// Get the database connection
 	db := db.GetDB()
 
-	// Query all categories from the Categories table
+	// Query a page of categories from the Categories table
 	var categories []models.Category
-	if err := db.Find(&categories).Error; err != nil {
-		log.Printf("Error querying categories from database: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
-	}
-
-	// Log the number of Categories fetched
-	log.Printf("Fetched %d categories", len(categories))
+	return listResponse(c, db.Model(&models.Category{}).Scopes(orgScope(c)), categoryListing, &categories)
+}
 
-	// Return the fetched Categories as JSON
-	return c.JSON(http.StatusOK, categories)
+var categoryListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"category_id":   {Column: "category_id", Kind: listing.Int, Sort: true},
+		"category_name": {Column: "category_name", Filter: true, Sort: true},
+		"product_name":  {Column: "product_name", Filter: true},
+	},
+	Key:         "category_id",
+	DefaultSort: "category_id",
 }
 
 func GetCategoryByID(c echo.Context) error {
@@ -45,7 +47,7 @@ func GetCategoryByID(c echo.Context) error {
 
 	// Query the category from the Categories table
 	var category models.Category
-	if err := db.First(&category, categoryID).Error; err != nil {
+	if err := db.Scopes(orgScope(c)).Where("category_id = ?", categoryID).First(&category).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			log.Printf("Category with ID %s not found", categoryID)
 			return c.JSON(http.StatusNotFound, map[string]string{"error": "Category not found"})
@@ -72,6 +74,10 @@ func CreateCategories(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Error decoding JSON")
 	}
 	log.Printf("Received request to create a category: %+v", category)
+	category.OrganizationID = currentOrganizationID(c)
+	if err := checkTaxCode(c, category.TaxCode); err != nil {
+		return err
+	}
 
 	// Execute the SQL INSERT query to add the category to the database
 	if err := db.Create(&category).Error; err != nil {
@@ -102,9 +108,12 @@ func UpdateCategory(c echo.Context) error {
 		log.Printf("Error binding payload: %s", err.Error())
 		return echo.NewHTTPError(http.StatusBadRequest, "Error binding payload")
 	}
+	if err := checkTaxCode(c, category.TaxCode); err != nil {
+		return err
+	}
 
 	// Execute the SQL UPDATE query to update the category in the database
-	if err := db.Model(&models.Category{}).Where("category_id = ?", categoryID).Updates(category).Error; err != nil {
+	if err := db.Model(&models.Category{}).Scopes(orgScope(c)).Where("category_id = ?", categoryID).Omit("organization_id").Updates(category).Error; err != nil {
 		log.Printf("Error updating a category: %s", err.Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, "Error updating category")
 	}
@@ -123,7 +132,7 @@ func DeleteCategoryByID(c echo.Context) error {
 	db := db.GetDB()
 
 	// Delete the category
-	result := db.Delete(&models.Category{}, categoryID)
+	result := db.Scopes(orgScope(c)).Where("category_id = ?", categoryID).Delete(&models.Category{})
 	if result.Error != nil {
 		log.Printf("Error deleting category: %s", result.Error.Error())
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
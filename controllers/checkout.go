@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	models "stock/models"
+	"stock/services"
+	"strconv"
+)
+
+// checkoutErrorResponse maps checkout errors to HTTP responses, listing short lines when stock is insufficient
+func checkoutErrorResponse(c echo.Context, err error) error {
+	var shortErr *services.InsufficientStockError
+	var paymentShortErr *services.PaymentShortError
+	var declinedErr *services.PaymentDeclinedError
+	switch {
+	case errors.As(err, &shortErr):
+		log.Printf("Checkout rejected: %s", err.Error())
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Insufficient quantity",
+			"lines": shortErr.Lines,
+		})
+	case errors.Is(err, services.ErrCheckoutAlreadyHandled):
+		return errorResponse(c, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrInvalidIdempotencyKey):
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrEmptyBasket):
+		return errorResponse(c, http.StatusBadRequest, "Basket must contain at least one line")
+	case errors.Is(err, services.ErrInvalidQuantity):
+		return errorResponse(c, http.StatusBadRequest, "Quantity must be greater than zero")
+	case errors.Is(err, services.ErrInvalidCoupon), errors.Is(err, services.ErrInvalidPrice),
+		errors.Is(err, services.ErrConflictingPrices):
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrApprovalRequired), errors.Is(err, services.ErrApprovalInvalid):
+		return errorResponse(c, http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrTaxRateNotFound):
+		return errorResponse(c, http.StatusConflict, "A product's tax code has no tax rate")
+	case errors.As(err, &paymentShortErr):
+		return c.JSON(http.StatusPaymentRequired, map[string]interface{}{
+			"error": "Payments do not cover the total",
+			"total": paymentShortErr.Total,
+			"paid":  paymentShortErr.Paid,
+		})
+	case errors.As(err, &declinedErr):
+		log.Printf("Checkout payment failed: %s", err.Error())
+		return errorResponse(c, http.StatusPaymentRequired, err.Error())
+	case errors.Is(err, services.ErrStoreCreditInsufficient):
+		return errorResponse(c, http.StatusPaymentRequired, err.Error())
+	case errors.Is(err, services.ErrInvalidPayment), errors.Is(err, services.ErrOverpaid):
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrStoreCreditNotFound):
+		return errorResponse(c, http.StatusNotFound, "Store credit not found")
+	case errors.Is(err, services.ErrCustomerNotFound):
+		return errorResponse(c, http.StatusNotFound, "Customer not found")
+	case errors.Is(err, services.ErrCustomerInactive), errors.Is(err, services.ErrCustomerRequired):
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrCreditLimitExceeded):
+		return errorResponse(c, http.StatusPaymentRequired, err.Error())
+	default:
+		return stockErrorResponse(c, err)
+	}
+}
+
+// Checkout sells a basket of products under a single receipt. The seller is
+// the authenticated user. A retry with the idempotency key of a checkout that
+// went through returns its receipt instead of selling the basket again.
+func Checkout(c echo.Context) error {
+	var input services.Basket
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		log.Printf("Error decoding JSON: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	if input.IdempotencyKey != "" {
+		receipt, err := services.FindCheckout(db, currentOrganizationID(c), input.IdempotencyKey)
+		if err != nil {
+			log.Printf("Error querying receipt by idempotency key: %s", err.Error())
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+		if receipt != nil {
+			return c.JSON(http.StatusOK, receipt)
+		}
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		log.Printf("Error starting transaction: %s", tx.Error.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	receipt, err := services.Checkout(tx, currentOrganizationID(c), currentUserID(c), input)
+	if err != nil {
+		return checkoutErrorResponse(c, err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction: %s", err.Error())
+		services.CancelPayments(receipt)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	services.TriggerReorderEvaluation()
+
+	log.Printf("Checked out receipt ID %d with %d lines, total %s", receipt.ReceiptID, len(receipt.Lines), receipt.TotalAmount)
+	return c.JSON(http.StatusCreated, receipt)
+}
+
+// GetReceiptByID fetches a receipt together with its sale lines, payments and
+// any invoice for what was put on account
+func GetReceiptByID(c echo.Context) error {
+	receiptID, err := strconv.Atoi(c.Param("receipt_id"))
+	if err != nil {
+		log.Printf("Invalid receipt ID: %s", c.Param("receipt_id"))
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid receipt ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var receipt models.Receipt
+	if err := db.Preload("Lines").Preload("Payments").Preload("Invoice").Scopes(orgScope(c)).Where("receipt_id = ?", receiptID).First(&receipt).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Printf("Receipt not found with ID: %d", receiptID)
+			return echo.NewHTTPError(http.StatusNotFound, "Receipt not found")
+		}
+		log.Printf("Error querying receipt: %s", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch receipt")
+	}
+
+	return c.JSON(http.StatusOK, receipt)
+}
@@ -0,0 +1,343 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"stock/listing"
+	"stock/models"
+	"stock/services"
+	"strconv"
+	"time"
+)
+
+// customerErrorResponse maps customer and invoice errors to HTTP errors
+func customerErrorResponse(c echo.Context, err error) error {
+	var declinedErr *services.PaymentDeclinedError
+	switch {
+	case errors.Is(err, services.ErrCustomerNotFound):
+		return errorResponse(c, http.StatusNotFound, "Customer not found")
+	case errors.Is(err, services.ErrInvoiceNotFound):
+		return errorResponse(c, http.StatusNotFound, "Invoice not found")
+	case errors.Is(err, services.ErrInvalidCustomer), errors.Is(err, services.ErrInvalidInvoicePayment),
+		errors.Is(err, services.ErrInvoiceOverpaid):
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrInvoicePaid):
+		return errorResponse(c, http.StatusConflict, "Invoice is already paid")
+	case errors.As(err, &declinedErr):
+		log.Printf("Invoice payment failed: %s", err.Error())
+		return errorResponse(c, http.StatusPaymentRequired, err.Error())
+	default:
+		log.Printf("Customer error: %s", err.Error())
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+// GetCustomers lists the organization's customers
+func GetCustomers(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var customers []models.Customer
+	return listResponse(c, db.Model(&models.Customer{}).Scopes(orgScope(c)), customerListing, &customers)
+}
+
+var customerListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":        {Column: "id", Kind: listing.Int, Sort: true},
+		"name":      {Column: "name", Filter: true, Sort: true},
+		"email":     {Column: "email", Filter: true},
+		"phone":     {Column: "phone", Filter: true},
+		"is_active": {Column: "is_active", Kind: listing.Bool, Filter: true},
+	},
+	Key:         "id",
+	DefaultSort: "name",
+}
+
+// GetCustomer returns a customer with what they owe
+func GetCustomer(c echo.Context) error {
+	customerID, err := strconv.Atoi(c.Param("customer_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid customer ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	customer, err := services.FindCustomer(db, currentOrganizationID(c), uint(customerID))
+	if err != nil {
+		return customerErrorResponse(c, err)
+	}
+	balance, err := services.CustomerBalance(db, customer.OrganizationID, customer.ID)
+	if err != nil {
+		return customerErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, struct {
+		*models.Customer
+		Balance models.Money `json:"balance"`
+	}{customer, balance})
+}
+
+// CreateCustomer adds a customer. Payment terms default to
+// services.DefaultPaymentTermsDays.
+func CreateCustomer(c echo.Context) error {
+	var customer models.Customer
+	if err := json.NewDecoder(c.Request().Body).Decode(&customer); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	customer.ID = 0
+	customer.OrganizationID = currentOrganizationID(c)
+	customer.IsActive = true
+	if err := services.SaveCustomer(tx, &customer); err != nil {
+		return customerErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditCustomerCreated, "customer", customer.ID, nil, customer); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error inserting customer")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error inserting customer")
+	}
+
+	log.Printf("Created customer %q with ID %d", customer.Name, customer.ID)
+	return c.JSON(http.StatusCreated, customer)
+}
+
+// UpdateCustomer replaces a customer's details. Setting is_active to false
+// stops new sales to them while keeping their history.
+func UpdateCustomer(c echo.Context) error {
+	customerID, err := strconv.Atoi(c.Param("customer_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid customer ID")
+	}
+
+	var input models.Customer
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	customer, err := services.LockCustomer(tx, currentOrganizationID(c), uint(customerID))
+	if err != nil {
+		return customerErrorResponse(c, err)
+	}
+	before := *customer
+	input.ID = customer.ID
+	input.OrganizationID = customer.OrganizationID
+	input.CreatedAt = customer.CreatedAt
+	*customer = input
+	if err := services.SaveCustomer(tx, customer); err != nil {
+		return customerErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditCustomerUpdated, "customer", customer.ID, before, customer); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update customer")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update customer")
+	}
+
+	log.Printf("Updated customer ID %d", customer.ID)
+	return c.JSON(http.StatusOK, customer)
+}
+
+// GetInvoices lists the organization's invoices
+func GetInvoices(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var invoices []models.Invoice
+	return listResponse(c, db.Model(&models.Invoice{}).Scopes(orgScope(c)), invoiceListing, &invoices)
+}
+
+var invoiceListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":          {Column: "id", Kind: listing.Int, Sort: true},
+		"customer_id": {Column: "customer_id", Kind: listing.Int, Filter: true},
+		"receipt_id":  {Column: "receipt_id", Kind: listing.Int, Filter: true},
+		"status":      {Column: "status", Filter: true},
+		"amount":      {Column: "amount", Kind: listing.Money, Range: true, Sort: true},
+		"issued_at":   {Column: "issued_at", Kind: listing.Time, Range: true, Sort: true},
+		"due_date":    {Column: "due_date", Kind: listing.Time, Range: true, Sort: true},
+	},
+	Key:         "id",
+	DefaultSort: "due_date",
+}
+
+// GetInvoice returns an invoice with its balance and the payments made on it
+func GetInvoice(c echo.Context) error {
+	invoiceID, err := strconv.Atoi(c.Param("invoice_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid invoice ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	invoice, err := services.FindInvoice(db, currentOrganizationID(c), uint(invoiceID))
+	if err != nil {
+		return customerErrorResponse(c, err)
+	}
+	var paid []models.InvoicePayment
+	if err := db.Where("invoice_id = ?", invoice.ID).Order("id").Find(&paid).Error; err != nil {
+		return customerErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, struct {
+		*models.Invoice
+		Balance  models.Money            `json:"balance"`
+		Payments []models.InvoicePayment `json:"payments"`
+	}{invoice, invoice.Balance(), paid})
+}
+
+// PayInvoice records a customer's payment towards an invoice
+func PayInvoice(c echo.Context) error {
+	invoiceID, err := strconv.Atoi(c.Param("invoice_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid invoice ID")
+	}
+
+	var input services.PaymentInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	invoice, err := services.LockInvoice(tx, currentOrganizationID(c), uint(invoiceID))
+	if err != nil {
+		return customerErrorResponse(c, err)
+	}
+	payment, err := services.PayInvoice(tx, invoice, input, currentUserID(c))
+	if err != nil {
+		return customerErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditInvoicePaid, "invoice", invoice.ID, nil, payment); err != nil {
+		services.CancelInvoicePayment(invoice, payment)
+		return errorResponse(c, http.StatusInternalServerError, "Failed to record payment")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		services.CancelInvoicePayment(invoice, payment)
+		return errorResponse(c, http.StatusInternalServerError, "Failed to record payment")
+	}
+
+	log.Printf("Recorded %s payment of %s on invoice %d, balance %s", payment.Tender, payment.Amount, invoice.ID, invoice.Balance())
+	return c.JSON(http.StatusCreated, echo.Map{
+		"payment": payment,
+		"invoice": invoice,
+		"balance": invoice.Balance(),
+	})
+}
+
+// GetCustomerStatement returns a customer's account over a period, as JSON or,
+// with format=csv, as a CSV download. It takes the same from, to and tz
+// parameters as the reports.
+func GetCustomerStatement(c echo.Context) error {
+	customerID, err := strconv.Atoi(c.Param("customer_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid customer ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	period, err := reportPeriod(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	}
+	customer, err := services.FindCustomer(db, currentOrganizationID(c), uint(customerID))
+	if err != nil {
+		return customerErrorResponse(c, err)
+	}
+	statement, err := services.CustomerStatement(db, customer, period)
+	if err != nil {
+		return customerErrorResponse(c, err)
+	}
+
+	from := period.From.Format("2006-01-02")
+	to := period.To.AddDate(0, 0, -1).Format("2006-01-02")
+	if c.QueryParam("format") != "csv" {
+		return c.JSON(http.StatusOK, echo.Map{
+			"from":            from,
+			"to":              to,
+			"time_zone":       period.Location.String(),
+			"customer":        statement.Customer,
+			"opening_balance": statement.OpeningBalance,
+			"lines":           statement.Lines,
+			"closing_balance": statement.ClosingBalance,
+		})
+	}
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	response.Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=\"statement-%d-%s-%s.csv\"", customer.ID, from, to))
+	response.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(response)
+	rows := [][]string{
+		{"date", "kind", "invoice_id", "reference", "debit", "credit", "balance"},
+		{from, "opening_balance", "", "", "", "", statement.OpeningBalance.String()},
+	}
+	for _, line := range statement.Lines {
+		rows = append(rows, []string{
+			line.Date.In(period.Location).Format(time.RFC3339),
+			line.Kind,
+			strconv.Itoa(int(line.InvoiceID)),
+			line.Reference,
+			line.Debit.String(),
+			line.Credit.String(),
+			line.Balance.String(),
+		})
+	}
+	rows = append(rows, []string{to, "closing_balance", "", "", "", "", statement.ClosingBalance.String()})
+	if err := w.WriteAll(rows); err != nil {
+		log.Printf("Error writing statement CSV: %s", err.Error())
+	}
+	return nil
+}
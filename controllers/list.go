@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"stock/listing"
+)
+
+// listResponse responds with one page of query in the shared listing
+// envelope, reading the paging, sorting and filter parameters from the request
+func listResponse(c echo.Context, query *gorm.DB, spec listing.Spec, dest interface{}) error {
+	page, err := listing.Find(query, spec, c.QueryParams(), dest)
+	if err != nil {
+		return listError(c, err)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// listError responds to a failed listing: 400 for a bad parameter, 500 otherwise
+func listError(c echo.Context, err error) error {
+	var paramErr *listing.ParamError
+	if errors.As(err, &paramErr) {
+		return errorResponse(c, http.StatusBadRequest, paramErr.Error())
+	}
+	log.Printf("Error listing %s: %v", c.Path(), err)
+	return errorResponse(c, http.StatusInternalServerError, "Failed to fetch records")
+}
+
+// userListing is shared by the user lists of platform and organization admins
+var userListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":              {Column: "id", Kind: listing.Int, Sort: true},
+		"username":        {Column: "username", Filter: true, Sort: true},
+		"email":           {Column: "email", Filter: true},
+		"role_id":         {Column: "role_id", Kind: listing.Int, Filter: true},
+		"organization_id": {Column: "organization_id", Kind: listing.Int, Filter: true},
+		"is_active":       {Column: "is_active", Kind: listing.Bool, Filter: true},
+		"created_at":      {Column: "created_at", Kind: listing.Time, Range: true, Sort: true},
+	},
+	Key:         "id",
+	DefaultSort: "id",
+}
+
+var organizationListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":         {Column: "id", Kind: listing.Int, Sort: true},
+		"name":       {Column: "name", Filter: true, Sort: true},
+		"city":       {Column: "city", Filter: true},
+		"state":      {Column: "state", Filter: true},
+		"country":    {Column: "country", Filter: true},
+		"is_active":  {Column: "is_active", Kind: listing.Bool, Filter: true},
+		"created_at": {Column: "created_at", Kind: listing.Time, Range: true, Sort: true},
+	},
+	Key:         "id",
+	DefaultSort: "id",
+}
@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"stock/db"
+	"stock/models"
+	"stock/services"
+)
+
+// mfaErrorResponse maps two-factor errors to HTTP errors
+func mfaErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrMFAChallengeInvalid):
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Two-factor challenge is invalid or expired"})
+	case errors.Is(err, services.ErrMFAInvalidCode):
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid two-factor code"})
+	case errors.Is(err, services.ErrUserInactive):
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "User is inactive"})
+	case errors.Is(err, services.ErrAccountLocked):
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "Account is temporarily locked after too many failed logins"})
+	case errors.Is(err, services.ErrMFANotStarted):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Two-factor enrollment has not been started"})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Two-factor authentication is already enabled"})
+	case errors.Is(err, services.ErrMFANotEnabled):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Two-factor authentication is not enabled"})
+	case errors.Is(err, services.ErrMFARequired):
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "Two-factor authentication is required for your role"})
+	case errors.Is(err, services.ErrRoleNotFound):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Unknown role"})
+	default:
+		log.Printf("MFA error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+}
+
+type mfaInput struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	Password     string `json:"password"`
+}
+
+func decodeMFAInput(c echo.Context) (*mfaInput, error) {
+	var input mfaInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return nil, c.JSON(http.StatusBadRequest, echo.Map{"error": "Error decoding JSON"})
+	}
+	return &input, nil
+}
+
+// VerifyLogin completes a login with a TOTP or recovery code
+func VerifyLogin(c echo.Context) error {
+	input, err := decodeMFAInput(c)
+	if input == nil {
+		return err
+	}
+
+	challenge, user, err := services.LoadMFAChallenge(db.GetDB(), input.MFAToken, models.MFAChallengeVerify)
+	if err != nil {
+		return mfaErrorResponse(c, err)
+	}
+
+	if err := services.VerifySecondFactor(db.GetDB(), user, input.Code, input.RecoveryCode); err != nil {
+		if errors.Is(err, services.ErrMFAInvalidCode) {
+			if err := services.FailMFAChallenge(db.GetDB(), challenge); err != nil {
+				log.Printf("VerifyLogin - FailMFAChallenge error: %v", err)
+			}
+		}
+		return mfaErrorResponse(c, err)
+	}
+	if err := services.CompleteMFAChallenge(db.GetDB(), challenge); err != nil {
+		return mfaErrorResponse(c, err)
+	}
+
+	result, err := services.CompleteLogin(db.GetDB(), user)
+	if err != nil {
+		log.Printf("VerifyLogin - CompleteLogin error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not log in"})
+	}
+
+	log.Printf("VerifyLogin - UserID %d logged in with two-factor authentication", user.ID)
+	return c.JSON(http.StatusOK, result)
+}
+
+// BeginLoginEnrollment starts enrollment for a user whose role requires
+// two-factor authentication before they can sign in
+func BeginLoginEnrollment(c echo.Context) error {
+	input, err := decodeMFAInput(c)
+	if input == nil {
+		return err
+	}
+
+	_, user, err := services.LoadMFAChallenge(db.GetDB(), input.MFAToken, models.MFAChallengeEnroll)
+	if err != nil {
+		return mfaErrorResponse(c, err)
+	}
+
+	enrollment, err := services.BeginMFAEnrollment(db.GetDB(), user)
+	if err != nil {
+		return mfaErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmLoginEnrollment confirms enrollment with a first code and signs the user in
+func ConfirmLoginEnrollment(c echo.Context) error {
+	input, err := decodeMFAInput(c)
+	if input == nil {
+		return err
+	}
+
+	challenge, user, err := services.LoadMFAChallenge(db.GetDB(), input.MFAToken, models.MFAChallengeEnroll)
+	if err != nil {
+		return mfaErrorResponse(c, err)
+	}
+
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	codes, err := services.ConfirmMFAEnrollment(tx, user, input.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrMFAInvalidCode) {
+			if err := services.FailMFAChallenge(db.GetDB(), challenge); err != nil {
+				log.Printf("ConfirmLoginEnrollment - FailMFAChallenge error: %v", err)
+			}
+		}
+		return mfaErrorResponse(c, err)
+	}
+	if err := services.CompleteMFAChallenge(tx, challenge); err != nil {
+		return mfaErrorResponse(c, err)
+	}
+	result, err := services.CompleteLogin(tx, user)
+	if err != nil {
+		log.Printf("ConfirmLoginEnrollment - CompleteLogin error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not log in"})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not enable two-factor authentication"})
+	}
+
+	log.Printf("ConfirmLoginEnrollment - UserID %d enrolled in two-factor authentication", user.ID)
+	return c.JSON(http.StatusOK, echo.Map{
+		"token":          result.AccessToken,
+		"refresh_token":  result.RefreshToken,
+		"expires_in":     result.ExpiresIn,
+		"profile":        result.Profile,
+		"recovery_codes": codes,
+	})
+}
+
+// currentUser loads the user authenticated by AuthMiddleware
+func currentUser(c echo.Context) (*models.User, error) {
+	var user models.User
+	if err := db.GetDB().First(&user, currentUserID(c)).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// BeginMFAEnrollment returns a new secret and provisioning URI for the
+// authenticated user's authenticator app
+func BeginMFAEnrollment(c echo.Context) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
+	}
+
+	enrollment, err := services.BeginMFAEnrollment(db.GetDB(), user)
+	if err != nil {
+		return mfaErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmMFAEnrollment enables two-factor authentication with a first code
+// and returns the recovery codes
+func ConfirmMFAEnrollment(c echo.Context) error {
+	input, err := decodeMFAInput(c)
+	if input == nil {
+		return err
+	}
+	user, err := currentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
+	}
+
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	codes, err := services.ConfirmMFAEnrollment(tx, user, input.Code)
+	if err != nil {
+		return mfaErrorResponse(c, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not enable two-factor authentication"})
+	}
+
+	log.Printf("ConfirmMFAEnrollment - UserID %d enabled two-factor authentication", user.ID)
+	return c.JSON(http.StatusOK, echo.Map{"recovery_codes": codes})
+}
+
+// DisableMFA turns two-factor authentication off after checking the password
+// and a current code
+func DisableMFA(c echo.Context) error {
+	input, err := decodeMFAInput(c)
+	if input == nil {
+		return err
+	}
+	user, err := currentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
+	}
+	if err := services.CheckPassword(db.GetDB(), user, input.Password); err != nil {
+		return passwordCheckResponse(c, err)
+	}
+	if err := services.CheckSecondFactor(db.GetDB(), user, input.Code, input.RecoveryCode); err != nil {
+		return mfaErrorResponse(c, err)
+	}
+
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	if err := services.DisableMFA(tx, user); err != nil {
+		return mfaErrorResponse(c, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not disable two-factor authentication"})
+	}
+
+	log.Printf("DisableMFA - UserID %d disabled two-factor authentication", user.ID)
+	return c.JSON(http.StatusOK, echo.Map{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the authenticated user's recovery codes after checking a current code
+func RegenerateRecoveryCodes(c echo.Context) error {
+	input, err := decodeMFAInput(c)
+	if input == nil {
+		return err
+	}
+	user, err := currentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
+	}
+	if err := services.CheckSecondFactor(db.GetDB(), user, input.Code, ""); err != nil {
+		return mfaErrorResponse(c, err)
+	}
+
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	codes, err := services.RegenerateRecoveryCodes(tx, user.ID)
+	if err != nil {
+		return mfaErrorResponse(c, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not regenerate recovery codes"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"recovery_codes": codes})
+}
+
+// GetMFAPolicy lists the roles the organization requires two-factor authentication for
+func GetMFAPolicy(c echo.Context) error {
+	var roleIDs []uint
+	if err := db.GetDB().Model(&models.MFARequiredRole{}).Scopes(orgScope(c)).
+		Order("role_id").Pluck("role_id", &roleIDs).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not load two-factor policy"})
+	}
+	if roleIDs == nil {
+		roleIDs = []uint{}
+	}
+	return c.JSON(http.StatusOK, echo.Map{"required_role_ids": roleIDs})
+}
+
+// UpdateMFAPolicy replaces the roles the organization requires two-factor authentication for
+func UpdateMFAPolicy(c echo.Context) error {
+	var input struct {
+		RequiredRoleIDs []uint `json:"required_role_ids"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Error decoding JSON"})
+	}
+
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	if err := services.SetMFARequiredRoles(tx, currentOrganizationID(c), input.RequiredRoleIDs); err != nil {
+		return mfaErrorResponse(c, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not update two-factor policy"})
+	}
+
+	log.Printf("UpdateMFAPolicy - Organization %d requires two-factor authentication for roles %v", currentOrganizationID(c), input.RequiredRoleIDs)
+	return GetMFAPolicy(c)
+}
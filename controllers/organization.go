@@ -1,65 +1,30 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/labstack/echo/v4"
-	"golang.org/x/crypto/bcrypt"
 	"log"
 	"net/http"
 	"stock/db"
+	"stock/mailer"
 	"stock/models"
+	"stock/services"
 	"stock/utils"
 	"time"
 )
 
-func OrganizationAdminLogin(c echo.Context) error {
-	var user models.User
-	if err := c.Bind(&user); err != nil {
-		return err
-	}
-
-	var storedUser models.User
-	if err := db.GetDB().Where("username = ?", user.Username).First(&storedUser).Error; err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid credentials"})
-	}
-
-	if err := bcrypt.CompareHashAndPassword([]byte(storedUser.Password), []byte(user.Password)); err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid credentials"})
-	}
-
-	token, err := utils.GenerateJWT(storedUser.ID, storedUser.RoleID)
-	if err != nil {
-		return err
-	}
-
-	return c.JSON(http.StatusOK, map[string]string{"token": token})
-}
-
-func OrganizationAdminLogout(c echo.Context) error {
-	// Clear token or handle session invalidation if necessary
-	return c.JSON(http.StatusOK, map[string]string{"message": "Logged out successfully"})
-}
-
 func OrganizationAdminAddUser(c echo.Context) error {
 	log.Println("OrganizationAdminAddUser called")
 
-	roleID, ok := c.Get("roleID").(int)
-	if !ok {
-		log.Println("Failed to get roleID from context")
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
-	}
-
 	userID, ok := c.Get("userID").(int)
 	if !ok {
 		log.Println("Failed to get userID from context")
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
 	}
 
-	log.Printf("Received RoleID: %d, UserID: %d", roleID, userID)
-
-	if roleID != 6 {
-		log.Println("Permission denied: non-organization admin trying to add user")
-		return c.JSON(http.StatusForbidden, echo.Map{"error": "Permission denied"})
-	}
+	orgID := currentOrganizationID(c)
+	log.Printf("Received UserID: %d, OrganizationID: %d", userID, orgID)
 
 	var newUser models.User
 	if err := c.Bind(&newUser); err != nil {
@@ -69,8 +34,8 @@ func OrganizationAdminAddUser(c echo.Context) error {
 
 	log.Printf("New user data: %+v", newUser)
 
-	if newUser.RoleID != 7 && newUser.RoleID != 8 {
-		log.Println("Invalid role ID")
+	if err := services.AssignableRole(db.GetDB(), orgID, newUser.RoleID); err != nil {
+		log.Printf("Invalid role ID %d: %v", newUser.RoleID, err)
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid role ID"})
 	}
 
@@ -80,48 +45,81 @@ func OrganizationAdminAddUser(c echo.Context) error {
 		return c.JSON(http.StatusConflict, echo.Map{"error": "Username already exists"})
 	}
 
-	hashedPassword, err := utils.HashPassword(newUser.Password)
-	if err != nil {
-		log.Printf("HashPassword error: %v", err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not hash password"})
-	}
-	newUser.Password = hashedPassword
+	// Without a password the user is invited to choose one
+	invite := newUser.Password == ""
+	if invite {
+		if err := services.InviteUser(&newUser); err != nil {
+			return invitationErrorResponse(c, err)
+		}
+	} else {
+		hashedPassword, err := utils.HashPassword(newUser.Password)
+		if err != nil {
+			log.Printf("HashPassword error: %v", err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not hash password"})
+		}
+		newUser.Password = hashedPassword
+	}
+	newUser.OrganizationID = orgID
 	newUser.CreatedBy = uint(userID) // Convert userID to uint
 
 	log.Printf("Saving new user to database")
 
-	if err := db.GetDB().Create(&newUser).Error; err != nil {
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	if err := tx.Create(&newUser).Error; err != nil {
 		log.Printf("Create error: %v", err)
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditUserCreated, "user", newUser.ID, nil, newUser); err != nil {
+		log.Printf("Audit error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not add user"})
+	}
+	var invitation *mailer.Message
+	if invite {
+		msg, err := services.CreateInvitation(tx, &newUser, uint(userID))
+		if err != nil {
+			return invitationErrorResponse(c, err)
+		}
+		invitation = msg
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not add user"})
+	}
+
+	if err := services.SendAccountEmail(invitation); err != nil {
+		return invitationEmailFailed(c, err)
+	}
 
 	log.Println("User added successfully")
+	if invite {
+		return c.JSON(http.StatusCreated, echo.Map{"message": "User invited successfully", "user": newUser})
+	}
 	return c.JSON(http.StatusCreated, echo.Map{"message": "User added successfully", "user": newUser})
 }
 
 func OrganizationAdminEditUser(c echo.Context) error {
 	log.Println("OrganizationAdminEditUser called")
 
-	roleID, ok := c.Get("roleID").(int)
-	if !ok {
-		log.Println("Failed to get roleID from context")
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
-	}
-
 	userID, ok := c.Get("userID").(int)
 	if !ok {
 		log.Println("Failed to get userID from context")
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
 	}
 
-	log.Printf("Received RoleID: %d, UserID: %d", roleID, userID)
+	orgID := currentOrganizationID(c)
+	log.Printf("Received UserID: %d, OrganizationID: %d", userID, orgID)
 
-	if roleID != 6 {
-		log.Println("Permission denied: non-organization admin trying to edit user")
-		return c.JSON(http.StatusForbidden, echo.Map{"error": "Permission denied"})
+	userIDParam := c.Param("id")
+	var before models.User
+	if err := db.GetDB().Where("id = ? AND organization_id = ?", userIDParam, orgID).First(&before).Error; err != nil {
+		log.Printf("Find error: %v", err)
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
 	}
 
-	userIDParam := c.Param("id")
 	var user models.User
 	if err := c.Bind(&user); err != nil {
 		log.Printf("Bind error: %v", err)
@@ -130,11 +128,44 @@ func OrganizationAdminEditUser(c echo.Context) error {
 
 	log.Printf("Updating user data: %+v", user)
 
-	if err := db.GetDB().Model(&models.User{}).Where("id = ? AND organization_id = ?", userIDParam, userID).Updates(user).Error; err != nil {
+	if user.RoleID != 0 {
+		if err := services.AssignableRole(db.GetDB(), orgID, user.RoleID); err != nil {
+			log.Printf("Invalid role ID %d: %v", user.RoleID, err)
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid role ID"})
+		}
+	}
+	user.UpdatedBy = uint(userID)
+
+	// A new password is hashed and signs the user out everywhere
+	passwordChanged := user.Password != ""
+	if passwordChanged {
+		hashedPassword, err := utils.HashPassword(user.Password)
+		if err != nil {
+			log.Printf("HashPassword error: %v", err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not hash password"})
+		}
+		user.Password = hashedPassword
+	}
+
+	if err := db.GetDB().Model(&models.User{}).Where("id = ? AND organization_id = ?", userIDParam, orgID).Omit("id", "organization_id").Updates(user).Error; err != nil {
 		log.Printf("Update error: %v", err)
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
 
+	if passwordChanged {
+		if err := services.RevokeUserTokens(db.GetDB(), before.ID); err != nil {
+			log.Printf("RevokeUserTokens error: %v", err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not revoke user tokens"})
+		}
+	}
+
+	var after models.User
+	if err := db.GetDB().First(&after, before.ID).Error; err != nil {
+		log.Printf("Find error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	services.RecordAuditLogged(db.GetDB(), auditActor(c), models.AuditUserUpdated, "user", before.ID, before, after)
+
 	log.Println("User updated successfully")
 	return c.JSON(http.StatusOK, echo.Map{"message": "User updated successfully"})
 }
@@ -142,60 +173,34 @@ func OrganizationAdminEditUser(c echo.Context) error {
 func OrganizationAdminGetUsers(c echo.Context) error {
 	log.Println("OrganizationAdminGetUsers called")
 
-	roleID, ok := c.Get("roleID").(int)
-	if !ok {
-		log.Println("Failed to get roleID from context")
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
-	}
-
 	userID, ok := c.Get("userID").(int)
 	if !ok {
 		log.Println("Failed to get userID from context")
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
 	}
 
-	log.Printf("Received RoleID: %d, UserID: %d", roleID, userID)
-
-	if roleID != 6 {
-		log.Println("Permission denied: non-organization admin trying to view users")
-		return c.JSON(http.StatusForbidden, echo.Map{"error": "Permission denied"})
-	}
+	orgID := currentOrganizationID(c)
+	log.Printf("Received UserID: %d, OrganizationID: %d", userID, orgID)
 
 	var users []models.User
-	if err := db.GetDB().Where("organization_id = ?", userID).Find(&users).Error; err != nil {
-		log.Printf("Find error: %v", err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
-	}
-
-	log.Println("Users retrieved successfully")
-	return c.JSON(http.StatusOK, users)
+	return listResponse(c, db.GetDB().Model(&models.User{}).Where("organization_id = ?", orgID), userListing, &users)
 }
 
 func OrganizationAdminGetUserByID(c echo.Context) error {
 	log.Println("OrganizationAdminGetUserByID called")
 
-	roleID, ok := c.Get("roleID").(int)
-	if !ok {
-		log.Println("Failed to get roleID from context")
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
-	}
-
 	userID, ok := c.Get("userID").(int)
 	if !ok {
 		log.Println("Failed to get userID from context")
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
 	}
 
-	log.Printf("Received RoleID: %d, UserID: %d", roleID, userID)
-
-	if roleID != 6 {
-		log.Println("Permission denied: non-organization admin trying to get user by ID")
-		return c.JSON(http.StatusForbidden, echo.Map{"error": "Permission denied"})
-	}
+	orgID := currentOrganizationID(c)
+	log.Printf("Received UserID: %d, OrganizationID: %d", userID, orgID)
 
 	userIDParam := c.Param("id")
 	var user models.User
-	if err := db.GetDB().Where("id = ? AND organization_id = ?", userIDParam, userID).First(&user).Error; err != nil {
+	if err := db.GetDB().Where("id = ? AND organization_id = ?", userIDParam, orgID).First(&user).Error; err != nil {
 		log.Printf("Find error: %v", err)
 		return c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
 	}
@@ -207,30 +212,35 @@ func OrganizationAdminGetUserByID(c echo.Context) error {
 func OrganizationAdminSoftDeleteUser(c echo.Context) error {
 	log.Println("OrganizationAdminSoftDeleteUser called")
 
-	roleID, ok := c.Get("roleID").(int)
-	if !ok {
-		log.Println("Failed to get roleID from context")
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
-	}
-
 	userID, ok := c.Get("userID").(int)
 	if !ok {
 		log.Println("Failed to get userID from context")
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
 	}
 
-	log.Printf("Received RoleID: %d, UserID: %d", roleID, userID)
+	orgID := currentOrganizationID(c)
+	log.Printf("Received UserID: %d, OrganizationID: %d", userID, orgID)
+
+	userIDParam := c.Param("id")
+	var user models.User
+	if err := db.GetDB().Where("id = ? AND organization_id = ?", userIDParam, orgID).First(&user).Error; err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
+	}
 
-	if roleID != 6 {
-		log.Println("Permission denied: non-organization admin trying to soft-delete user")
-		return c.JSON(http.StatusForbidden, echo.Map{"error": "Permission denied"})
+	result := db.GetDB().Model(&models.User{}).Where("id = ? AND organization_id = ?", userIDParam, orgID).Update("deleted_at", time.Now())
+	if result.Error != nil {
+		log.Printf("Update error: %v", result.Error)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": result.Error.Error()})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
 	}
 
-	userIDParam := c.Param("id")
-	if err := db.GetDB().Model(&models.User{}).Where("id = ? AND organization_id = ?", userIDParam, userID).Update("deleted_at", time.Now()).Error; err != nil {
-		log.Printf("Update error: %v", err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	if err := services.RevokeUserTokens(db.GetDB(), user.ID); err != nil {
+		log.Printf("RevokeUserTokens error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not revoke user tokens"})
 	}
+	services.RecordAuditLogged(db.GetDB(), auditActor(c), models.AuditUserDeleted, "user", user.ID, user, nil)
 
 	log.Println("User soft-deleted successfully")
 	return c.JSON(http.StatusOK, echo.Map{"message": "User soft-deleted successfully"})
@@ -239,38 +249,146 @@ func OrganizationAdminSoftDeleteUser(c echo.Context) error {
 func OrganizationAdminActivateDeactivateUser(c echo.Context) error {
 	log.Println("OrganizationAdminActivateDeactivateUser called")
 
-	roleID, ok := c.Get("roleID").(int)
-	if !ok {
-		log.Println("Failed to get roleID from context")
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
-	}
-
 	userID, ok := c.Get("userID").(int)
 	if !ok {
 		log.Println("Failed to get userID from context")
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Unauthorized"})
 	}
 
-	log.Printf("Received RoleID: %d, UserID: %d", roleID, userID)
-
-	if roleID != 6 {
-		log.Println("Permission denied: non-organization admin trying to activate/deactivate user")
-		return c.JSON(http.StatusForbidden, echo.Map{"error": "Permission denied"})
-	}
+	orgID := currentOrganizationID(c)
+	log.Printf("Received UserID: %d, OrganizationID: %d", userID, orgID)
 
 	userIDParam := c.Param("id")
 	var user models.User
-	if err := db.GetDB().Where("id = ? AND organization_id = ?", userIDParam, userID).First(&user).Error; err != nil {
+	if err := db.GetDB().Where("id = ? AND organization_id = ?", userIDParam, orgID).First(&user).Error; err != nil {
 		log.Printf("Find error: %v", err)
 		return c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
 	}
 
+	before := user
 	user.IsActive = !user.IsActive
 	if err := db.GetDB().Save(&user).Error; err != nil {
 		log.Printf("Save error: %v", err)
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
+	action := models.AuditUserDeactivated
+	if user.IsActive {
+		action = models.AuditUserActivated
+	}
+	services.RecordAuditLogged(db.GetDB(), auditActor(c), action, "user", user.ID, before, user)
+
+	if !user.IsActive {
+		if err := services.RevokeUserTokens(db.GetDB(), user.ID); err != nil {
+			log.Printf("RevokeUserTokens error: %v", err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not revoke user tokens"})
+		}
+	}
 
 	log.Println("User activation/deactivation updated successfully")
 	return c.JSON(http.StatusOK, echo.Map{"message": "User activation/deactivation updated successfully", "user": user})
 }
+
+// OrganizationAdminGetLockedUsers lists users of the organization locked out
+// after too many failed logins
+func OrganizationAdminGetLockedUsers(c echo.Context) error {
+	var users []models.User
+	return listResponse(c, services.LockedUsers(db.GetDB()).Model(&models.User{}).Scopes(orgScope(c)), userListing, &users)
+}
+
+// OrganizationAdminUnlockUser lifts a lockout of a user of the organization
+func OrganizationAdminUnlockUser(c echo.Context) error {
+	var user models.User
+	if err := db.GetDB().Scopes(orgScope(c)).First(&user, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
+	}
+
+	if err := services.UnlockUser(db.GetDB(), user.ID); err != nil {
+		log.Printf("Unlock error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not unlock user"})
+	}
+
+	unlocked := user
+	unlocked.FailedLoginAttempts, unlocked.LockedUntil = 0, nil
+	services.RecordAuditLogged(db.GetDB(), auditActor(c), models.AuditUserUnlocked, "user", user.ID, user, unlocked)
+
+	log.Printf("User %d unlocked by UserID %d", user.ID, currentUserID(c))
+	return c.JSON(http.StatusOK, echo.Map{"message": "User unlocked successfully"})
+}
+
+// OrganizationAdminGetCostingMethod returns how the organization values its stock
+func OrganizationAdminGetCostingMethod(c echo.Context) error {
+	method, err := services.OrganizationCostingMethod(db.GetDB(), currentOrganizationID(c))
+	if err != nil {
+		log.Printf("Costing method error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not load costing method"})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"costing_method": method})
+}
+
+// OrganizationAdminUpdateCostingMethod switches the organization between FIFO
+// and weighted average costing. It applies from the next stock movement on.
+func OrganizationAdminUpdateCostingMethod(c echo.Context) error {
+	var input struct {
+		CostingMethod string `json:"costing_method"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Error decoding JSON"})
+	}
+
+	orgID := currentOrganizationID(c)
+	before, err := services.OrganizationCostingMethod(db.GetDB(), orgID)
+	if err != nil {
+		log.Printf("Costing method error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not load costing method"})
+	}
+	if err := services.SetOrganizationCostingMethod(db.GetDB(), orgID, input.CostingMethod); err != nil {
+		if errors.Is(err, services.ErrInvalidCostingMethod) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		log.Printf("Costing method error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not update costing method"})
+	}
+	services.RecordAuditLogged(db.GetDB(), auditActor(c), models.AuditOrganizationUpdated, "organization", orgID,
+		echo.Map{"costing_method": before}, echo.Map{"costing_method": input.CostingMethod})
+
+	log.Printf("Organization %d now uses %s costing", orgID, input.CostingMethod)
+	return c.JSON(http.StatusOK, echo.Map{"costing_method": input.CostingMethod})
+}
+
+// OrganizationAdminGetTaxSettings returns whether prices include tax and the
+// organization's default tax code
+func OrganizationAdminGetTaxSettings(c echo.Context) error {
+	settings, err := services.OrganizationTaxSettings(db.GetDB(), currentOrganizationID(c))
+	if err != nil {
+		log.Printf("Tax settings error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not load tax settings"})
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+// OrganizationAdminUpdateTaxSettings switches prices between tax-inclusive
+// and tax-exclusive and sets the default tax code. It applies from the next
+// sale on.
+func OrganizationAdminUpdateTaxSettings(c echo.Context) error {
+	var input services.TaxSettings
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Error decoding JSON"})
+	}
+
+	orgID := currentOrganizationID(c)
+	before, err := services.OrganizationTaxSettings(db.GetDB(), orgID)
+	if err != nil {
+		log.Printf("Tax settings error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not load tax settings"})
+	}
+	if err := services.SetOrganizationTaxSettings(db.GetDB(), orgID, input); err != nil {
+		if errors.Is(err, services.ErrTaxRateNotFound) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Unknown tax code"})
+		}
+		log.Printf("Tax settings error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not update tax settings"})
+	}
+	services.RecordAuditLogged(db.GetDB(), auditActor(c), models.AuditOrganizationUpdated, "organization", orgID, before, input)
+
+	return c.JSON(http.StatusOK, input)
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"stock/models"
+	"stock/services"
+)
+
+// IssueStoreCredit gives a customer store credit, typically instead of a cash
+// refund. The code in the response is what they pay with.
+func IssueStoreCredit(c echo.Context) error {
+	var input struct {
+		Amount models.Money `json:"amount"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	credit, err := services.IssueStoreCredit(db, currentOrganizationID(c), input.Amount)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPayment) {
+			return errorResponse(c, http.StatusBadRequest, "Amount must be greater than zero")
+		}
+		log.Printf("Error issuing store credit: %s", err.Error())
+		return errorResponse(c, http.StatusInternalServerError, "Failed to issue store credit")
+	}
+	services.RecordAuditLogged(db, auditActor(c), models.AuditStoreCreditIssued, "store_credit", credit.ID, nil, credit)
+
+	log.Printf("Issued store credit ID %d of %s %s", credit.ID, credit.Balance, credit.Currency)
+	return c.JSON(http.StatusCreated, credit)
+}
+
+// GetStoreCredit looks up a store credit's balance by its code
+func GetStoreCredit(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	credit, err := services.FindStoreCredit(db, currentOrganizationID(c), c.Param("code"))
+	if err != nil {
+		if errors.Is(err, services.ErrStoreCreditNotFound) {
+			return errorResponse(c, http.StatusNotFound, "Store credit not found")
+		}
+		log.Printf("Error querying store credit: %s", err.Error())
+		return errorResponse(c, http.StatusInternalServerError, "Failed to fetch store credit")
+	}
+	return c.JSON(http.StatusOK, credit)
+}
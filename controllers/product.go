@@ -7,7 +7,9 @@ import (
 	"log"
 	"net/http"
 	"stock/db"
+	"stock/listing"
 	models "stock/models"
+	"stock/services"
 	"strconv"
 )
 
@@ -40,6 +42,63 @@ func errorResponse(c echo.Context, statusCode int, message string) error {
 	log.Println(message)
 	return echo.NewHTTPError(statusCode, message)
 }
+
+// currentUserID returns the user ID set by AuthMiddleware, or 0 on unauthenticated routes
+func currentUserID(c echo.Context) uint {
+	userID, _ := c.Get("userID").(int)
+	return uint(userID)
+}
+
+// currentOrganizationID returns the tenant resolved by TenantMiddleware
+func currentOrganizationID(c echo.Context) uint {
+	orgID, _ := c.Get("organizationID").(uint)
+	return orgID
+}
+
+// auditActor describes the authenticated user and request for the audit trail
+func auditActor(c echo.Context) services.Actor {
+	roleID, _ := c.Get("roleID").(int)
+	requestID, _ := c.Get("requestID").(string)
+	return services.Actor{
+		UserID:         currentUserID(c),
+		RoleID:         uint(roleID),
+		OrganizationID: currentOrganizationID(c),
+		IP:             c.RealIP(),
+		RequestID:      requestID,
+	}
+}
+
+// auditActorFor is auditActor for a platform admin acting on an
+// organization's data, so the event lands in that organization's trail
+func auditActorFor(c echo.Context, orgID uint) services.Actor {
+	actor := auditActor(c)
+	actor.OrganizationID = orgID
+	return actor
+}
+
+// orgScope restricts a query to rows owned by the current tenant
+func orgScope(c echo.Context) func(*gorm.DB) *gorm.DB {
+	orgID := currentOrganizationID(c)
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("organization_id = ?", orgID)
+	}
+}
+
+// searchOrganizationID is the organization a search or report covers. Tenant
+// users always get their own; platform users get every organization (0)
+// unless they pick one with organization_id.
+func searchOrganizationID(c echo.Context) (uint, error) {
+	if orgID := currentOrganizationID(c); orgID != 0 {
+		return orgID, nil
+	}
+	raw := c.QueryParam("organization_id")
+	if raw == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(raw, 10, 32)
+	return uint(id), err
+}
+
 func MoveProductFromPendingDeletion(c echo.Context) error {
 	productID, err := strconv.Atoi(c.Param("product_id"))
 	if err != nil {
@@ -58,7 +117,7 @@ func MoveProductFromPendingDeletion(c echo.Context) error {
 	defer tx.Rollback()
 
 	var prod models.Product
-	if err := tx.Table("pending_deletion_products").Where("product_id = ?", productID).First(&prod).Error; err != nil {
+	if err := tx.Table("pending_deletion_products").Scopes(orgScope(c)).Where("product_id = ?", productID).First(&prod).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return errorResponse(c, http.StatusNotFound, "Product not found in pending deletion")
 		}
@@ -75,9 +134,12 @@ func MoveProductFromPendingDeletion(c echo.Context) error {
 		return errorResponse(c, http.StatusInternalServerError, "Failed to move product back to products")
 	}
 
-	if err := tx.Table("pending_deletion_products").Where("product_id = ?", productID).Delete(&models.Product{}).Error; err != nil {
+	if err := tx.Table("pending_deletion_products").Scopes(orgScope(c)).Where("product_id = ?", productID).Delete(&models.Product{}).Error; err != nil {
 		return errorResponse(c, http.StatusInternalServerError, "Failed to delete product from pending deletion")
 	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditProductRestored, "product", uint(productID), nil, prod); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to record audit event")
+	}
 
 	if err := tx.Commit().Error; err != nil {
 		return errorResponse(c, http.StatusInternalServerError, "Failed to complete operation")
@@ -103,7 +165,7 @@ func MoveProductToPendingDeletion(c echo.Context) error {
 	defer tx.Rollback()
 
 	var prod models.Product
-	if err := tx.Table("products").Where("product_id = ?", productID).First(&prod).Error; err != nil {
+	if err := tx.Table("products").Scopes(orgScope(c)).Where("product_id = ?", productID).First(&prod).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return errorResponse(c, http.StatusNotFound, "Product not found")
 		}
@@ -121,9 +183,12 @@ func MoveProductToPendingDeletion(c echo.Context) error {
 		return errorResponse(c, http.StatusInternalServerError, "Failed to move product to pending deletion")
 	}
 
-	if err := tx.Table("products").Where("product_id = ?", productID).Delete(&models.Product{}).Error; err != nil {
+	if err := tx.Table("products").Scopes(orgScope(c)).Where("product_id = ?", productID).Delete(&models.Product{}).Error; err != nil {
 		return errorResponse(c, http.StatusInternalServerError, "Failed to delete product")
 	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditProductPendingDeletion, "product", uint(productID), prod, nil); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to record audit event")
+	}
 
 	if err := tx.Commit().Error; err != nil {
 		return errorResponse(c, http.StatusInternalServerError, "Failed to complete operation")
@@ -140,11 +205,22 @@ func GetProducts(c echo.Context) error {
 	}
 
 	var products []models.Product
-	if err := db.Table("products").Find(&products).Error; err != nil {
-		return errorResponse(c, http.StatusInternalServerError, "Failed to fetch products")
-	}
+	return listResponse(c, db.Model(&models.Product{}).Scopes(orgScope(c)), productListing, &products)
+}
 
-	return c.JSON(http.StatusOK, products)
+// productListing ranges over date as text, which works for its YYYY-MM-DD values
+var productListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"product_id":    {Column: "product_id", Kind: listing.Int, Sort: true},
+		"category_name": {Column: "category_name", Filter: true, Sort: true},
+		"product_name":  {Column: "product_name", Filter: true, Sort: true},
+		"product_code":  {Column: "product_code", Filter: true},
+		"date":          {Column: "date", Range: true, Sort: true},
+		"quantity":      {Column: "quantity", Kind: listing.Int, Range: true, Sort: true},
+		"price":         {Column: "price", Kind: listing.Money, Range: true, Sort: true},
+	},
+	Key:         "product_id",
+	DefaultSort: "product_id",
 }
 
 // GetProductByID fetches a product by its ID
@@ -160,7 +236,7 @@ func GetProductByID(c echo.Context) error {
 	}
 
 	var prod models.Product
-	if err := db.Table("products").Where("product_id = ?", productID).First(&prod).Error; err != nil {
+	if err := db.Table("products").Scopes(orgScope(c)).Where("product_id = ?", productID).First(&prod).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return errorResponse(c, http.StatusNotFound, "Product not found")
 		}
@@ -186,11 +262,46 @@ func AddProduct(c echo.Context) error {
 		return errorResponse(c, http.StatusBadRequest, "Invalid date format")
 	}
 	product.Date = formattedDate
+	if err := checkTaxCode(c, product.TaxCode); err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error starting transaction")
+	}
+	defer tx.Rollback()
 
-	if err := db.Table("products").Create(&product).Error; err != nil {
+	// Opening stock enters through the ledger so the quantity can be rebuilt
+	// later; average_cost in the request is what it cost
+	openingQuantity, openingCost := product.Quantity, product.AverageCost
+	product.Quantity, product.AverageCost = 0, 0
+	product.OrganizationID = currentOrganizationID(c)
+	if err := tx.Table("products").Create(&product).Error; err != nil {
 		return errorResponse(c, http.StatusInternalServerError, "Error inserting product")
 	}
 
+	if openingQuantity != 0 {
+		if _, err := services.ApplyMovement(tx, &product, services.Movement{
+			Delta:         openingQuantity,
+			Reason:        models.MovementReasonReceipt,
+			UserID:        currentUserID(c),
+			ReferenceType: "product",
+			ReferenceID:   uint(product.ProductID),
+			Note:          "Opening stock",
+			UnitCost:      openingCost,
+		}); err != nil {
+			return stockErrorResponse(c, err)
+		}
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditProductCreated, "product", uint(product.ProductID), nil, product); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to record audit event")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to complete operation")
+	}
+
 	return c.JSON(http.StatusCreated, product)
 }
 
@@ -201,11 +312,21 @@ func UpdateProduct(c echo.Context) error {
 		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
 	}
 
-	productID := c.Param("product_id")
-	var updatedProduct models.Product
-	if err := c.Bind(&updatedProduct); err != nil {
+	productID, err := strconv.Atoi(c.Param("product_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid product ID")
+	}
+
+	// Quantity is a pointer so that a missing quantity leaves stock alone and
+	// zero counts it down to nothing
+	var input struct {
+		models.Product
+		Quantity *int `json:"quantity"`
+	}
+	if err := c.Bind(&input); err != nil {
 		return errorResponse(c, http.StatusBadRequest, "Failed to parse request body")
 	}
+	updatedProduct := input.Product
 
 	// Convert the ISO 8601 date format to MySQL TIMESTAMP format
 	formattedDate, err := convertToTimestampFormat(updatedProduct.Date)
@@ -213,12 +334,54 @@ func UpdateProduct(c echo.Context) error {
 		return errorResponse(c, http.StatusBadRequest, "Invalid date format")
 	}
 	updatedProduct.Date = formattedDate
+	if err := checkTaxCode(c, updatedProduct.TaxCode); err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	current, err := services.LockProduct(tx, currentOrganizationID(c), productID)
+	if err != nil {
+		return stockErrorResponse(c, err)
+	}
+	before := *current
+
+	// A changed quantity is recorded as an adjustment instead of overwriting the column
+	if input.Quantity != nil && *input.Quantity != current.Quantity {
+		if _, err := services.ApplyMovement(tx, current, services.Movement{
+			Delta:         *input.Quantity - current.Quantity,
+			Reason:        models.MovementReasonAdjustment,
+			UserID:        currentUserID(c),
+			ReferenceType: "product",
+			ReferenceID:   uint(productID),
+			Note:          "Product update",
+		}); err != nil {
+			return stockErrorResponse(c, err)
+		}
+	}
 
 	// Update the product in the database
-	if err := db.Table("products").Where("product_id = ?", productID).Updates(updatedProduct).Error; err != nil {
+	if err := tx.Table("products").Scopes(orgScope(c)).Where("product_id = ?", productID).Omit("quantity", "organization_id", "average_cost").Updates(updatedProduct).Error; err != nil {
 		return errorResponse(c, http.StatusInternalServerError, "Failed to update product")
 	}
 
+	var after models.Product
+	if err := tx.Table("products").Where("product_id = ?", productID).First(&after).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to fetch product")
+	}
+	if err := services.RecordProductUpdate(tx, auditActor(c), &before, &after); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to record audit event")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to complete operation")
+	}
+	services.TriggerReorderEvaluation()
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Product updated successfully"})
 }
 
@@ -234,9 +397,41 @@ func DeleteProduct(c echo.Context) error {
 		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
 	}
 
-	if err := db.Table("products").Where("product_id = ?", productID).Delete(&models.Product{}).Error; err != nil {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	prod, err := services.LockProduct(tx, currentOrganizationID(c), productID)
+	if err != nil {
+		return stockErrorResponse(c, err)
+	}
+
+	// Write off any remaining stock so the ledger balances to zero
+	if prod.Quantity != 0 {
+		if _, err := services.ApplyMovement(tx, prod, services.Movement{
+			Delta:         -prod.Quantity,
+			Reason:        models.MovementReasonWriteOff,
+			UserID:        currentUserID(c),
+			ReferenceType: "product",
+			ReferenceID:   uint(productID),
+			Note:          "Product deleted",
+		}); err != nil {
+			return stockErrorResponse(c, err)
+		}
+	}
+
+	if err := tx.Table("products").Scopes(orgScope(c)).Where("product_id = ?", productID).Delete(&models.Product{}).Error; err != nil {
 		return errorResponse(c, http.StatusInternalServerError, "Failed to delete product")
 	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditProductDeleted, "product", uint(productID), prod, nil); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to record audit event")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to complete operation")
+	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Product deleted successfully"})
 }
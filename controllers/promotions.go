@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"stock/listing"
+	"stock/models"
+	"stock/services"
+	"strconv"
+)
+
+// promotionErrorResponse maps promotion errors to HTTP errors
+func promotionErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrPromotionNotFound):
+		return errorResponse(c, http.StatusNotFound, "Promotion not found")
+	case errors.Is(err, services.ErrInvalidPromotion), errors.Is(err, services.ErrInvalidPrice):
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrDuplicateCoupon):
+		return errorResponse(c, http.StatusConflict, "Coupon code is already in use")
+	case errors.Is(err, services.ErrProductNotFound):
+		return errorResponse(c, http.StatusNotFound, "Product not found")
+	default:
+		log.Printf("Promotion error: %s", err.Error())
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+// GetPromotions lists the organization's promotions
+func GetPromotions(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var promotions []models.Promotion
+	return listResponse(c, db.Model(&models.Promotion{}).Scopes(orgScope(c)), promotionListing, &promotions)
+}
+
+var promotionListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":            {Column: "id", Kind: listing.Int, Sort: true},
+		"name":          {Column: "name", Filter: true, Sort: true},
+		"kind":          {Column: "kind", Filter: true},
+		"product_id":    {Column: "product_id", Kind: listing.Int, Filter: true},
+		"category_name": {Column: "category_name", Filter: true},
+		"coupon_code":   {Column: "coupon_code", Filter: true},
+		"is_active":     {Column: "is_active", Kind: listing.Bool, Filter: true},
+		"starts_at":     {Column: "starts_at", Kind: listing.Time, Range: true, Sort: true},
+		"ends_at":       {Column: "ends_at", Kind: listing.Time, Range: true, Sort: true},
+	},
+	Key:         "id",
+	DefaultSort: "id",
+}
+
+// CreatePromotion adds a promotion. It is active straight away, within its
+// time window if it has one.
+func CreatePromotion(c echo.Context) error {
+	var promotion models.Promotion
+	if err := json.NewDecoder(c.Request().Body).Decode(&promotion); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	promotion.ID = 0
+	promotion.OrganizationID = currentOrganizationID(c)
+	promotion.IsActive = true
+	if err := services.SavePromotion(tx, &promotion); err != nil {
+		return promotionErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditPromotionCreated, "promotion", promotion.ID, nil, promotion); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error inserting promotion")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error inserting promotion")
+	}
+
+	log.Printf("Created promotion %q with ID %d for organization %d", promotion.Name, promotion.ID, promotion.OrganizationID)
+	return c.JSON(http.StatusCreated, promotion)
+}
+
+// UpdatePromotion replaces a promotion's terms. Sales already made keep the
+// discount they were given.
+func UpdatePromotion(c echo.Context) error {
+	promotionID, err := strconv.Atoi(c.Param("promotion_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid promotion ID")
+	}
+
+	var input models.Promotion
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	promotion, err := services.LockPromotion(tx, currentOrganizationID(c), uint(promotionID))
+	if err != nil {
+		return promotionErrorResponse(c, err)
+	}
+	before := *promotion
+	input.ID = promotion.ID
+	input.OrganizationID = promotion.OrganizationID
+	input.CreatedAt = promotion.CreatedAt
+	*promotion = input
+	if err := services.SavePromotion(tx, promotion); err != nil {
+		return promotionErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditPromotionUpdated, "promotion", promotion.ID, before, promotion); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update promotion")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update promotion")
+	}
+
+	log.Printf("Updated promotion ID %d", promotion.ID)
+	return c.JSON(http.StatusOK, promotion)
+}
+
+// DeactivatePromotion ends a promotion while keeping it for the sales it discounted
+func DeactivatePromotion(c echo.Context) error {
+	promotionID, err := strconv.Atoi(c.Param("promotion_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid promotion ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	promotion, err := services.LockPromotion(tx, currentOrganizationID(c), uint(promotionID))
+	if err != nil {
+		return promotionErrorResponse(c, err)
+	}
+	before := *promotion
+	promotion.IsActive = false
+	if err := tx.Model(promotion).Update("is_active", false).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to deactivate promotion")
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditPromotionUpdated, "promotion", promotion.ID, before, promotion); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to deactivate promotion")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to deactivate promotion")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Promotion deactivated successfully"})
+}
+
+// CreatePriceApproval lets a manager approve selling a product below the
+// override threshold. The token in the response is handed to the cashier,
+// who sends it as the checkout line's approval_token.
+func CreatePriceApproval(c echo.Context) error {
+	var input struct {
+		ProductID int          `json:"product_id"`
+		MinPrice  models.Money `json:"min_price"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	token, approval, err := services.IssuePriceApproval(db, currentOrganizationID(c), input.ProductID, input.MinPrice, currentUserID(c))
+	if err != nil {
+		return promotionErrorResponse(c, err)
+	}
+	services.RecordAuditLogged(db, auditActor(c), models.AuditPriceApproved, "price_approval", approval.ID, nil, approval)
+
+	log.Printf("User %d approved a price of %s for product %d", approval.ApprovedBy, approval.MinPrice, approval.ProductID)
+	return c.JSON(http.StatusCreated, echo.Map{
+		"approval_token": token,
+		"product_id":     approval.ProductID,
+		"min_price":      approval.MinPrice,
+		"expires_at":     approval.ExpiresAt,
+	})
+}
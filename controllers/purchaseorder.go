@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"stock/listing"
+	models "stock/models"
+	"stock/services"
+	"strconv"
+)
+
+// purchaseOrderErrorResponse maps purchasing errors to HTTP errors
+func purchaseOrderErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrPurchaseOrderNotFound):
+		return errorResponse(c, http.StatusNotFound, "Purchase order not found")
+	case errors.Is(err, services.ErrInvalidTransition):
+		return errorResponse(c, http.StatusConflict, "Purchase order cannot move to that status")
+	case errors.Is(err, services.ErrUnknownOrderLine):
+		return errorResponse(c, http.StatusBadRequest, "Line does not belong to the purchase order")
+	case errors.Is(err, services.ErrOverReceipt):
+		return errorResponse(c, http.StatusBadRequest, "Received quantity exceeds outstanding quantity")
+	case errors.Is(err, services.ErrInvalidQuantity):
+		return errorResponse(c, http.StatusBadRequest, "Quantity must be greater than zero")
+	default:
+		return stockErrorResponse(c, err)
+	}
+}
+
+// validatePurchaseOrderLines checks that every line names an existing product and a positive quantity
+func validatePurchaseOrderLines(db *gorm.DB, orgID uint, lines []models.PurchaseOrderLine) error {
+	if len(lines) == 0 {
+		return errors.New("Purchase order must contain at least one line")
+	}
+	for _, line := range lines {
+		if line.QuantityOrdered <= 0 {
+			return errors.New("Quantity ordered must be greater than zero")
+		}
+		var count int64
+		if err := db.Model(&models.Product{}).Where("product_id = ? AND organization_id = ?", line.ProductID, orgID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("Product " + strconv.Itoa(line.ProductID) + " not found")
+		}
+	}
+	return nil
+}
+
+// GetPurchaseOrders fetches purchase orders, optionally filtered by status or supplier
+func GetPurchaseOrders(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var orders []models.PurchaseOrder
+	return listResponse(c, db.Model(&models.PurchaseOrder{}).Scopes(orgScope(c)), purchaseOrderListing, &orders)
+}
+
+var purchaseOrderListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":          {Column: "id", Kind: listing.Int, Sort: true},
+		"status":      {Column: "status", Filter: true},
+		"supplier_id": {Column: "supplier_id", Kind: listing.Int, Filter: true},
+		"reference":   {Column: "reference", Filter: true},
+		"created_at":  {Column: "created_at", Kind: listing.Time, Range: true, Sort: true},
+	},
+	Key:         "id",
+	DefaultSort: "-id",
+	Preload:     []string{"Lines"},
+}
+
+// GetPurchaseOrderByID fetches a purchase order with its lines
+func GetPurchaseOrderByID(c echo.Context) error {
+	orderID, err := strconv.Atoi(c.Param("purchase_order_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid purchase order ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var order models.PurchaseOrder
+	if err := db.Preload("Lines").Scopes(orgScope(c)).Where("id = ?", orderID).First(&order).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return errorResponse(c, http.StatusNotFound, "Purchase order not found")
+		}
+		return errorResponse(c, http.StatusInternalServerError, "Failed to fetch purchase order")
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
+
+// CreatePurchaseOrder drafts a new purchase order for a supplier
+func CreatePurchaseOrder(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var order models.PurchaseOrder
+	if err := json.NewDecoder(c.Request().Body).Decode(&order); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	var supplier models.Supplier
+	if err := db.Scopes(orgScope(c)).Where("id = ?", order.SupplierID).First(&supplier).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return errorResponse(c, http.StatusBadRequest, "Supplier not found")
+		}
+		return errorResponse(c, http.StatusInternalServerError, "Failed to fetch supplier")
+	}
+	if !supplier.IsActive {
+		return errorResponse(c, http.StatusBadRequest, "Supplier is inactive")
+	}
+
+	if err := validatePurchaseOrderLines(db, currentOrganizationID(c), order.Lines); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	}
+
+	order.ID = 0
+	order.OrganizationID = currentOrganizationID(c)
+	order.Status = models.PurchaseOrderStatusDraft
+	order.CreatedBy = currentUserID(c)
+	order.SentAt, order.ReceivedAt, order.ClosedAt = nil, nil, nil
+	for i := range order.Lines {
+		order.Lines[i].ID = 0
+		order.Lines[i].QuantityReceived = 0
+	}
+
+	if err := db.Create(&order).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error inserting purchase order")
+	}
+
+	log.Printf("Created purchase order ID %d for supplier ID %d", order.ID, order.SupplierID)
+	return c.JSON(http.StatusCreated, order)
+}
+
+// UpdatePurchaseOrder replaces the details and lines of a draft purchase order
+func UpdatePurchaseOrder(c echo.Context) error {
+	orderID, err := strconv.Atoi(c.Param("purchase_order_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid purchase order ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var input models.PurchaseOrder
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+	if err := validatePurchaseOrderLines(db, currentOrganizationID(c), input.Lines); err != nil {
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	order, err := services.LockPurchaseOrder(tx, currentOrganizationID(c), uint(orderID))
+	if err != nil {
+		return purchaseOrderErrorResponse(c, err)
+	}
+	if order.Status != models.PurchaseOrderStatusDraft {
+		return errorResponse(c, http.StatusConflict, "Only draft purchase orders can be edited")
+	}
+
+	if err := tx.Model(order).Updates(map[string]interface{}{
+		"reference": input.Reference,
+		"notes":     input.Notes,
+	}).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update purchase order")
+	}
+
+	if err := tx.Where("purchase_order_id = ?", order.ID).Delete(&models.PurchaseOrderLine{}).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update purchase order lines")
+	}
+	for i := range input.Lines {
+		input.Lines[i].ID = 0
+		input.Lines[i].PurchaseOrderID = order.ID
+		input.Lines[i].QuantityReceived = 0
+	}
+	if err := tx.Create(&input.Lines).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update purchase order lines")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to complete operation")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Purchase order updated successfully"})
+}
+
+// SendPurchaseOrder marks a draft purchase order as sent to the supplier
+func SendPurchaseOrder(c echo.Context) error {
+	return transitionPurchaseOrder(c, models.PurchaseOrderStatusSent)
+}
+
+// ClosePurchaseOrder closes a received or partially received purchase order
+func ClosePurchaseOrder(c echo.Context) error {
+	return transitionPurchaseOrder(c, models.PurchaseOrderStatusClosed)
+}
+
+// CancelPurchaseOrder cancels a draft or sent purchase order before any goods arrive
+func CancelPurchaseOrder(c echo.Context) error {
+	return transitionPurchaseOrder(c, models.PurchaseOrderStatusCancelled)
+}
+
+func transitionPurchaseOrder(c echo.Context, status string) error {
+	orderID, err := strconv.Atoi(c.Param("purchase_order_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid purchase order ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	order, err := services.LockPurchaseOrder(tx, currentOrganizationID(c), uint(orderID))
+	if err != nil {
+		return purchaseOrderErrorResponse(c, err)
+	}
+	if err := services.TransitionPurchaseOrder(tx, order, status); err != nil {
+		return purchaseOrderErrorResponse(c, err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to complete operation")
+	}
+
+	log.Printf("Purchase order ID %d moved to status %s", order.ID, order.Status)
+	return c.JSON(http.StatusOK, order)
+}
+
+// ReceivePurchaseOrder books goods received against a sent purchase order
+func ReceivePurchaseOrder(c echo.Context) error {
+	orderID, err := strconv.Atoi(c.Param("purchase_order_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid purchase order ID")
+	}
+
+	var input struct {
+		Lines []services.ReceiveLine `json:"lines"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	order, receipts, err := services.ReceivePurchaseOrder(tx, currentOrganizationID(c), uint(orderID), currentUserID(c), input.Lines)
+	if err != nil {
+		return purchaseOrderErrorResponse(c, err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to complete operation")
+	}
+
+	services.TriggerReorderEvaluation()
+
+	log.Printf("Received %d lines against purchase order ID %d, status now %s", len(receipts), order.ID, order.Status)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"purchase_order": order,
+		"receipts":       receipts,
+	})
+}
+
+// GetPurchaseOrderReceipts lists the goods received against a purchase order
+func GetPurchaseOrderReceipts(c echo.Context) error {
+	orderID, err := strconv.Atoi(c.Param("purchase_order_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid purchase order ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var receipts []models.GoodsReceipt
+	if err := db.Scopes(orgScope(c)).Where("purchase_order_id = ?", orderID).Order("id").Find(&receipts).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to fetch goods receipts")
+	}
+
+	return c.JSON(http.StatusOK, receipts)
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"stock/listing"
+	models "stock/models"
+	"stock/services"
+)
+
+// GetLowStockProducts lists the open reorder alerts. Pass refresh=true to
+// re-evaluate stock levels before returning.
+func GetLowStockProducts(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	if c.QueryParam("refresh") == "true" {
+		if _, err := services.RefreshReorderAlerts(db); err != nil {
+			log.Printf("Error evaluating reorder alerts: %s", err.Error())
+			return errorResponse(c, http.StatusInternalServerError, "Failed to evaluate reorder alerts")
+		}
+	}
+
+	var alerts []models.ReorderAlert
+	query := db.Model(&models.ReorderAlert{}).Scopes(orgScope(c)).Where("status = ?", models.ReorderAlertStatusOpen)
+	return listResponse(c, query, reorderAlertListing, &alerts)
+}
+
+var reorderAlertListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":                 {Column: "id", Kind: listing.Int, Sort: true},
+		"product_id":         {Column: "product_id", Kind: listing.Int, Filter: true, Sort: true},
+		"quantity":           {Column: "quantity", Kind: listing.Int, Range: true, Sort: true},
+		"suggested_quantity": {Column: "suggested_quantity", Kind: listing.Int, Range: true, Sort: true},
+		"created_at":         {Column: "created_at", Kind: listing.Time, Range: true, Sort: true},
+	},
+	Key:         "id",
+	DefaultSort: "product_id",
+}
+
+// DraftSuggestedPurchaseOrder drafts a purchase order for a supplier from the
+// suggested quantities of open reorder alerts
+func DraftSuggestedPurchaseOrder(c echo.Context) error {
+	var input struct {
+		SupplierID uint  `json:"supplier_id"`
+		ProductIDs []int `json:"product_ids"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var supplier models.Supplier
+	if err := db.Scopes(orgScope(c)).Where("id = ?", input.SupplierID).First(&supplier).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return errorResponse(c, http.StatusBadRequest, "Supplier not found")
+		}
+		return errorResponse(c, http.StatusInternalServerError, "Failed to fetch supplier")
+	}
+
+	query := db.Scopes(orgScope(c)).Where("status = ? AND suggested_quantity > 0", models.ReorderAlertStatusOpen)
+	if len(input.ProductIDs) > 0 {
+		query = query.Where("product_id IN ?", input.ProductIDs)
+	}
+	var alerts []models.ReorderAlert
+	if err := query.Order("product_id").Find(&alerts).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to fetch reorder alerts")
+	}
+	if len(alerts) == 0 {
+		return errorResponse(c, http.StatusNotFound, "No products need reordering")
+	}
+
+	order := models.PurchaseOrder{
+		OrganizationID: supplier.OrganizationID,
+		SupplierID:     supplier.ID,
+		Status:         models.PurchaseOrderStatusDraft,
+		Notes:          "Suggested from reorder alerts",
+		CreatedBy:      currentUserID(c),
+	}
+	for _, alert := range alerts {
+		order.Lines = append(order.Lines, models.PurchaseOrderLine{
+			ProductID:       alert.ProductID,
+			QuantityOrdered: alert.SuggestedQuantity,
+		})
+	}
+
+	if err := db.Create(&order).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error inserting purchase order")
+	}
+
+	log.Printf("Drafted suggested purchase order ID %d with %d lines", order.ID, len(order.Lines))
+	return c.JSON(http.StatusCreated, order)
+}
@@ -0,0 +1,323 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"stock/services"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultReportDays       = 30
+	defaultProductRankLimit = 10
+	maxProductRankLimit     = 100
+)
+
+func reportErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidGrouping), errors.Is(err, services.ErrInvalidRanking),
+		errors.Is(err, services.ErrInvalidTaxGrouping):
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	default:
+		log.Printf("Report error: %v", err)
+		return errorResponse(c, http.StatusInternalServerError, "Failed to build report")
+	}
+}
+
+// reportPeriod reads the period of a report: from and to are dates in the tz
+// time zone, both included. It defaults to the last 30 days.
+func reportPeriod(c echo.Context) (services.SalesPeriod, error) {
+	period := services.SalesPeriod{Location: services.ReportLocation}
+	if tz := c.QueryParam("tz"); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return period, errors.New("invalid time zone")
+		}
+		period.Location = location
+	}
+
+	orgID, err := searchOrganizationID(c)
+	if err != nil {
+		return period, errors.New("invalid organization ID")
+	}
+	period.OrganizationID = orgID
+
+	now := time.Now().In(period.Location)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, period.Location)
+	if raw := c.QueryParam("to"); raw != "" {
+		if to, err = time.ParseInLocation("2006-01-02", raw, period.Location); err != nil {
+			return period, errors.New("invalid to date")
+		}
+	}
+	from := to.AddDate(0, 0, 1-defaultReportDays)
+	if raw := c.QueryParam("from"); raw != "" {
+		if from, err = time.ParseInLocation("2006-01-02", raw, period.Location); err != nil {
+			return period, errors.New("invalid from date")
+		}
+	}
+	if to.Before(from) {
+		return period, errors.New("to must not be before from")
+	}
+
+	period.From, period.To = from, to.AddDate(0, 0, 1)
+	return period, nil
+}
+
+// GetSalesSummary totals sales over a period grouped by day, week, month,
+// category, product or cashier. compare=true adds the previous period of the
+// same length and the change from it.
+func GetSalesSummary(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	period, err := reportPeriod(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	}
+	groupBy := c.QueryParam("group_by")
+	if groupBy == "" {
+		groupBy = "day"
+	}
+
+	summary, err := services.SummarizeSalesBy(db, period, groupBy)
+	if err != nil {
+		return reportErrorResponse(c, err)
+	}
+
+	response := echo.Map{
+		"from":      period.From.Format("2006-01-02"),
+		"to":        period.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		"time_zone": period.Location.String(),
+		"group_by":  summary.GroupBy,
+		"groups":    summary.Groups,
+		"totals":    summary.Totals,
+	}
+	if c.QueryParam("compare") == "true" {
+		previousPeriod := period.Previous()
+		previous, err := services.SummarizeSalesBy(db, previousPeriod, groupBy)
+		if err != nil {
+			return reportErrorResponse(c, err)
+		}
+		response["previous"] = echo.Map{
+			"from":   previousPeriod.From.Format("2006-01-02"),
+			"to":     previousPeriod.To.AddDate(0, 0, -1).Format("2006-01-02"),
+			"groups": previous.Groups,
+			"totals": previous.Totals,
+		}
+		response["change"] = services.CompareSales(summary.Totals, previous.Totals)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetProductSalesRanking lists the top (or, with order=bottom, the bottom)
+// products of a period by units or revenue. compare=true adds each product's
+// sales in the previous period.
+func GetProductSalesRanking(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	period, err := reportPeriod(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	}
+	by := c.QueryParam("by")
+	if by == "" {
+		by = "units"
+	}
+	order := c.QueryParam("order")
+	if order != "" && order != "top" && order != "bottom" {
+		return errorResponse(c, http.StatusBadRequest, "order must be top or bottom")
+	}
+	limit := defaultProductRankLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return errorResponse(c, http.StatusBadRequest, "Invalid limit")
+		}
+		if n < maxProductRankLimit {
+			limit = n
+		} else {
+			limit = maxProductRankLimit
+		}
+	}
+
+	ranks, err := services.RankProducts(db, period, by, order == "bottom", limit)
+	if err != nil {
+		return reportErrorResponse(c, err)
+	}
+	if c.QueryParam("compare") == "true" {
+		if err := services.AddPreviousProductSales(db, period, ranks); err != nil {
+			return reportErrorResponse(c, err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"from":      period.From.Format("2006-01-02"),
+		"to":        period.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		"time_zone": period.Location.String(),
+		"by":        by,
+		"products":  ranks,
+	})
+}
+
+// GetInventoryValuation values the stock on hand by product and category
+// under the organization's costing method
+func GetInventoryValuation(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	orgID, err := searchOrganizationID(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid organization ID")
+	}
+	valuation, err := services.ValueInventory(db, orgID)
+	if err != nil {
+		return reportErrorResponse(c, err)
+	}
+
+	response := echo.Map{
+		"products":   valuation.Products,
+		"categories": valuation.Categories,
+		"quantity":   valuation.Quantity,
+		"value":      valuation.Value,
+	}
+	if orgID != 0 {
+		method, err := services.OrganizationCostingMethod(db, orgID)
+		if err != nil {
+			return reportErrorResponse(c, err)
+		}
+		response["costing_method"] = method
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetGrossMargin reports revenue, cost of goods sold and gross margin over a
+// period by product (the default) or category
+func GetGrossMargin(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	period, err := reportPeriod(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	}
+	groupBy := c.QueryParam("group_by")
+	if groupBy == "" {
+		groupBy = "product"
+	}
+
+	groups, totals, err := services.GrossMargins(db, period, groupBy)
+	if err != nil {
+		return reportErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"from":      period.From.Format("2006-01-02"),
+		"to":        period.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		"time_zone": period.Location.String(),
+		"group_by":  groupBy,
+		"groups":    groups,
+		"totals":    totals,
+	})
+}
+
+// GetTaxReport summarizes the tax collected over a period by rate. group_by
+// day, week or month also splits each rate by period.
+func GetTaxReport(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	period, err := reportPeriod(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	}
+	groupBy := c.QueryParam("group_by")
+
+	summary, err := services.SummarizeTax(db, period, groupBy)
+	if err != nil {
+		return reportErrorResponse(c, err)
+	}
+
+	response := echo.Map{
+		"from":      period.From.Format("2006-01-02"),
+		"to":        period.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		"time_zone": period.Location.String(),
+		"rates":     summary.Rates,
+		"totals":    summary.Totals,
+	}
+	if groupBy != "" {
+		response["group_by"] = groupBy
+		response["periods"] = summary.Periods
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetTillOverShort reports, per cashier and day, how far the cash counted at
+// the close of their till sessions was over or short of what was expected
+func GetTillOverShort(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	period, err := reportPeriod(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	}
+
+	rows, err := services.SummarizeOverShort(db, period)
+	if err != nil {
+		return reportErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"from":      period.From.Format("2006-01-02"),
+		"to":        period.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		"time_zone": period.Location.String(),
+		"cashiers":  rows,
+	})
+}
+
+// GetAgedReceivables buckets what each customer owes by how long it has been
+// overdue, as of the as_of date (default today) in the tz time zone
+func GetAgedReceivables(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	period, err := reportPeriod(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	}
+	asOf := time.Now().In(period.Location)
+	if raw := c.QueryParam("as_of"); raw != "" {
+		if asOf, err = time.ParseInLocation("2006-01-02", raw, period.Location); err != nil {
+			return errorResponse(c, http.StatusBadRequest, "invalid as_of date")
+		}
+	}
+
+	customers, totals, err := services.AgedReceivables(db, period.OrganizationID, asOf)
+	if err != nil {
+		return reportErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"as_of":     asOf.Format("2006-01-02"),
+		"time_zone": period.Location.String(),
+		"customers": customers,
+		"totals":    totals,
+	})
+}
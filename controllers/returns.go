@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	models "stock/models"
+	"stock/services"
+	"strconv"
+)
+
+// saleReturnErrorResponse maps void and return errors to HTTP errors
+func saleReturnErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrSaleNotFound):
+		return errorResponse(c, http.StatusNotFound, "Sale not found")
+	case errors.Is(err, services.ErrSaleVoided):
+		return errorResponse(c, http.StatusConflict, "Sale has already been voided")
+	case errors.Is(err, services.ErrReturnExceedsSale):
+		return errorResponse(c, http.StatusBadRequest, "Return quantity exceeds quantity still on the sale")
+	case errors.Is(err, services.ErrReasonRequired):
+		return errorResponse(c, http.StatusBadRequest, "A reason is required")
+	case errors.Is(err, services.ErrSaleWithoutProduct):
+		return errorResponse(c, http.StatusBadRequest, "Sale is not linked to a product and cannot be restocked")
+	case errors.Is(err, services.ErrInvalidQuantity):
+		return errorResponse(c, http.StatusBadRequest, "Quantity must be greater than zero")
+	case errors.Is(err, services.ErrStoreCreditNotFound):
+		return errorResponse(c, http.StatusConflict, "The store credit paid with no longer exists")
+	default:
+		return stockErrorResponse(c, err)
+	}
+}
+
+// VoidSale reverses a whole sale line, restocking whatever has not been returned yet
+func VoidSale(c echo.Context) error {
+	saleID, err := strconv.Atoi(c.Param("sale_id"))
+	if err != nil {
+		log.Printf("Invalid sale ID: %s", c.Param("sale_id"))
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid sale ID")
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		log.Printf("Error decoding JSON: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	return voidSale(c, saleID, input.Reason)
+}
+
+func voidSale(c echo.Context, saleID int, reason string) error {
+	db := getDB()
+	if db == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		log.Printf("Error starting transaction: %s", tx.Error.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	sale, saleReturn, err := services.VoidSale(tx, currentOrganizationID(c), saleID, reason, auditActor(c))
+	if err != nil {
+		return saleReturnErrorResponse(c, err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction: %s", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+	if saleReturn != nil {
+		services.SettleRefunds(db, saleReturn.Refunds)
+	}
+
+	log.Printf("Voided sale with ID: %d", saleID)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Sale voided successfully",
+		"sale":    sale,
+		"return":  saleReturn,
+	})
+}
+
+// VoidReceipt voids every line on a receipt
+func VoidReceipt(c echo.Context) error {
+	receiptID, err := strconv.Atoi(c.Param("receipt_id"))
+	if err != nil {
+		log.Printf("Invalid receipt ID: %s", c.Param("receipt_id"))
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid receipt ID")
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		log.Printf("Error decoding JSON: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		log.Printf("Error starting transaction: %s", tx.Error.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	sales, refunds, err := services.VoidReceipt(tx, currentOrganizationID(c), uint(receiptID), input.Reason, auditActor(c))
+	if err != nil {
+		return saleReturnErrorResponse(c, err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction: %s", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+	services.SettleRefunds(db, refunds)
+
+	log.Printf("Voided %d sale lines on receipt ID %d", len(sales), receiptID)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Receipt voided successfully",
+		"sales":   sales,
+		"refunds": refunds,
+	})
+}
+
+// ReturnSale records a customer return against a sale line
+func ReturnSale(c echo.Context) error {
+	saleID, err := strconv.Atoi(c.Param("sale_id"))
+	if err != nil {
+		log.Printf("Invalid sale ID: %s", c.Param("sale_id"))
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid sale ID")
+	}
+
+	var input struct {
+		Quantity int    `json:"quantity"`
+		Reason   string `json:"reason"`
+		Restock  *bool  `json:"restock"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		log.Printf("Error decoding JSON: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	// Returned goods go back on the shelf unless the caller says they are damaged
+	restock := true
+	if input.Restock != nil {
+		restock = *input.Restock
+	}
+
+	db := getDB()
+	if db == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		log.Printf("Error starting transaction: %s", tx.Error.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	saleReturn, err := services.ReturnSale(tx, services.ReturnInput{
+		OrganizationID: currentOrganizationID(c),
+		SaleID:         saleID,
+		Quantity:       input.Quantity,
+		Reason:         input.Reason,
+		Restock:        restock,
+		UserID:         currentUserID(c),
+	})
+	if err != nil {
+		return saleReturnErrorResponse(c, err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing transaction: %s", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+	services.SettleRefunds(db, saleReturn.Refunds)
+
+	log.Printf("Returned %d units against sale ID %d (restocked: %v)", saleReturn.Quantity, saleID, saleReturn.Restocked)
+	return c.JSON(http.StatusCreated, saleReturn)
+}
+
+// GetSaleReturns lists the returns and voids recorded against a sale line
+func GetSaleReturns(c echo.Context) error {
+	saleID, err := strconv.Atoi(c.Param("sale_id"))
+	if err != nil {
+		log.Printf("Invalid sale ID: %s", c.Param("sale_id"))
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid sale ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var returns []models.SaleReturn
+	if err := db.Scopes(orgScope(c)).Where("sale_id = ?", saleID).Order("id").Find(&returns).Error; err != nil {
+		log.Printf("Error querying sale returns: %s", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch sale returns")
+	}
+
+	return c.JSON(http.StatusOK, returns)
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"stock/models"
+	"stock/services"
+	"strconv"
+)
+
+// roleErrorResponse maps role errors to HTTP errors
+func roleErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRoleNotFound):
+		return errorResponse(c, http.StatusNotFound, "Role not found")
+	case errors.Is(err, services.ErrRoleNameRequired):
+		return errorResponse(c, http.StatusBadRequest, "Role name is required")
+	case errors.Is(err, services.ErrPermissionRequired):
+		return errorResponse(c, http.StatusBadRequest, "At least one permission is required")
+	case errors.Is(err, services.ErrUnknownPermission):
+		return errorResponse(c, http.StatusBadRequest, "Unknown permission")
+	case errors.Is(err, services.ErrDefaultRoleChange):
+		return errorResponse(c, http.StatusForbidden, "Default roles cannot be changed")
+	case errors.Is(err, services.ErrRoleInUse):
+		return errorResponse(c, http.StatusConflict, "Role is assigned to users")
+	default:
+		log.Printf("Role error: %s", err.Error())
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+type roleInput struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// GetPermissions lists every permission a custom role can be granted
+func GetPermissions(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var permissions []models.Permission
+	if err := db.Order("id").Find(&permissions).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to fetch permissions")
+	}
+
+	return c.JSON(http.StatusOK, permissions)
+}
+
+// GetRoles lists the default roles and the organization's custom roles with their permissions
+func GetRoles(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var roles []models.Role
+	if err := db.Preload("Permissions").
+		Where("is_default = ? OR organization_id = ?", true, currentOrganizationID(c)).
+		Order("id").Find(&roles).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to fetch roles")
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+// CreateRole defines a custom role for the organization
+func CreateRole(c echo.Context) error {
+	var input roleInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	role := models.Role{
+		OrganizationID: currentOrganizationID(c),
+		Name:           input.Name,
+		Description:    input.Description,
+	}
+	if err := services.SaveRole(tx, &role, input.Permissions); err != nil {
+		return roleErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditRoleCreated, "role", role.ID, nil, role); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error inserting role")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error inserting role")
+	}
+
+	log.Printf("Created role %q with ID %d for organization %d", role.Name, role.ID, role.OrganizationID)
+	return c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole renames a custom role and replaces its permissions
+func UpdateRole(c echo.Context) error {
+	roleID, err := strconv.Atoi(c.Param("role_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid role ID")
+	}
+
+	var input roleInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	role, err := services.LockRole(tx, currentOrganizationID(c), uint(roleID))
+	if err != nil {
+		return roleErrorResponse(c, err)
+	}
+	if err := tx.Model(role).Association("Permissions").Find(&role.Permissions); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update role")
+	}
+	before := *role
+	role.Name = input.Name
+	role.Description = input.Description
+	if err := services.SaveRole(tx, role, input.Permissions); err != nil {
+		return roleErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditRoleUpdated, "role", role.ID, before, role); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update role")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update role")
+	}
+
+	log.Printf("Updated role ID %d", role.ID)
+	return c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole removes a custom role that no user holds
+func DeleteRole(c echo.Context) error {
+	roleID, err := strconv.Atoi(c.Param("role_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid role ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	role, err := services.LockRole(tx, currentOrganizationID(c), uint(roleID))
+	if err != nil {
+		return roleErrorResponse(c, err)
+	}
+	if err := tx.Model(role).Association("Permissions").Find(&role.Permissions); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to delete role")
+	}
+	if err := services.DeleteRole(tx, currentOrganizationID(c), uint(roleID)); err != nil {
+		return roleErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditRoleDeleted, "role", role.ID, role, nil); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to delete role")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to delete role")
+	}
+
+	log.Printf("Deleted role ID %d", roleID)
+	return c.JSON(http.StatusOK, map[string]string{"message": "Role deleted successfully"})
+}
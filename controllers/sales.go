@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
+	"io"
 	"log"
 	"net/http"
+	"stock/listing"
 	models "stock/models"
+	"stock/services"
 	"strconv"
 	"time"
 )
@@ -24,7 +27,6 @@ import (
 func SellProduct(c echo.Context) error {
 	productIDStr := c.Param("product_id")
 	quantitySoldStr := c.Param("quantity_sold")
-	userID := c.QueryParam("user_id") // Assuming user_id is passed as a query parameter
 
 	// Convert parameters to integer values
 	productID, err := strconv.Atoi(productIDStr)
@@ -39,6 +41,18 @@ func SellProduct(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid quantity sold")
 	}
 
+	// The body, when there is one, carries the payments and the customer
+	var input struct {
+		Payments       []services.PaymentInput `json:"payments"`
+		CustomerID     uint                    `json:"customer_id"`
+		DueDate        *time.Time              `json:"due_date"`
+		IdempotencyKey string                  `json:"idempotency_key"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil && err != io.EOF {
+		log.Printf("Error decoding JSON: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "Error decoding JSON")
+	}
+
 	// Initialize database connection
 	db := getDB()
 	if db == nil {
@@ -53,66 +67,53 @@ func SellProduct(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
-	// Retrieve the product details
-	var product models.Product
-	if err := tx.First(&product, productID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			log.Printf("Product not found with ID: %d", productID)
-			return echo.NewHTTPError(http.StatusNotFound, "Product not found")
-		}
-		log.Printf("Error querying product details: %s", err.Error())
-		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	// Sell the product as a single-line basket on behalf of the authenticated user
+	basket := services.Basket{
+		Lines:          []services.CheckoutLine{{ProductID: productID, Quantity: quantitySold}},
+		Payments:       input.Payments,
+		CustomerID:     input.CustomerID,
+		DueDate:        input.DueDate,
+		IdempotencyKey: input.IdempotencyKey,
 	}
-
-	// Check if enough quantity is available
-	if product.Quantity < quantitySold {
-		log.Printf("Insufficient quantity for product ID %d: Available %d, Requested %d", productID, product.Quantity, quantitySold)
-		return echo.NewHTTPError(http.StatusBadRequest, "Insufficient quantity")
+	if coupon := c.QueryParam("coupon"); coupon != "" {
+		basket.CouponCodes = []string{coupon}
 	}
-
-	// Prepare the updated quantity
-	updatedQuantity := product.Quantity - quantitySold
-
-	// Update the quantity of the product in the 'products' table
-	if err := tx.Model(&models.Product{}).Where("product_id = ?", productID).Update("quantity", updatedQuantity).Error; err != nil {
-		log.Printf("Error updating product quantity: %s", err.Error())
-		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	receipt, err := services.Checkout(tx, currentOrganizationID(c), currentUserID(c), basket)
+	if err != nil {
+		return checkoutErrorResponse(c, err)
 	}
 
-	// Insert sale record into the 'sale' table
-	sale := models.Sale{
-		Name:         product.ProductName,
-		Price:        product.Price,
-		Quantity:     quantitySold,
-		UserID:       userID,
-		Date:         time.Now(),
-		CategoryName: product.CategoryName,
-	}
-	if err := tx.Create(&sale).Error; err != nil {
-		log.Printf("Error inserting sale record: %s", err.Error())
+	var updatedQuantity int
+	if err := tx.Model(&models.Product{}).Where("product_id = ?", productID).Select("quantity").Scan(&updatedQuantity).Error; err != nil {
+		log.Printf("Error querying product quantity: %s", err.Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
 	}
 
 	// Commit the transaction
 	if err := tx.Commit().Error; err != nil {
 		log.Printf("Error committing transaction: %s", err.Error())
+		services.CancelPayments(receipt)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
 	}
 
+	services.TriggerReorderEvaluation()
+
 	// Log successful sale
 	log.Printf("Sold %d units of product ID %d. Remaining quantity: %d", quantitySold, productID, updatedQuantity)
 
 	// Return success response
 	return c.JSON(http.StatusOK, map[string]string{
 		"message":       "Sale processed successfully",
+		"receipt_id":    strconv.Itoa(int(receipt.ReceiptID)),
 		"product_id":    strconv.Itoa(productID),
 		"quantity_sold": strconv.Itoa(quantitySold),
 		"remaining_qty": strconv.Itoa(updatedQuantity),
+		"change_due":    receipt.ChangeDue.String(),
 	})
 }
 
-// getDB is a placeholder function to initialize and return the database connection
-// GetSales fetches all sales from the database.
+// GetSales searches sales. On top of the shared listing parameters it returns
+// totals over every matching sale, not just the page.
 func GetSales(c echo.Context) error {
 	log.Println("Received request to fetch sales")
 
@@ -122,18 +123,56 @@ func GetSales(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to connect to the database")
 	}
 
-	// Query all sales from the Sales table
+	orgID, err := searchOrganizationID(c)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid organization ID")
+	}
+	query := func() *gorm.DB {
+		query := db.Model(&models.Sale{})
+		if orgID != 0 {
+			query = query.Where("organization_id = ?", orgID)
+		}
+		return query
+	}
+
 	var sales []models.Sale
-	if err := db.Find(&sales).Error; err != nil {
-		log.Printf("Error querying sales from database: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
+	page, err := listing.Find(query(), saleListing, c.QueryParams(), &sales)
+	if err != nil {
+		return listError(c, err)
+	}
+
+	filtered, err := listing.Filter(query(), saleListing, c.QueryParams())
+	if err != nil {
+		return listError(c, err)
+	}
+	totals, err := services.SummarizeSales(filtered)
+	if err != nil {
+		log.Printf("Error totalling sales: %s", err.Error())
+		return errorResponse(c, http.StatusInternalServerError, "Failed to total sales")
 	}
 
-	// Log the number of Sales fetched
-	log.Printf("Fetched %d sales", len(sales))
+	return c.JSON(http.StatusOK, struct {
+		*listing.Page
+		Totals services.SaleTotals `json:"totals"`
+	}{page, totals})
+}
 
-	// Return the fetched Sales as JSON
-	return c.JSON(http.StatusOK, sales)
+var saleListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"sale_id":         {Column: "sale_id", Kind: listing.Int, Sort: true},
+		"receipt_id":      {Column: "receipt_id", Kind: listing.Int, Filter: true},
+		"product_id":      {Column: "product_id", Kind: listing.Int, Filter: true},
+		"category_name":   {Column: "category_name", Filter: true, Sort: true},
+		"user_id":         {Column: "user_id", Filter: true},
+		"tax_code":        {Column: "tax_code", Filter: true},
+		"customer_id":     {Column: "customer_id", Kind: listing.Int, Filter: true},
+		"till_session_id": {Column: "till_session_id", Kind: listing.Int, Filter: true},
+		"date":            {Column: "date", Kind: listing.Time, Range: true, Sort: true},
+		"price":           {Column: "price", Kind: listing.Money, Range: true, Sort: true},
+		"line_total":      {Column: "line_total", Kind: listing.Money, Range: true, Sort: true},
+	},
+	Key:         "sale_id",
+	DefaultSort: "-date",
 }
 
 // GetSaleByID fetches a single sale by its ID from the database.
@@ -141,9 +180,9 @@ func GetSaleByID(c echo.Context) error {
 	log.Println("Received request to fetch sale by ID")
 
 	// Extract sale ID from path parameter
-	saleID, err := strconv.Atoi(c.Param("id"))
+	saleID, err := strconv.Atoi(c.Param("sale_id"))
 	if err != nil {
-		log.Printf("Invalid sale ID: %s", c.Param("id"))
+		log.Printf("Invalid sale ID: %s", c.Param("sale_id"))
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid sale ID")
 	}
 
@@ -155,7 +194,7 @@ func GetSaleByID(c echo.Context) error {
 
 	// Query the sale by sale ID
 	var sale models.Sale
-	if err := db.First(&sale, saleID).Error; err != nil {
+	if err := db.Scopes(orgScope(c)).Where("sale_id = ?", saleID).First(&sale).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			log.Printf("Sale not found with ID: %d", saleID)
 			return echo.NewHTTPError(http.StatusNotFound, "Sale not found")
@@ -171,46 +210,12 @@ func GetSaleByID(c echo.Context) error {
 	return c.JSON(http.StatusOK, sale)
 }
 
-// AddSale adds a new sale record to the database.
-func AddSale(c echo.Context) error {
-	// Initialize database connection
-	db := getDB()
-	if db == nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to connect to the database")
-	}
-
-	// Parse JSON manually from request body into Sale struct
-	var sale models.Sale
-	if err := json.NewDecoder(c.Request().Body).Decode(&sale); err != nil {
-		log.Printf("Error decoding JSON: %s", err.Error())
-		return echo.NewHTTPError(http.StatusBadRequest, "Error decoding JSON")
-	}
-
-	// Log the received sale details
-	log.Printf("Received request to create a sale: %+v", sale)
-
-	// Execute the SQL INSERT query to add the sale to the database
-	if err := db.Create(&sale).Error; err != nil {
-		log.Printf("Error inserting sale: %s", err.Error())
-		return echo.NewHTTPError(http.StatusInternalServerError, "Error inserting sale")
-	}
-
-	// Log the creation of the new sale
-	log.Printf("Created new sale with ID: %d", sale.SaleID)
-
-	// Return success response
-	return c.JSON(http.StatusCreated, map[string]interface{}{
-		"message": "Sale created successfully",
-		"sale_id": sale.SaleID,
-	})
-}
-
 // UpdateSale updates an existing sale record in the database.
 func UpdateSale(c echo.Context) error {
 	// Extract sale ID from path parameter
-	saleID, err := strconv.Atoi(c.Param("id"))
+	saleID, err := strconv.Atoi(c.Param("sale_id"))
 	if err != nil {
-		log.Printf("Invalid sale ID: %s", c.Param("id"))
+		log.Printf("Invalid sale ID: %s", c.Param("sale_id"))
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid sale ID")
 	}
 
@@ -229,9 +234,14 @@ func UpdateSale(c echo.Context) error {
 
 	// Log the update details
 	log.Printf("Received request to update sale ID %d: %+v", saleID, sale)
+	if sale.CustomerID != 0 {
+		if _, err := services.FindCustomer(db, currentOrganizationID(c), sale.CustomerID); err != nil {
+			return checkoutErrorResponse(c, err)
+		}
+	}
 
 	// Execute SQL UPDATE query to modify the sale in the database
-	if err := db.Model(&models.Sale{}).Where("sale_id = ?", saleID).Updates(sale).Error; err != nil {
+	if err := db.Model(&models.Sale{}).Scopes(orgScope(c)).Where("sale_id = ?", saleID).Omit("organization_id", "currency").Updates(sale).Error; err != nil {
 		log.Printf("Error updating sale: %s", err.Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, "Error updating sale")
 	}
@@ -243,30 +253,20 @@ func UpdateSale(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"message": "Sale updated successfully"})
 }
 
-// DeleteSale deletes a sale record from the database.
+// DeleteSale voids a sale instead of deleting it, so the stock comes back and
+// the original record is kept for audit.
 func DeleteSale(c echo.Context) error {
 	// Extract sale ID from path parameter
-	saleID, err := strconv.Atoi(c.Param("id"))
+	saleID, err := strconv.Atoi(c.Param("sale_id"))
 	if err != nil {
-		log.Printf("Invalid sale ID: %s", c.Param("id"))
+		log.Printf("Invalid sale ID: %s", c.Param("sale_id"))
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid sale ID")
 	}
 
-	// Initialize database connection
-	db := getDB()
-	if db == nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to connect to the database")
-	}
-
-	// Execute SQL DELETE query to remove the sale from the database
-	if err := db.Delete(&models.Sale{}, saleID).Error; err != nil {
-		log.Printf("Error deleting sale: %s", err.Error())
-		return echo.NewHTTPError(http.StatusInternalServerError, "Error deleting sale")
+	reason := c.QueryParam("reason")
+	if reason == "" {
+		reason = "Sale deleted"
 	}
 
-	// Log the deletion success
-	log.Printf("Deleted sale with ID: %d", saleID)
-
-	// Return success response
-	return c.JSON(http.StatusOK, map[string]string{"message": "Sale deleted successfully"})
+	return voidSale(c, saleID, reason)
 }
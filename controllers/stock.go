@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"stock/listing"
+	models "stock/models"
+	"stock/services"
+	"strconv"
+)
+
+// stockErrorResponse maps stock ledger errors to HTTP errors
+func stockErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrProductNotFound):
+		return errorResponse(c, http.StatusNotFound, "Product not found")
+	case errors.Is(err, services.ErrInsufficientStock):
+		return errorResponse(c, http.StatusBadRequest, "Insufficient quantity")
+	case errors.Is(err, services.ErrInvalidMovement):
+		return errorResponse(c, http.StatusBadRequest, "Invalid stock movement")
+	default:
+		log.Printf("Stock ledger error: %s", err.Error())
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+// GetProductMovements returns the stock ledger for a product, oldest first
+func GetProductMovements(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("product_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid product ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var movements []models.StockMovement
+	query := db.Model(&models.StockMovement{}).Scopes(orgScope(c)).Where("product_id = ?", productID)
+	return listResponse(c, query, stockMovementListing, &movements)
+}
+
+var stockMovementListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":             {Column: "id", Kind: listing.Int, Sort: true},
+		"reason":         {Column: "reason", Filter: true},
+		"user_id":        {Column: "user_id", Kind: listing.Int, Filter: true},
+		"reference_type": {Column: "reference_type", Filter: true},
+		"created_at":     {Column: "created_at", Kind: listing.Time, Range: true, Sort: true},
+	},
+	Key:         "id",
+	DefaultSort: "id",
+}
+
+// AdjustProductStock records a manual adjustment or write-off against a product
+func AdjustProductStock(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("product_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid product ID")
+	}
+
+	var input struct {
+		Delta  int    `json:"delta"`
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
+		// UnitCost is what stock added by the adjustment cost; it defaults to the average cost
+		UnitCost models.Money `json:"unit_cost"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	if input.Reason == "" {
+		input.Reason = models.MovementReasonAdjustment
+	}
+	if input.Reason != models.MovementReasonAdjustment && input.Reason != models.MovementReasonWriteOff {
+		return errorResponse(c, http.StatusBadRequest, "Reason must be adjustment or write_off")
+	}
+	if input.Reason == models.MovementReasonWriteOff && input.Delta > 0 {
+		return errorResponse(c, http.StatusBadRequest, "A write-off must reduce stock")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	movement, err := services.RecordMovement(tx, services.Movement{
+		OrganizationID: currentOrganizationID(c),
+		ProductID:      productID,
+		Delta:          input.Delta,
+		Reason:         input.Reason,
+		UserID:         currentUserID(c),
+		Note:           input.Note,
+		UnitCost:       input.UnitCost,
+	})
+	if err != nil {
+		return stockErrorResponse(c, err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to complete operation")
+	}
+
+	services.TriggerReorderEvaluation()
+	return c.JSON(http.StatusCreated, movement)
+}
+
+// RebuildProductQuantity recomputes a product's cached quantity from its ledger
+func RebuildProductQuantity(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("product_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid product ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	quantity, err := services.RebuildProductQuantity(tx, currentOrganizationID(c), productID)
+	if err != nil {
+		return stockErrorResponse(c, err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to complete operation")
+	}
+
+	log.Printf("Rebuilt quantity for product ID %d: %d", productID, quantity)
+	return c.JSON(http.StatusOK, map[string]int{"product_id": productID, "quantity": quantity})
+}
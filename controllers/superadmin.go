@@ -1,16 +1,18 @@
 package controllers
 
 import (
-	"github.com/golang-jwt/jwt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 	"stock/db"
+	"stock/mailer"
 	"stock/models"
+	"stock/services"
 	"stock/utils"
 	"stock/validators"
 )
@@ -45,20 +47,19 @@ func SuperAdminSignup(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not hash password"})
 	}
 	input.Password = hashedPassword
+	input.IsActive = true
 
 	if err := db.GetDB().Create(&input).Error; err != nil {
 		log.Printf("Create error: %v", err)
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
 
-	token, err := utils.GenerateJWT(input.ID, input.RoleID)
-	if err != nil {
-		log.Printf("GenerateJWT error: %v", err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not generate token"})
-	}
+	actor := auditActor(c)
+	actor.UserID, actor.RoleID = input.ID, input.RoleID
+	services.RecordAuditLogged(db.GetDB(), actor, models.AuditUserCreated, "user", input.ID, nil, input)
 
 	log.Println("Super admin signed up successfully")
-	return c.JSON(http.StatusOK, echo.Map{"token": token})
+	return issueLoginTokens(c, &input)
 }
 
 func AddAdmin(c echo.Context) error {
@@ -120,6 +121,8 @@ func AddAdmin(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
 
+	services.RecordAuditLogged(db.GetDB(), auditActor(c), models.AuditUserCreated, "user", newAdmin.ID, nil, newAdmin)
+
 	log.Println("Admin added successfully")
 	return c.JSON(http.StatusOK, echo.Map{"message": "Admin added successfully", "admin": newAdmin})
 }
@@ -153,12 +156,21 @@ func SuperAdminAddOrganization(c echo.Context) error {
 	}
 
 	newOrganization.RoleID = 5
+	newOrganization.Currency = strings.ToUpper(strings.TrimSpace(newOrganization.Currency))
+	if newOrganization.Currency == "" {
+		newOrganization.Currency = services.DefaultCurrency
+	}
+	if !models.ValidCurrency(newOrganization.Currency) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid currency code"})
+	}
 
 	if err := db.GetDB().Create(&newOrganization).Error; err != nil {
 		log.Printf("Create error: %v", err)
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
 
+	services.RecordAuditLogged(db.GetDB(), auditActorFor(c, newOrganization.ID), models.AuditOrganizationCreated, "organization", newOrganization.ID, nil, newOrganization)
+
 	log.Println("Organization added successfully")
 	return c.JSON(http.StatusOK, echo.Map{"message": "Organization added successfully", "organization": newOrganization})
 }
@@ -229,6 +241,8 @@ func SuperAdminAddOrganizationAdmin(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
 
+	services.RecordAuditLogged(db.GetDB(), auditActorFor(c, newUser.OrganizationID), models.AuditUserCreated, "user", newUser.ID, nil, newUser)
+
 	log.Println("Organization admin added successfully")
 	return c.JSON(http.StatusOK, echo.Map{"message": "Organization admin added successfully", "user": newUser})
 }
@@ -271,80 +285,6 @@ func SuperAdminAddOrganizationAdmin(c echo.Context) error {
 am getting this error on postman  "error": "Access forbidden" and this error on 2024/07/30 15:10:22 Token parsed successfully. UserID: 30, RoleID: 1 Failed to get JWT token from context. help me fix it
 */
 
-func SuperAdminLogin(c echo.Context) error {
-	var input struct {
-		Username string `json:"username" binding:"required"`
-		Password string `json:"password" binding:"required"`
-	}
-
-	if err := c.Bind(&input); err != nil {
-		log.Printf("Bind error: %v", err)
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
-	}
-
-	var user models.User
-	if err := db.GetDB().Where("username = ?", input.Username).First(&user).Error; err != nil {
-		log.Printf("Where error: %v", err)
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid email or password"})
-	}
-
-	if err := utils.CheckPasswordHash(input.Password, user.Password); err != nil {
-		log.Printf("CheckPasswordHash error: %v", err)
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid username or password"})
-	}
-
-	token, err := utils.GenerateJWT(user.ID, user.RoleID)
-	if err != nil {
-		log.Printf("GenerateJWT error: %v", err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not generate token"})
-	}
-
-	log.Println("Super admin logged in successfully")
-	return c.JSON(http.StatusOK, echo.Map{"token": token})
-}
-
-func SuperAdminLogout(c echo.Context) error {
-	log.Println("Super admin logged out successfully")
-	return c.JSON(http.StatusOK, echo.Map{"message": "Successfully logged out"})
-}
-
-func AdminLogin(c echo.Context) error {
-	log.Println("AdminLogin - Entry")
-
-	var input struct {
-		Email    string `json:"email" binding:"required"`
-		Password string `json:"password" binding:"required"`
-	}
-
-	if err := c.Bind(&input); err != nil {
-		log.Printf("AdminLogin - Bind error: %v", err)
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
-	}
-
-	log.Printf("AdminLogin - Received input: %+v", input)
-
-	var user models.User
-	if err := db.GetDB().Where("email = ?", input.Email).First(&user).Error; err != nil {
-		log.Printf("AdminLogin - Where error: %v", err)
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid email or password"})
-	}
-
-	if err := utils.CheckPasswordHash(input.Password, user.Password); err != nil {
-		log.Printf("AdminLogin - CheckPasswordHash error: %v", err)
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid email or password"})
-	}
-
-	token, err := utils.GenerateJWT(user.ID, user.RoleID)
-	if err != nil {
-		log.Printf("AdminLogin - GenerateJWT error: %v", err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not generate token"})
-	}
-
-	log.Println("AdminLogin - Admin logged in successfully")
-	log.Println("AdminLogin - Exit")
-	return c.JSON(http.StatusOK, echo.Map{"token": token})
-}
-
 func GetUserByID(c echo.Context) error {
 	id, _ := strconv.Atoi(c.Param("id"))
 	log.Printf("GetUserByID - Entry with ID: %d", id)
@@ -398,20 +338,57 @@ func AdminAddUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid role ID. Allowed roles: 3 (shopkeeper), 4 (auditor), 2 (admin)"})
 	}
 
-	hashedPassword, err := utils.HashPassword(input.Password)
-	if err != nil {
-		log.Printf("AdminAddUser - HashPassword error: %v", err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not hash password"})
+	// Without a password the user is invited to choose one
+	invite := input.Password == ""
+	if invite {
+		if err := services.InviteUser(&input); err != nil {
+			return invitationErrorResponse(c, err)
+		}
+	} else {
+		hashedPassword, err := utils.HashPassword(input.Password)
+		if err != nil {
+			log.Printf("AdminAddUser - HashPassword error: %v", err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not hash password"})
+		}
+		input.Password = hashedPassword
 	}
-	input.Password = hashedPassword
+	input.CreatedBy = uint(userID)
 
-	if err := db.GetDB().Create(&input).Error; err != nil {
+	tx := db.GetDB().Begin()
+	if tx.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Internal Server Error"})
+	}
+	defer tx.Rollback()
+
+	if err := tx.Create(&input).Error; err != nil {
 		log.Printf("AdminAddUser - Create error: %v", err)
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
+	if err := services.RecordAudit(tx, auditActorFor(c, input.OrganizationID), models.AuditUserCreated, "user", input.ID, nil, input); err != nil {
+		log.Printf("AdminAddUser - Audit error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not create user"})
+	}
+	var invitation *mailer.Message
+	if invite {
+		msg, err := services.CreateInvitation(tx, &input, uint(userID))
+		if err != nil {
+			return invitationErrorResponse(c, err)
+		}
+		invitation = msg
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not create user"})
+	}
+
+	if err := services.SendAccountEmail(invitation); err != nil {
+		return invitationEmailFailed(c, err)
+	}
 
 	log.Println("AdminAddUser - User created successfully")
 	log.Println("AdminAddUser - Exit")
+	if invite {
+		return c.JSON(http.StatusOK, echo.Map{"message": "User invited successfully"})
+	}
 	return c.JSON(http.StatusOK, echo.Map{"message": "User created successfully"})
 }
 
@@ -426,17 +403,39 @@ func EditUser(c echo.Context) error {
 	}
 
 	log.Printf("EditUser - Current user details: %+v", user)
+	before := user
+	currentPassword, wasActive := user.Password, user.IsActive
 
 	if err := c.Bind(&user); err != nil {
 		log.Printf("EditUser - Bind error: %v", err)
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
 	}
 
+	// A new password arrives in plain text and signs the user out everywhere
+	passwordChanged := user.Password != currentPassword
+	if passwordChanged {
+		hashedPassword, err := utils.HashPassword(user.Password)
+		if err != nil {
+			log.Printf("EditUser - HashPassword error: %v", err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not hash password"})
+		}
+		user.Password = hashedPassword
+	}
+
 	if err := db.GetDB().Save(&user).Error; err != nil {
 		log.Printf("EditUser - Save error: %v", err)
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
 
+	if passwordChanged || (wasActive && !user.IsActive) {
+		if err := services.RevokeUserTokens(db.GetDB(), user.ID); err != nil {
+			log.Printf("EditUser - RevokeUserTokens error: %v", err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not revoke user tokens"})
+		}
+	}
+
+	services.RecordAuditLogged(db.GetDB(), auditActorFor(c, user.OrganizationID), models.AuditUserUpdated, "user", user.ID, before, user)
+
 	log.Println("EditUser - User updated successfully")
 	log.Println("EditUser - Exit")
 	return c.JSON(http.StatusOK, user)
@@ -461,14 +460,7 @@ func AdminViewAllUsers(c echo.Context) error {
 	}
 
 	var users []models.User
-	if err := db.GetDB().Find(&users).Error; err != nil {
-		log.Printf("AdminViewAllUsers - Find error: %v", err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not retrieve users"})
-	}
-
-	log.Printf("AdminViewAllUsers - Retrieved users: %+v", users)
-	log.Println("AdminViewAllUsers - Exit")
-	return c.JSON(http.StatusOK, users)
+	return listResponse(c, db.GetDB().Model(&models.User{}), userListing, &users)
 }
 
 func SoftDeleteUser(c echo.Context) error {
@@ -487,6 +479,7 @@ func SoftDeleteUser(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, echo.Map{"error": "User not found"})
 	}
 
+	before := user
 	user.DeletedAt = gorm.DeletedAt{
 		Time:  time.Now(),
 		Valid: true,
@@ -497,6 +490,13 @@ func SoftDeleteUser(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
 
+	if err := services.RevokeUserTokens(db.GetDB(), user.ID); err != nil {
+		log.Printf("SoftDeleteUser - RevokeUserTokens error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not revoke user tokens"})
+	}
+
+	services.RecordAuditLogged(db.GetDB(), auditActorFor(c, user.OrganizationID), models.AuditUserDeleted, "user", user.ID, before, user)
+
 	log.Println("SoftDeleteUser - User soft deleted successfully")
 	log.Println("SoftDeleteUser - Exit")
 	return c.JSON(http.StatusOK, echo.Map{"message": "User soft deleted successfully"})
@@ -510,10 +510,12 @@ func ActivateUser(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"message": "User not found"})
 	}
 
+	before := user
 	user.IsActive = true
 	if err := db.GetDB().Save(&user).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error saving user"})
 	}
+	services.RecordAuditLogged(db.GetDB(), auditActorFor(c, user.OrganizationID), models.AuditUserActivated, "user", user.ID, before, user)
 
 	return c.JSON(http.StatusOK, user)
 }
@@ -526,10 +528,18 @@ func DeactivateUser(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"message": "User not found"})
 	}
 
+	before := user
 	user.IsActive = false
 	if err := db.GetDB().Save(&user).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error saving user"})
 	}
+	services.RecordAuditLogged(db.GetDB(), auditActorFor(c, user.OrganizationID), models.AuditUserDeactivated, "user", user.ID, before, user)
+
+	// Sign the user out of every session at once
+	if err := services.RevokeUserTokens(db.GetDB(), user.ID); err != nil {
+		log.Printf("DeactivateUser - RevokeUserTokens error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error revoking user tokens"})
+	}
 
 	return c.JSON(http.StatusOK, user)
 }
@@ -553,14 +563,7 @@ func GetAllOrganizations(c echo.Context) error {
 	log.Println("GetAllOrganizations - Entry")
 
 	var orgs []models.Organization
-	if err := db.GetDB().Find(&orgs).Error; err != nil {
-		log.Printf("GetAllOrganizations - Find error: %v", err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Error retrieving organizations"})
-	}
-
-	log.Printf("GetAllOrganizations - Organizations found: %+v", orgs)
-	log.Println("GetAllOrganizations - Exit")
-	return c.JSON(http.StatusOK, orgs)
+	return listResponse(c, db.GetDB().Model(&models.Organization{}), organizationListing, &orgs)
 }
 
 // ActivateOrganization activates an organization
@@ -579,12 +582,14 @@ func ActivateOrganization(c echo.Context) error {
 	// Log current status before activation
 	log.Printf("Current status of organization ID %s: IsActive=%v", orgID, org.IsActive)
 
+	before := org
 	org.IsActive = true
 	// Try to save the updated organization
 	if err := db.GetDB().Save(&org).Error; err != nil {
 		log.Printf("Error saving organization ID %s. Error: %v", orgID, err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error saving organization"})
 	}
+	services.RecordAuditLogged(db.GetDB(), auditActorFor(c, org.ID), models.AuditOrganizationActivated, "organization", org.ID, before, org)
 
 	// Log success and updated status
 	log.Printf("Successfully activated organization ID %s. Updated status: IsActive=%v", orgID, org.IsActive)
@@ -607,12 +612,14 @@ func DeactivateOrganization(c echo.Context) error {
 	// Log current status before deactivation
 	log.Printf("Current status of organization ID %s: IsActive=%v", orgID, org.IsActive)
 
+	before := org
 	org.IsActive = false
 	// Try to save the updated organization
 	if err := db.GetDB().Save(&org).Error; err != nil {
 		log.Printf("Error saving organization ID %s. Error: %v", orgID, err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error saving organization"})
 	}
+	services.RecordAuditLogged(db.GetDB(), auditActorFor(c, org.ID), models.AuditOrganizationDeactivated, "organization", org.ID, before, org)
 
 	// Log success and updated status
 	log.Printf("Successfully deactivated organization ID %s. Updated status: IsActive=%v", orgID, org.IsActive)
@@ -621,139 +628,47 @@ func DeactivateOrganization(c echo.Context) error {
 
 func GetActiveUsers(c echo.Context) error {
 	var users []models.User
-	if err := db.GetDB().Where("is_active = ?", true).Find(&users).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error retrieving users"})
-	}
-	return c.JSON(http.StatusOK, users)
+	return listResponse(c, db.GetDB().Model(&models.User{}).Where("is_active = ?", true), userListing, &users)
 }
 
 func GetInactiveUsers(c echo.Context) error {
 	var users []models.User
-	if err := db.GetDB().Where("is_active = ?", false).Find(&users).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error retrieving users"})
-	}
-	return c.JSON(http.StatusOK, users)
-}
-
-func GetActiveOrganizations(c echo.Context) error {
-	var orgs []models.Organization
-	if err := db.GetDB().Where("is_active = ?", true).Find(&orgs).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error retrieving organizations"})
-	}
-	return c.JSON(http.StatusOK, orgs)
-}
-
-func GetInactiveOrganizations(c echo.Context) error {
-	var orgs []models.Organization
-	if err := db.GetDB().Where("is_active = ?", false).Find(&orgs).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error retrieving organizations"})
-	}
-	return c.JSON(http.StatusOK, orgs)
+	return listResponse(c, db.GetDB().Model(&models.User{}).Where("is_active = ?", false), userListing, &users)
 }
 
-func AdminLogout(c echo.Context) error {
-	log.Println("AdminLogout - Entry")
-	log.Println("AdminLogout - Admin logged out successfully")
-	log.Println("AdminLogout - Exit")
-	return c.JSON(http.StatusOK, echo.Map{"message": "Successfully logged out"})
+// GetLockedUsers lists users locked out after too many failed logins
+func GetLockedUsers(c echo.Context) error {
+	var users []models.User
+	return listResponse(c, services.LockedUsers(db.GetDB()).Model(&models.User{}), userListing, &users)
 }
 
-func Login(c echo.Context) error {
-	log.Println("Login - Entry")
-
-	var loginData struct {
-		Email    string `json:"email" binding:"required"`
-		Password string `json:"password" binding:"required"`
-	}
-
-	if err := c.Bind(&loginData); err != nil {
-		log.Printf("Login - Bind error: %v", err)
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
-	}
-
-	log.Printf("Login - Received data: %+v", loginData)
-
+// UnlockUser lifts a lockout before it runs out
+func UnlockUser(c echo.Context) error {
+	userID := c.Param("id")
 	var user models.User
-	if err := db.GetDB().Where("email = ?", loginData.Email).First(&user).Error; err != nil {
-		log.Printf("Login - Where error: %v", err)
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid email or password"})
-	}
 
-	if err := utils.CheckPasswordHash(loginData.Password, user.Password); err != nil {
-		log.Printf("Login - CheckPasswordHash error: %v", err)
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid email or password"})
+	if err := db.GetDB().First(&user, userID).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "User not found"})
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID": user.ID,
-		"roleID": user.RoleID,
-		"exp":    time.Now().Add(time.Hour * 72).Unix(),
-	})
-
-	tokenString, err := token.SignedString(utils.JwtSecret)
-	if err != nil {
-		log.Printf("Login - SignedString error: %v", err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not generate token"})
+	if err := services.UnlockUser(db.GetDB(), user.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error saving user"})
 	}
 
-	log.Println("Login - User logged in successfully")
-	log.Println("Login - Exit")
-	return c.JSON(http.StatusOK, echo.Map{"token": tokenString})
-}
+	unlocked := user
+	unlocked.FailedLoginAttempts, unlocked.LockedUntil = 0, nil
+	services.RecordAuditLogged(db.GetDB(), auditActorFor(c, user.OrganizationID), models.AuditUserUnlocked, "user", user.ID, user, unlocked)
 
-func Logout(c echo.Context) error {
-	log.Println("Logout - Entry")
-	log.Println("Logout - User logged out successfully")
-	log.Println("Logout - Exit")
-	return c.JSON(http.StatusOK, echo.Map{"message": "Successfully logged out"})
+	log.Printf("UnlockUser - UserID %d unlocked by UserID %d", user.ID, currentUserID(c))
+	return c.JSON(http.StatusOK, map[string]string{"message": "User unlocked"})
 }
 
-func AuditorLogin(c echo.Context) error {
-	log.Println("AuditorLogin - Entry")
-
-	var loginData struct {
-		Email    string `json:"email" binding:"required"`
-		Password string `json:"password" binding:"required"`
-	}
-
-	if err := c.Bind(&loginData); err != nil {
-		log.Printf("AuditorLogin - Bind error: %v", err)
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
-	}
-
-	log.Printf("AuditorLogin - Received data: %+v", loginData)
-
-	var user models.User
-	if err := db.GetDB().Where("email = ?", loginData.Email).First(&user).Error; err != nil {
-		log.Printf("AuditorLogin - Where error: %v", err)
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid email or password"})
-	}
-
-	if err := utils.CheckPasswordHash(loginData.Password, user.Password); err != nil {
-		log.Printf("AuditorLogin - CheckPasswordHash error: %v", err)
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid email or password"})
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID": user.ID,
-		"roleID": user.RoleID,
-		"exp":    time.Now().Add(time.Hour * 72).Unix(),
-	})
-
-	tokenString, err := token.SignedString(utils.JwtSecret)
-	if err != nil {
-		log.Printf("AuditorLogin - SignedString error: %v", err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Could not generate token"})
-	}
-
-	log.Println("AuditorLogin - Auditor logged in successfully")
-	log.Println("AuditorLogin - Exit")
-	return c.JSON(http.StatusOK, echo.Map{"token": tokenString})
+func GetActiveOrganizations(c echo.Context) error {
+	var orgs []models.Organization
+	return listResponse(c, db.GetDB().Model(&models.Organization{}).Where("is_active = ?", true), organizationListing, &orgs)
 }
 
-func AuditorLogout(c echo.Context) error {
-	log.Println("AuditorLogout - Entry")
-	log.Println("AuditorLogout - Auditor logged out successfully")
-	log.Println("AuditorLogout - Exit")
-	return c.JSON(http.StatusOK, echo.Map{"message": "Successfully logged out"})
+func GetInactiveOrganizations(c echo.Context) error {
+	var orgs []models.Organization
+	return listResponse(c, db.GetDB().Model(&models.Organization{}).Where("is_active = ?", false), organizationListing, &orgs)
 }
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"stock/listing"
+	models "stock/models"
+	"strconv"
+)
+
+// GetSuppliers fetches all suppliers
+func GetSuppliers(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var suppliers []models.Supplier
+	return listResponse(c, db.Model(&models.Supplier{}).Scopes(orgScope(c)), supplierListing, &suppliers)
+}
+
+var supplierListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":         {Column: "id", Kind: listing.Int, Sort: true},
+		"name":       {Column: "name", Filter: true, Sort: true},
+		"email":      {Column: "email", Filter: true},
+		"is_active":  {Column: "is_active", Kind: listing.Bool, Filter: true},
+		"created_at": {Column: "created_at", Kind: listing.Time, Range: true, Sort: true},
+	},
+	Key:         "id",
+	DefaultSort: "id",
+}
+
+// GetSupplierByID fetches a supplier by its ID
+func GetSupplierByID(c echo.Context) error {
+	supplierID, err := strconv.Atoi(c.Param("supplier_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid supplier ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var supplier models.Supplier
+	if err := db.Scopes(orgScope(c)).Where("id = ?", supplierID).First(&supplier).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return errorResponse(c, http.StatusNotFound, "Supplier not found")
+		}
+		return errorResponse(c, http.StatusInternalServerError, "Failed to fetch supplier")
+	}
+
+	return c.JSON(http.StatusOK, supplier)
+}
+
+// CreateSupplier adds a new supplier
+func CreateSupplier(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var supplier models.Supplier
+	if err := json.NewDecoder(c.Request().Body).Decode(&supplier); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+	if supplier.Name == "" {
+		return errorResponse(c, http.StatusBadRequest, "Supplier name is required")
+	}
+	supplier.ID = 0
+	supplier.OrganizationID = currentOrganizationID(c)
+	supplier.IsActive = true
+
+	if err := db.Create(&supplier).Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error inserting supplier")
+	}
+
+	log.Printf("Created supplier with ID: %d", supplier.ID)
+	return c.JSON(http.StatusCreated, supplier)
+}
+
+// UpdateSupplier updates an existing supplier
+func UpdateSupplier(c echo.Context) error {
+	supplierID, err := strconv.Atoi(c.Param("supplier_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid supplier ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var supplier models.Supplier
+	if err := c.Bind(&supplier); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Failed to parse request body")
+	}
+
+	result := db.Model(&models.Supplier{}).Scopes(orgScope(c)).Where("id = ?", supplierID).Omit("id", "organization_id", "created_at").Updates(supplier)
+	if result.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update supplier")
+	}
+	if result.RowsAffected == 0 {
+		return errorResponse(c, http.StatusNotFound, "Supplier not found")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Supplier updated successfully"})
+}
+
+// DeactivateSupplier marks a supplier inactive while keeping its purchase history
+func DeactivateSupplier(c echo.Context) error {
+	supplierID, err := strconv.Atoi(c.Param("supplier_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid supplier ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	result := db.Model(&models.Supplier{}).Scopes(orgScope(c)).Where("id = ?", supplierID).Update("is_active", false)
+	if result.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to deactivate supplier")
+	}
+	if result.RowsAffected == 0 {
+		return errorResponse(c, http.StatusNotFound, "Supplier not found")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Supplier deactivated successfully"})
+}
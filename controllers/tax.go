@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"stock/listing"
+	"stock/models"
+	"stock/services"
+	"strconv"
+)
+
+// taxErrorResponse maps tax errors to HTTP errors
+func taxErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrTaxRateNotFound):
+		return errorResponse(c, http.StatusNotFound, "Tax rate not found")
+	case errors.Is(err, services.ErrInvalidTaxRate), errors.Is(err, services.ErrInvalidTaxGrouping):
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrDuplicateTaxCode):
+		return errorResponse(c, http.StatusConflict, "Tax code is already in use")
+	case errors.Is(err, services.ErrTaxRateInUse):
+		return errorResponse(c, http.StatusConflict, "Tax rate is still in use")
+	default:
+		log.Printf("Tax error: %s", err.Error())
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+// checkTaxCode rejects a product or category tax code the organization has
+// no rate for. An empty code is fine.
+func checkTaxCode(c echo.Context, code string) error {
+	if code == "" {
+		return nil
+	}
+	if _, err := services.FindTaxRate(getDB(), currentOrganizationID(c), code); err != nil {
+		if errors.Is(err, services.ErrTaxRateNotFound) {
+			return errorResponse(c, http.StatusBadRequest, "Unknown tax code")
+		}
+		return taxErrorResponse(c, err)
+	}
+	return nil
+}
+
+// GetTaxRates lists the organization's tax rates
+func GetTaxRates(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var rates []models.TaxRate
+	return listResponse(c, db.Model(&models.TaxRate{}).Scopes(orgScope(c)), taxRateListing, &rates)
+}
+
+var taxRateListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":    {Column: "id", Kind: listing.Int, Sort: true},
+		"code":  {Column: "code", Filter: true, Sort: true},
+		"class": {Column: "class", Filter: true},
+		"rate":  {Column: "rate", Kind: listing.Money, Range: true, Sort: true},
+	},
+	Key:         "id",
+	DefaultSort: "code",
+}
+
+type taxRateInput struct {
+	Code  string         `json:"code"`
+	Name  string         `json:"name"`
+	Class string         `json:"class"`
+	Rate  models.Percent `json:"rate"`
+}
+
+// CreateTaxRate adds a tax rate to the organization
+func CreateTaxRate(c echo.Context) error {
+	var input taxRateInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	rate := models.TaxRate{
+		OrganizationID: currentOrganizationID(c),
+		Code:           input.Code,
+		Name:           input.Name,
+		Class:          input.Class,
+		Rate:           input.Rate,
+	}
+	if err := services.SaveTaxRate(tx, &rate); err != nil {
+		return taxErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditTaxRateCreated, "tax_rate", rate.ID, nil, rate); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error inserting tax rate")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Error inserting tax rate")
+	}
+
+	log.Printf("Created tax rate %q with ID %d for organization %d", rate.Code, rate.ID, rate.OrganizationID)
+	return c.JSON(http.StatusCreated, rate)
+}
+
+// UpdateTaxRate changes the name, class and rate of a tax rate. The code is
+// fixed, since products and categories refer to it. Sales already made keep
+// the rate they were made at.
+func UpdateTaxRate(c echo.Context) error {
+	rateID, err := strconv.Atoi(c.Param("tax_rate_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid tax rate ID")
+	}
+
+	var input taxRateInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	rate, err := services.LockTaxRate(tx, currentOrganizationID(c), uint(rateID))
+	if err != nil {
+		return taxErrorResponse(c, err)
+	}
+	before := *rate
+	rate.Name = input.Name
+	rate.Class = input.Class
+	rate.Rate = input.Rate
+	if err := services.SaveTaxRate(tx, rate); err != nil {
+		return taxErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditTaxRateUpdated, "tax_rate", rate.ID, before, rate); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update tax rate")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to update tax rate")
+	}
+
+	log.Printf("Updated tax rate ID %d", rate.ID)
+	return c.JSON(http.StatusOK, rate)
+}
+
+// DeleteTaxRate removes a tax rate no product, category or default uses
+func DeleteTaxRate(c echo.Context) error {
+	rateID, err := strconv.Atoi(c.Param("tax_rate_id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Invalid tax rate ID")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	rate, err := services.LockTaxRate(tx, currentOrganizationID(c), uint(rateID))
+	if err != nil {
+		return taxErrorResponse(c, err)
+	}
+	if err := services.DeleteTaxRate(tx, rate); err != nil {
+		return taxErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditTaxRateDeleted, "tax_rate", rate.ID, rate, nil); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to delete tax rate")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to delete tax rate")
+	}
+
+	log.Printf("Deleted tax rate ID %d", rateID)
+	return c.JSON(http.StatusOK, map[string]string{"message": "Tax rate deleted successfully"})
+}
@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"stock/listing"
+	"stock/models"
+	"stock/services"
+	"strconv"
+)
+
+// tillErrorResponse maps till session errors to HTTP errors
+func tillErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrTillSessionNotFound):
+		return errorResponse(c, http.StatusNotFound, "Till session not found")
+	case errors.Is(err, services.ErrTillSessionOpen):
+		return errorResponse(c, http.StatusConflict, "You already have an open till session")
+	case errors.Is(err, services.ErrTillSessionClosed):
+		return errorResponse(c, http.StatusConflict, "Till session is closed")
+	case errors.Is(err, services.ErrInvalidTillEntry), errors.Is(err, services.ErrInvalidCashAmount):
+		return errorResponse(c, http.StatusBadRequest, err.Error())
+	default:
+		log.Printf("Till session error: %s", err.Error())
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+// canManageTills reports whether the user may act on other cashiers' sessions
+func canManageTills(c echo.Context) (bool, error) {
+	roleID, _ := c.Get("roleID").(int)
+	return services.RoleHasPermission(getDB(), uint(roleID), models.PermissionTillManage)
+}
+
+// tillSessionFor loads the session in the till_session_id parameter. Cashiers
+// only see their own sessions; managers see every session of the organization.
+func tillSessionFor(c echo.Context, find func(uint) (*models.TillSession, error)) (*models.TillSession, error) {
+	sessionID, err := strconv.Atoi(c.Param("till_session_id"))
+	if err != nil {
+		return nil, services.ErrTillSessionNotFound
+	}
+	session, err := find(uint(sessionID))
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != currentUserID(c) {
+		manager, err := canManageTills(c)
+		if err != nil {
+			return nil, err
+		}
+		if !manager {
+			return nil, services.ErrTillSessionNotFound
+		}
+	}
+	return session, nil
+}
+
+// GetTillSessions lists the organization's till sessions
+func GetTillSessions(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	var sessions []models.TillSession
+	return listResponse(c, db.Model(&models.TillSession{}).Scopes(orgScope(c)), tillSessionListing, &sessions)
+}
+
+var tillSessionListing = listing.Spec{
+	Fields: map[string]listing.Field{
+		"id":         {Column: "id", Kind: listing.Int, Sort: true},
+		"user_id":    {Column: "user_id", Kind: listing.Int, Filter: true},
+		"status":     {Column: "status", Filter: true},
+		"opened_at":  {Column: "opened_at", Kind: listing.Time, Range: true, Sort: true},
+		"closed_at":  {Column: "closed_at", Kind: listing.Time, Range: true, Sort: true},
+		"over_short": {Column: "over_short", Kind: listing.Money, Range: true, Sort: true},
+	},
+	Key:         "id",
+	DefaultSort: "-opened_at",
+}
+
+// OpenTillSession starts a shift for the authenticated cashier
+func OpenTillSession(c echo.Context) error {
+	var input struct {
+		OpeningFloat models.Money `json:"opening_float"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	session, err := services.OpenTillSession(tx, currentOrganizationID(c), currentUserID(c), input.OpeningFloat)
+	if err != nil {
+		return tillErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditTillOpened, "till_session", session.ID, nil, session); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to open till session")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to open till session")
+	}
+
+	log.Printf("User %d opened till session %d with a float of %s", session.UserID, session.ID, session.OpeningFloat)
+	return c.JSON(http.StatusCreated, session)
+}
+
+// GetCurrentTillSession returns the X-report of the authenticated cashier's open session
+func GetCurrentTillSession(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	session, err := services.CurrentTillSession(db, currentOrganizationID(c), currentUserID(c))
+	if err != nil {
+		return tillErrorResponse(c, err)
+	}
+	report, err := services.BuildTillReport(db, session)
+	if err != nil {
+		return tillErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// GetTillSessionReport returns a session's X-report while it is open and its
+// Z-report once it is closed
+func GetTillSessionReport(c echo.Context) error {
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	session, err := tillSessionFor(c, func(id uint) (*models.TillSession, error) {
+		return services.FindTillSession(db, currentOrganizationID(c), id)
+	})
+	if err != nil {
+		return tillErrorResponse(c, err)
+	}
+	report, err := services.BuildTillReport(db, session)
+	if err != nil {
+		return tillErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// AddTillEntry records cash put into or taken out of an open session
+func AddTillEntry(c echo.Context) error {
+	var entry models.TillEntry
+	if err := json.NewDecoder(c.Request().Body).Decode(&entry); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	session, err := tillSessionFor(c, func(id uint) (*models.TillSession, error) {
+		return services.LockTillSession(tx, currentOrganizationID(c), id)
+	})
+	if err != nil {
+		return tillErrorResponse(c, err)
+	}
+	entry.UserID = currentUserID(c)
+	if err := services.AddTillEntry(tx, session, &entry); err != nil {
+		return tillErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditTillEntryAdded, "till_session", session.ID, nil, entry); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to record till entry")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to record till entry")
+	}
+
+	log.Printf("Recorded %s of %s on till session %d", entry.Kind, entry.Amount, session.ID)
+	return c.JSON(http.StatusCreated, entry)
+}
+
+// CloseTillSession ends a session with the cash counted in the drawer and
+// returns its Z-report
+func CloseTillSession(c echo.Context) error {
+	var input struct {
+		CountedCash *models.Money `json:"counted_cash"`
+		Note        string        `json:"note"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "Error decoding JSON")
+	}
+	if input.CountedCash == nil {
+		return errorResponse(c, http.StatusBadRequest, "counted_cash is required")
+	}
+
+	db := getDB()
+	if db == nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to connect to the database")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	session, err := tillSessionFor(c, func(id uint) (*models.TillSession, error) {
+		return services.LockTillSession(tx, currentOrganizationID(c), id)
+	})
+	if err != nil {
+		return tillErrorResponse(c, err)
+	}
+	before := *session
+	report, err := services.CloseTillSession(tx, session, *input.CountedCash, input.Note, currentUserID(c))
+	if err != nil {
+		return tillErrorResponse(c, err)
+	}
+	if err := services.RecordAudit(tx, auditActor(c), models.AuditTillClosed, "till_session", session.ID, before, session); err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to close till session")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "Failed to close till session")
+	}
+
+	log.Printf("Closed till session %d: expected %s, counted %s, over/short %s",
+		session.ID, session.ExpectedCash, session.CountedCash, session.OverShort)
+	return c.JSON(http.StatusOK, report)
+}
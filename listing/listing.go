@@ -0,0 +1,388 @@
+// Package listing applies the query parameters shared by every collection
+// endpoint: filters, sorting, and offset or cursor pagination.
+//
+//	limit=50             page size, at most MaxLimit
+//	offset=100           skip rows (offset pagination)
+//	cursor=...           continue after a page (cursor pagination); taken from next_cursor
+//	sort=-created_at     sort field, prefixed with - for descending
+//	<field>=value        exact match on a filterable field
+//	<field>_from=...     lower bound (inclusive) on a range field
+//	<field>_to=...       upper bound on a range field; a bare date includes that whole day
+package listing
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"stock/models"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// Kind is how a field's parameter values are parsed
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Float
+	Bool
+	Time
+	// Money is a DECIMAL amount, parsed and compared exactly rather than as a float
+	Money
+)
+
+// Field is a column clients may filter or sort by
+type Field struct {
+	Column string
+	Kind   Kind
+	// Filter allows field=value
+	Filter bool
+	// Range allows field_from and field_to
+	Range bool
+	// Sort allows sort=field. Only sort by columns that are never NULL, or
+	// cursors cannot continue past them.
+	Sort bool
+}
+
+// Spec describes one collection. Field names are the parameter names.
+type Spec struct {
+	Fields map[string]Field
+	// Key is the unique column that breaks sort ties and anchors cursors
+	Key string
+	// DefaultSort is used when no sort is given, e.g. "-created_at"
+	DefaultSort string
+	// Preload names associations to load with each page
+	Preload []string
+}
+
+// Pagination describes the page returned
+type Pagination struct {
+	Total      int64  `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	Sort       string `json:"sort"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Page is the response envelope of every collection endpoint
+type Page struct {
+	Data       interface{} `json:"data"`
+	Pagination Pagination  `json:"pagination"`
+}
+
+// ParamError is a query parameter the client got wrong
+type ParamError struct {
+	Param   string
+	Message string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Message)
+}
+
+type cursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v"`
+	Key   interface{} `json:"k"`
+}
+
+// Find filters, sorts and pages query into dest, a pointer to a slice, and
+// returns the page with the total count of matching rows
+func Find(query *gorm.DB, spec Spec, params url.Values, dest interface{}) (*Page, error) {
+	limit, err := intParam(params, "limit", DefaultLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return nil, &ParamError{"limit", "must be positive"}
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	offset, err := intParam(params, "offset", 0)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, &ParamError{"offset", "must not be negative"}
+	}
+	if offset > 0 && params.Get("cursor") != "" {
+		return nil, &ParamError{"offset", "cannot be combined with cursor"}
+	}
+
+	sort := params.Get("sort")
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+	sortName := strings.TrimPrefix(sort, "-")
+	desc := strings.HasPrefix(sort, "-")
+	sortField, ok := spec.Fields[sortName]
+	if !ok || !sortField.Sort {
+		return nil, &ParamError{"sort", fmt.Sprintf("cannot sort by %q", sortName)}
+	}
+
+	query, err = Filter(query, spec, params)
+	if err != nil {
+		return nil, err
+	}
+	// Later calls must not change the count query
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+	page := query
+	if raw := params.Get("cursor"); raw != "" {
+		after, err := decodeCursor(raw, sort, sortField.Kind)
+		if err != nil {
+			return nil, err
+		}
+		if sortField.Column == spec.Key {
+			page = page.Where(fmt.Sprintf("%s %s ?", spec.Key, comparison), after.Key)
+		} else {
+			value := placeholder(sortField.Kind)
+			page = page.Where(fmt.Sprintf("((%s %s %s) OR (%s = %s AND %s %s ?))",
+				sortField.Column, comparison, value, sortField.Column, value, spec.Key, comparison),
+				after.Value, after.Value, after.Key)
+		}
+	}
+	page = page.Order(sortField.Column + " " + direction)
+	if sortField.Column != spec.Key {
+		page = page.Order(spec.Key + " " + direction)
+	}
+	for _, association := range spec.Preload {
+		page = page.Preload(association)
+	}
+
+	// One extra row tells whether there is another page
+	result := page.Offset(offset).Limit(limit + 1).Find(dest)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	rows := reflect.ValueOf(dest).Elem()
+	if rows.IsNil() {
+		rows.Set(reflect.MakeSlice(rows.Type(), 0, 0))
+	}
+	pagination := Pagination{Total: total, Limit: limit, Offset: offset, Sort: sort}
+	if rows.Len() > limit {
+		rows.Set(rows.Slice(0, limit))
+		pagination.HasMore = true
+		next, err := encodeCursor(result, rows.Index(limit-1), sort, sortField.Column, spec.Key)
+		if err != nil {
+			return nil, err
+		}
+		pagination.NextCursor = next
+	}
+
+	return &Page{Data: rows.Interface(), Pagination: pagination}, nil
+}
+
+// Filter applies only the field filters of params, for queries that summarize
+// the same rows a listing pages through
+func Filter(query *gorm.DB, spec Spec, params url.Values) (*gorm.DB, error) {
+	// Walk fields in name order so the same request builds the same SQL
+	names := make([]string, 0, len(spec.Fields))
+	for name := range spec.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := spec.Fields[name]
+		if field.Filter {
+			if raw := params.Get(name); raw != "" {
+				value, err := parseValue(name, raw, field.Kind, false)
+				if err != nil {
+					return nil, err
+				}
+				query = query.Where(field.Column+" = "+placeholder(field.Kind), value)
+			}
+		}
+		if field.Range {
+			if raw := params.Get(name + "_from"); raw != "" {
+				value, err := parseValue(name+"_from", raw, field.Kind, false)
+				if err != nil {
+					return nil, err
+				}
+				query = query.Where(field.Column+" >= "+placeholder(field.Kind), value)
+			}
+			if raw := params.Get(name + "_to"); raw != "" {
+				value, err := parseValue(name+"_to", raw, field.Kind, true)
+				if err != nil {
+					return nil, err
+				}
+				// A bare date was moved to the next day, so it is excluded
+				op := " <= "
+				if field.Kind == Time && isDate(raw) {
+					op = " < "
+				}
+				query = query.Where(field.Column+op+placeholder(field.Kind), value)
+			}
+		}
+	}
+	return query, nil
+}
+
+func parseValue(param, raw string, kind Kind, upper bool) (interface{}, error) {
+	switch kind {
+	case Int:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, &ParamError{param, "must be a whole number"}
+		}
+		return n, nil
+	case Float:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, &ParamError{param, "must be a number"}
+		}
+		return f, nil
+	case Money:
+		m, err := models.ParseMoney(raw)
+		if err != nil {
+			return nil, &ParamError{param, "must be a decimal amount"}
+		}
+		return m, nil
+	case Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, &ParamError{param, "must be true or false"}
+		}
+		return b, nil
+	case Time:
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			return t, nil
+		}
+		t, err := time.ParseInLocation("2006-01-02", raw, time.Local)
+		if err != nil {
+			return nil, &ParamError{param, "must be a date (YYYY-MM-DD) or RFC 3339 time"}
+		}
+		if upper {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	default:
+		return raw, nil
+	}
+}
+
+// placeholder is the SQL a parameter of kind is bound with. Money is sent as
+// a decimal string, which MySQL would compare with a DECIMAL column as a
+// float unless it is cast back.
+func placeholder(kind Kind) string {
+	if kind == Money {
+		return "CAST(? AS DECIMAL(19,4))"
+	}
+	return "?"
+}
+
+func isDate(raw string) bool {
+	_, err := time.Parse("2006-01-02", raw)
+	return err == nil
+}
+
+func intParam(params url.Values, name string, fallback int) (int, error) {
+	raw := params.Get(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, &ParamError{name, "must be a whole number"}
+	}
+	return n, nil
+}
+
+// encodeCursor records the sort and key values of the last row on a page
+func encodeCursor(result *gorm.DB, row reflect.Value, sort, sortColumn, keyColumn string) (string, error) {
+	if result.Statement.Schema == nil {
+		return "", fmt.Errorf("listing: no schema to read cursor values from")
+	}
+	value, err := columnValue(result, row, sortColumn)
+	if err != nil {
+		return "", err
+	}
+	key, err := columnValue(result, row, keyColumn)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(cursor{Sort: sort, Value: value, Key: key})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func columnValue(result *gorm.DB, row reflect.Value, column string) (interface{}, error) {
+	field := result.Statement.Schema.LookUpField(column)
+	if field == nil {
+		return nil, fmt.Errorf("listing: no field for column %s", column)
+	}
+	value, _ := field.ValueOf(result.Statement.Context, reflect.Indirect(row))
+	return value, nil
+}
+
+func decodeCursor(raw, sort string, kind Kind) (*cursor, error) {
+	invalid := &ParamError{"cursor", "is not a cursor from this listing"}
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, invalid
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var c cursor
+	if err := decoder.Decode(&c); err != nil || c.Sort != sort {
+		return nil, invalid
+	}
+
+	c.Value = cursorValue(c.Value, kind)
+	c.Key = cursorValue(c.Key, Int)
+	return &c, nil
+}
+
+// cursorValue turns a decoded JSON value back into what the column holds.
+// Times come back as strings and must be compared as times, and amounts as
+// exact decimals.
+func cursorValue(v interface{}, kind Kind) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if kind == Money {
+			if m, err := models.ParseMoney(v.String()); err == nil {
+				return m
+			}
+		}
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	case string:
+		if kind == Time {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t
+			}
+		}
+	}
+	return v
+}
@@ -0,0 +1,82 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email
+type Mailer interface {
+	Send(msg Message) error
+}
+
+var (
+	mu      sync.RWMutex
+	current Mailer = &LogMailer{}
+)
+
+// Default returns the mailer the application sends email with
+func Default() Mailer {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// SetDefault replaces the mailer the application sends email with
+func SetDefault(m Mailer) {
+	mu.Lock()
+	current = m
+	mu.Unlock()
+}
+
+// FromEnv builds a mailer from MAIL_DRIVER:
+//
+//   - "smtp": SMTPMailer configured by SMTP_HOST, SMTP_PORT, SMTP_USERNAME,
+//     SMTP_PASSWORD and MAIL_FROM.
+//   - "file": LogMailer writing each message to a file in MAIL_DIR.
+//   - "log": LogMailer writing messages to the server log.
+//
+// Unset falls back to "log" only when APP_ENV is "development"; anywhere else
+// it is an error, since the log would then hold every emailed link.
+func FromEnv() (Mailer, error) {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "smtp":
+		m := &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		if m.Host == "" || m.From == "" {
+			return nil, fmt.Errorf("SMTP_HOST and MAIL_FROM are required for the smtp mail driver")
+		}
+		if m.Port == "" {
+			m.Port = "587"
+		}
+		return m, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("MAIL_DIR is required for the file mail driver")
+		}
+		return &LogMailer{Dir: dir}, nil
+	case "":
+		if os.Getenv("APP_ENV") != "development" {
+			return nil, fmt.Errorf("MAIL_DRIVER is required unless APP_ENV is development")
+		}
+		return &LogMailer{}, nil
+	case "log":
+		return &LogMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
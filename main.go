@@ -5,15 +5,122 @@ import (
 	"log"
 	"os"
 	"stock/db"
+	"stock/mailer"
+	"stock/middlewares"
+	"stock/models"
+	"stock/payments"
+	"stock/ratelimit"
 	"stock/routes"
+	"stock/services"
+	"stock/utils"
+	"strconv"
+	"time"
 )
 
 func main() {
 	// Initialize the database
 	db.Init() // Changed from InitDB to Init
 
+	// Load the JWT signing keys; tokens cannot be issued without one
+	if err := utils.LoadSigningKeys(); err != nil {
+		log.Fatalf("Error loading JWT signing keys: %v", err)
+	}
+
+	// Email goes through the configured mailer; only development may leave it unset and log it
+	m, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatalf("Error configuring mailer: %v", err)
+	}
+	mailer.SetDefault(m)
+
+	// Card and mobile money payments go through the configured provider; only
+	// development may leave it unset and use the fake, which moves no money
+	provider, err := payments.FromEnv()
+	if err != nil {
+		log.Fatalf("Error configuring payment provider: %v", err)
+	}
+	payments.SetDefault(provider)
+
+	// Refunds of returns that committed before a restart still have to reach the provider
+	go services.SettlePendingRefunds(db.GetDB())
+
+	// Login throttling counts attempts in this process or, with several
+	// instances, in the database
+	limiter, err := ratelimit.FromEnv(db.GetDB())
+	if err != nil {
+		log.Fatalf("Error configuring rate limiter: %v", err)
+	}
+	ratelimit.SetDefault(limiter)
+	if n, err := strconv.Atoi(os.Getenv("AUTH_IP_RATE_LIMIT")); err == nil {
+		services.AuthIPLimit = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUTH_ACCOUNT_RATE_LIMIT")); err == nil {
+		services.AuthAccountLimit = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_MAX_FAILURES")); err == nil {
+		services.MaxLoginFailures = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT_DURATION")); err == nil && d > 0 {
+		services.LockoutDuration = d
+	}
+
+	// Flag low stock on a schedule as well as after each sale
+	interval, err := time.ParseDuration(os.Getenv("REORDER_EVALUATION_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 15 * time.Minute // Default interval if not specified
+	}
+	services.StartReorderEvaluator(db.GetDB(), interval)
+
+	// Reports group days in this time zone unless a request asks for another
+	if tz := os.Getenv("REPORT_TIME_ZONE"); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			log.Fatalf("Error loading REPORT_TIME_ZONE: %v", err)
+		}
+		services.ReportLocation = location
+	}
+
+	// New organizations are in this currency unless they pick another
+	if currency := os.Getenv("DEFAULT_CURRENCY"); currency != "" {
+		if !models.ValidCurrency(currency) {
+			log.Fatalf("Invalid DEFAULT_CURRENCY: %s", currency)
+		}
+		services.DefaultCurrency = currency
+	}
+
+	// Cashiers need a manager's approval to go further below the list price
+	if percent, err := models.ParseMoney(os.Getenv("PRICE_OVERRIDE_APPROVAL_PERCENT")); err == nil && percent >= 0 {
+		services.PriceOverrideApprovalPercent = percent
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("PRICE_APPROVAL_TTL")); err == nil && ttl > 0 {
+		services.PriceApprovalTTL = ttl
+	}
+
+	// Token lifetimes can be tuned per deployment
+	if ttl, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && ttl > 0 {
+		utils.AccessTokenTTL = ttl
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && ttl > 0 {
+		services.RefreshTokenTTL = ttl
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("INVITATION_TTL")); err == nil && ttl > 0 {
+		services.InvitationTTL = ttl
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL")); err == nil && ttl > 0 {
+		services.PasswordResetTTL = ttl
+	}
+
 	// Create a new Echo instance
 	e := echo.New()
+	// Client IPs are only read from X-Forwarded-For behind a trusted proxy,
+	// otherwise anyone could pick the IP they are rate limited as
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+	// Tag every request with an ID and record authenticated changes in the audit trail
+	e.Use(middlewares.RequestID, middlewares.AuditRequests)
 	// Set up routes
 	routes.RegisterRoutes(e) // Only pass the Echo instance
 	routes.SetupRoutes(e)
@@ -0,0 +1,25 @@
+-- Migration script to store refresh tokens and revoked access tokens
+
+ALTER TABLE users ADD COLUMN tokens_valid_after TIMESTAMP NULL;
+
+CREATE TABLE refresh_tokens (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id INT UNSIGNED NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL,
+    replaced_by_id INT UNSIGNED NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+CREATE TABLE revoked_tokens (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    token_id VARCHAR(64) NOT NULL UNIQUE,
+    user_id INT UNSIGNED,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
@@ -0,0 +1,13 @@
+-- Migration script for login rate limiting and account lockout
+
+CREATE TABLE rate_limits (
+    bucket VARCHAR(255) NOT NULL PRIMARY KEY,
+    hits INT NOT NULL DEFAULT 0,
+    reset_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_rate_limits_reset_at ON rate_limits (reset_at);
+
+ALTER TABLE users
+    ADD COLUMN failed_login_attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN locked_until TIMESTAMP NULL;
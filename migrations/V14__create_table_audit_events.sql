@@ -0,0 +1,33 @@
+-- Migration script for the audit trail
+
+CREATE TABLE audit_events (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL DEFAULT 0,
+    actor_id INT UNSIGNED NOT NULL DEFAULT 0,
+    actor_role_id INT UNSIGNED NOT NULL DEFAULT 0,
+    action VARCHAR(64) NOT NULL,
+    entity_type VARCHAR(64),
+    entity_id INT UNSIGNED NOT NULL DEFAULT 0,
+    changes JSON,
+    method VARCHAR(10),
+    path VARCHAR(255),
+    status INT NOT NULL DEFAULT 0,
+    ip VARCHAR(45),
+    request_id VARCHAR(64),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_events_organization_created ON audit_events (organization_id, created_at);
+CREATE INDEX idx_audit_events_actor_id ON audit_events (actor_id);
+CREATE INDEX idx_audit_events_action ON audit_events (action);
+CREATE INDEX idx_audit_events_entity ON audit_events (entity_type, entity_id);
+CREATE INDEX idx_audit_events_request_id ON audit_events (request_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('audit:read', 'View the audit trail');
+
+-- Auditors and admins read the audit trail
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (1, 2, 4, 6, 8)
+  AND p.name = 'audit:read';
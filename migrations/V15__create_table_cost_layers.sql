@@ -0,0 +1,41 @@
+-- Migration script for inventory costing: cost layers, average cost and cost of goods sold
+
+ALTER TABLE organizations
+    ADD COLUMN costing_method VARCHAR(20) NOT NULL DEFAULT 'fifo';
+
+ALTER TABLE products
+    ADD COLUMN average_cost DOUBLE(12,4) NOT NULL DEFAULT 0;
+
+ALTER TABLE stock_movements
+    ADD COLUMN unit_cost DOUBLE(12,4) NOT NULL DEFAULT 0,
+    ADD COLUMN value_delta DOUBLE(12,2) NOT NULL DEFAULT 0;
+
+ALTER TABLE sales
+    ADD COLUMN cost_of_goods DOUBLE(12,2) NOT NULL DEFAULT 0;
+
+CREATE TABLE cost_layers (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    product_id INT NOT NULL,
+    movement_id INT UNSIGNED,
+    quantity INT NOT NULL,
+    remaining INT NOT NULL,
+    unit_cost DOUBLE(12,4) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_cost_layers_product_remaining ON cost_layers (product_id, remaining);
+CREATE INDEX idx_cost_layers_organization_id ON cost_layers (organization_id);
+
+-- Stock already on hand is costed at its last receipt cost, or zero if it was never received
+UPDATE products p
+SET p.average_cost = COALESCE((
+    SELECT gr.unit_cost FROM goods_receipts gr
+    WHERE gr.product_id = p.product_id
+    ORDER BY gr.id DESC LIMIT 1
+), 0);
+
+INSERT INTO cost_layers (organization_id, product_id, quantity, remaining, unit_cost)
+SELECT organization_id, product_id, quantity, quantity, average_cost
+FROM products
+WHERE quantity > 0;
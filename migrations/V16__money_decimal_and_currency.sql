@@ -0,0 +1,40 @@
+-- Migration script to store money as exact decimals in a currency per organization.
+-- Amounts keep four decimal places so unit costs stay exact; amounts charged to
+-- customers are rounded to the currency's minor unit by the application.
+
+ALTER TABLE organizations
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+
+ALTER TABLE products
+    MODIFY COLUMN price DECIMAL(19,4),
+    MODIFY COLUMN average_cost DECIMAL(19,4) NOT NULL DEFAULT 0;
+
+ALTER TABLE sales
+    MODIFY COLUMN price DECIMAL(19,4),
+    MODIFY COLUMN line_total DECIMAL(19,4) NOT NULL DEFAULT 0,
+    MODIFY COLUMN cost_of_goods DECIMAL(19,4) NOT NULL DEFAULT 0,
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+
+ALTER TABLE receipts
+    MODIFY COLUMN total_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+
+ALTER TABLE sale_returns
+    MODIFY COLUMN amount DECIMAL(19,4) NOT NULL DEFAULT 0;
+
+ALTER TABLE purchase_order_lines
+    MODIFY COLUMN unit_cost DECIMAL(19,4) NOT NULL DEFAULT 0;
+
+ALTER TABLE goods_receipts
+    MODIFY COLUMN unit_cost DECIMAL(19,4) NOT NULL DEFAULT 0;
+
+ALTER TABLE stock_movements
+    MODIFY COLUMN unit_cost DECIMAL(19,4) NOT NULL DEFAULT 0,
+    MODIFY COLUMN value_delta DECIMAL(19,4) NOT NULL DEFAULT 0;
+
+ALTER TABLE cost_layers
+    MODIFY COLUMN unit_cost DECIMAL(19,4) NOT NULL DEFAULT 0;
+
+-- Existing sales and receipts are in their organization's currency
+UPDATE sales s JOIN organizations o ON o.id = s.organization_id SET s.currency = o.currency;
+UPDATE receipts r JOIN organizations o ON o.id = r.organization_id SET r.currency = o.currency;
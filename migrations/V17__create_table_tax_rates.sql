@@ -0,0 +1,49 @@
+-- Migration script for the tax engine: tax rates, tax settings and a tax breakdown on every sale line
+
+CREATE TABLE tax_rates (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    code VARCHAR(32) NOT NULL,
+    name VARCHAR(255),
+    class VARCHAR(20) NOT NULL DEFAULT 'taxable',
+    rate DECIMAL(19,4) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_tax_rates_organization_code (organization_id, code)
+);
+
+ALTER TABLE organizations
+    ADD COLUMN prices_include_tax BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN default_tax_code VARCHAR(32) NOT NULL DEFAULT '';
+
+ALTER TABLE categories ADD COLUMN tax_code VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE products ADD COLUMN tax_code VARCHAR(32) NOT NULL DEFAULT '';
+
+ALTER TABLE sales
+    ADD COLUMN tax_code VARCHAR(32) NOT NULL DEFAULT '',
+    ADD COLUMN tax_class VARCHAR(20) NOT NULL DEFAULT '',
+    ADD COLUMN tax_rate DECIMAL(19,4) NOT NULL DEFAULT 0,
+    ADD COLUMN price_includes_tax BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN net_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    ADD COLUMN tax_amount DECIMAL(19,4) NOT NULL DEFAULT 0;
+
+ALTER TABLE receipts ADD COLUMN total_tax DECIMAL(19,4) NOT NULL DEFAULT 0;
+ALTER TABLE sale_returns ADD COLUMN tax_amount DECIMAL(19,4) NOT NULL DEFAULT 0;
+
+-- Sales made before tax was tracked carried no tax
+UPDATE sales SET net_amount = line_total;
+
+INSERT INTO permissions (name, description) VALUES
+    ('tax:read', 'View tax rates and settings'),
+    ('tax:write', 'Manage tax rates and settings');
+
+-- Admins manage tax; auditors can read it
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (1, 2, 4, 6, 8)
+  AND p.name = 'tax:read';
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (1, 2, 6)
+  AND p.name = 'tax:write';
@@ -0,0 +1,64 @@
+-- Migration script for promotions, coupon codes and manager-approved price overrides
+
+CREATE TABLE promotions (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    value DECIMAL(19,4) NOT NULL DEFAULT 0,
+    buy_quantity INT NOT NULL DEFAULT 0,
+    get_quantity INT NOT NULL DEFAULT 0,
+    product_id INT NOT NULL DEFAULT 0,
+    category_name VARCHAR(100) NOT NULL DEFAULT '',
+    coupon_code VARCHAR(64) NOT NULL DEFAULT '',
+    starts_at DATETIME NULL,
+    ends_at DATETIME NULL,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_promotions_organization_active ON promotions (organization_id, is_active);
+
+CREATE TABLE price_approvals (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    product_id INT NOT NULL,
+    min_price DECIMAL(19,4) NOT NULL DEFAULT 0,
+    token_hash CHAR(64) NOT NULL,
+    approved_by INT UNSIGNED NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME NULL,
+    sale_id INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_price_approvals_token_hash (token_hash)
+);
+
+CREATE INDEX idx_price_approvals_organization_id ON price_approvals (organization_id);
+
+ALTER TABLE sales
+    ADD COLUMN list_price DECIMAL(19,4) NOT NULL DEFAULT 0,
+    ADD COLUMN promotion_id INT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN discount_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    ADD COLUMN override_approved_by INT UNSIGNED NOT NULL DEFAULT 0;
+
+ALTER TABLE receipts ADD COLUMN total_discount DECIMAL(19,4) NOT NULL DEFAULT 0;
+
+-- Sales so far were made at the product's price
+UPDATE sales SET list_price = COALESCE(price, 0);
+
+INSERT INTO permissions (name, description) VALUES
+    ('promotions:read', 'View promotions'),
+    ('promotions:write', 'Manage promotions'),
+    ('prices:approve', 'Approve price overrides below the threshold');
+
+-- Everyone who sells can see promotions; admins manage them and approve overrides
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (1, 2, 3, 4, 6, 7, 8)
+  AND p.name = 'promotions:read';
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (1, 2, 6)
+  AND p.name IN ('promotions:write', 'prices:approve');
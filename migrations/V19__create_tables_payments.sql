@@ -0,0 +1,48 @@
+-- Migration script for receipt payments, their refunds and store credit
+
+CREATE TABLE payments (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    receipt_id INT UNSIGNED NOT NULL,
+    tender VARCHAR(20) NOT NULL,
+    amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    tendered DECIMAL(19,4) NOT NULL DEFAULT 0,
+    `change` DECIMAL(19,4) NOT NULL DEFAULT 0,
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    reference VARCHAR(255) NOT NULL DEFAULT '',
+    provider VARCHAR(32) NOT NULL DEFAULT '',
+    provider_ref VARCHAR(255) NOT NULL DEFAULT '',
+    refunded_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_payments_organization_id ON payments (organization_id);
+CREATE INDEX idx_payments_receipt_id ON payments (receipt_id);
+
+CREATE TABLE payment_refunds (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    payment_id INT UNSIGNED NOT NULL,
+    sale_return_id INT UNSIGNED NOT NULL,
+    tender VARCHAR(20) NOT NULL,
+    amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    provider_ref VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_payment_refunds_organization_id ON payment_refunds (organization_id);
+CREATE INDEX idx_payment_refunds_payment_id ON payment_refunds (payment_id);
+CREATE INDEX idx_payment_refunds_sale_return_id ON payment_refunds (sale_return_id);
+
+CREATE TABLE store_credits (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    code VARCHAR(32) NOT NULL,
+    balance DECIMAL(19,4) NOT NULL DEFAULT 0,
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_store_credits_organization_code (organization_id, code)
+);
+
+ALTER TABLE receipts ADD COLUMN change_due DECIMAL(19,4) NOT NULL DEFAULT 0;
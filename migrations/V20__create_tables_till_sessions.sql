@@ -0,0 +1,59 @@
+-- Migration script for cashier till sessions, cash entries and reconciliation
+
+CREATE TABLE till_sessions (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    status VARCHAR(10) NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    opening_float DECIMAL(19,4) NOT NULL DEFAULT 0,
+    opened_at DATETIME NOT NULL,
+    closed_at DATETIME NULL,
+    closed_by INT UNSIGNED NOT NULL DEFAULT 0,
+    expected_cash DECIMAL(19,4) NOT NULL DEFAULT 0,
+    counted_cash DECIMAL(19,4) NOT NULL DEFAULT 0,
+    over_short DECIMAL(19,4) NOT NULL DEFAULT 0,
+    note TEXT
+);
+
+CREATE INDEX idx_till_sessions_organization_user_status ON till_sessions (organization_id, user_id, status);
+CREATE INDEX idx_till_sessions_organization_opened_at ON till_sessions (organization_id, opened_at);
+
+CREATE TABLE till_entries (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    till_session_id INT UNSIGNED NOT NULL,
+    kind VARCHAR(10) NOT NULL,
+    amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    user_id INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_till_entries_organization_id ON till_entries (organization_id);
+CREATE INDEX idx_till_entries_till_session_id ON till_entries (till_session_id);
+
+ALTER TABLE receipts ADD COLUMN till_session_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE sales ADD COLUMN till_session_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE payments ADD COLUMN till_session_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE payment_refunds ADD COLUMN till_session_id INT UNSIGNED NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_receipts_till_session_id ON receipts (till_session_id);
+CREATE INDEX idx_sales_till_session_id ON sales (till_session_id);
+CREATE INDEX idx_payments_till_session_id ON payments (till_session_id);
+CREATE INDEX idx_payment_refunds_till_session_id ON payment_refunds (till_session_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('till:operate', 'Open, run and close your own till session'),
+    ('till:manage', 'View and close every cashier''s till sessions');
+
+-- Everyone who sells runs a till; admins manage every till
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (1, 2, 3, 6, 7)
+  AND p.name = 'till:operate';
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (1, 2, 6)
+  AND p.name = 'till:manage';
@@ -0,0 +1,79 @@
+-- Migration script for customers, credit sales and accounts receivable
+
+CREATE TABLE customers (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    phone VARCHAR(50) NOT NULL DEFAULT '',
+    address TEXT,
+    tax_number VARCHAR(64) NOT NULL DEFAULT '',
+    credit_limit DECIMAL(19,4) NOT NULL DEFAULT 0,
+    payment_terms_days INT NOT NULL DEFAULT 30,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_customers_organization_name ON customers (organization_id, name);
+
+CREATE TABLE invoices (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    customer_id INT UNSIGNED NOT NULL,
+    receipt_id INT UNSIGNED NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    credited_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    paid_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    status VARCHAR(10) NOT NULL,
+    issued_at DATETIME NOT NULL,
+    due_date DATETIME NOT NULL,
+    paid_at DATETIME NULL,
+    UNIQUE KEY uq_invoices_receipt_id (receipt_id)
+);
+
+CREATE INDEX idx_invoices_organization_status_due ON invoices (organization_id, status, due_date);
+CREATE INDEX idx_invoices_customer_id ON invoices (customer_id);
+
+CREATE TABLE invoice_payments (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL,
+    invoice_id INT UNSIGNED NOT NULL,
+    customer_id INT UNSIGNED NOT NULL,
+    tender VARCHAR(20) NOT NULL,
+    amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    reference VARCHAR(255) NOT NULL DEFAULT '',
+    provider VARCHAR(32) NOT NULL DEFAULT '',
+    provider_ref VARCHAR(255) NOT NULL DEFAULT '',
+    till_session_id INT UNSIGNED NOT NULL DEFAULT 0,
+    user_id INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_invoice_payments_organization_id ON invoice_payments (organization_id);
+CREATE INDEX idx_invoice_payments_invoice_id ON invoice_payments (invoice_id);
+CREATE INDEX idx_invoice_payments_customer_id ON invoice_payments (customer_id);
+CREATE INDEX idx_invoice_payments_till_session_id ON invoice_payments (till_session_id);
+
+ALTER TABLE receipts ADD COLUMN customer_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE sales ADD COLUMN customer_id INT UNSIGNED NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_receipts_customer_id ON receipts (customer_id);
+CREATE INDEX idx_sales_customer_id ON sales (customer_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('customers:read', 'View customers, invoices and statements'),
+    ('customers:write', 'Manage customers'),
+    ('invoices:pay', 'Take payments against customer invoices');
+
+-- Everyone who sells can look up and add customers and take invoice payments
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (1, 2, 3, 4, 6, 7, 8)
+  AND p.name = 'customers:read';
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (1, 2, 3, 6, 7)
+  AND p.name IN ('customers:write', 'invoices:pay');
@@ -0,0 +1,10 @@
+-- Migration script moving store credit issuing from sales:refund to its own permission
+
+INSERT INTO permissions (name, description) VALUES
+    ('store_credits:issue', 'Issue store credit with an opening balance');
+
+-- Cashiers can refund but not mint credit; only admins issue it
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (1, 2, 6)
+  AND p.name = 'store_credits:issue';
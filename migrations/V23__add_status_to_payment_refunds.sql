@@ -0,0 +1,10 @@
+-- Migration script for provider refunds settled after the return commits
+
+ALTER TABLE payment_refunds ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'settled';
+ALTER TABLE payment_refunds ADD COLUMN charge_ref VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE payment_refunds ADD COLUMN currency CHAR(3) NOT NULL DEFAULT '';
+
+UPDATE payment_refunds r JOIN payments p ON p.id = r.payment_id
+SET r.charge_ref = p.provider_ref, r.currency = p.currency;
+
+CREATE INDEX idx_payment_refunds_status ON payment_refunds (status);
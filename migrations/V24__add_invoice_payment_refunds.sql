@@ -0,0 +1,6 @@
+-- Migration script for refunding returns on paid invoices through the invoice's payments
+
+ALTER TABLE invoice_payments ADD COLUMN refunded_amount DECIMAL(19,4) NOT NULL DEFAULT 0;
+ALTER TABLE payment_refunds ADD COLUMN invoice_payment_id INT UNSIGNED NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_payment_refunds_invoice_payment_id ON payment_refunds (invoice_payment_id);
@@ -0,0 +1,3 @@
+-- Migration script storing token cutoffs to the microsecond, like the iat_us claim
+
+ALTER TABLE users MODIFY COLUMN tokens_valid_after TIMESTAMP(6) NULL;
@@ -0,0 +1,3 @@
+-- Migration script recording when a draft or sent purchase order was cancelled
+
+ALTER TABLE purchase_orders ADD COLUMN cancelled_at TIMESTAMP NULL AFTER closed_at;
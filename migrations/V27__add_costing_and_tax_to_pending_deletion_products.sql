@@ -0,0 +1,7 @@
+-- Migration script keeping pending_deletion_products in step with products, so
+-- a product moved to pending deletion and back keeps its average cost and tax code
+
+ALTER TABLE pending_deletion_products
+    MODIFY COLUMN price DECIMAL(19,4),
+    ADD COLUMN average_cost DECIMAL(19,4) NOT NULL DEFAULT 0,
+    ADD COLUMN tax_code VARCHAR(32) NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+-- Migration script recording the idempotency key of each checkout, so a retry
+-- of one that already went through is not sold and charged again
+
+ALTER TABLE receipts ADD COLUMN idempotency_key VARCHAR(64) NULL;
+
+CREATE UNIQUE INDEX uq_receipts_organization_idempotency_key ON receipts (organization_id, idempotency_key);
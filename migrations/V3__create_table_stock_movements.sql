@@ -0,0 +1,22 @@
+-- Migration script to create the stock movement ledger
+
+CREATE TABLE stock_movements (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    product_id INT NOT NULL,
+    delta INT NOT NULL,
+    quantity_after INT NOT NULL,
+    reason VARCHAR(20) NOT NULL,
+    user_id INT UNSIGNED,
+    reference_type VARCHAR(50),
+    reference_id INT UNSIGNED,
+    note VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_stock_movements_product_id ON stock_movements (product_id);
+
+-- Seed an opening balance so existing quantities can be rebuilt from the ledger
+INSERT INTO stock_movements (product_id, delta, quantity_after, reason, reference_type, reference_id, note)
+SELECT product_id, quantity, quantity, 'adjustment', 'product', product_id, 'Opening balance'
+FROM products
+WHERE quantity IS NOT NULL AND quantity <> 0;
@@ -0,0 +1,18 @@
+-- Migration script to group sale lines under a receipt header
+
+CREATE TABLE receipts (
+    receipt_id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id INT UNSIGNED,
+    total_quantity INT NOT NULL DEFAULT 0,
+    total_amount DOUBLE(10,2) NOT NULL DEFAULT 0,
+    date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE sales
+    ADD COLUMN receipt_id INT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN product_id INT,
+    ADD COLUMN line_total DOUBLE(10,2) NOT NULL DEFAULT 0;
+
+UPDATE sales SET line_total = price * quantity;
+
+CREATE INDEX idx_sales_receipt_id ON sales (receipt_id);
@@ -0,0 +1,53 @@
+-- Migration script to create suppliers, purchase orders and goods receipts
+
+CREATE TABLE suppliers (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    contact_name VARCHAR(255),
+    email VARCHAR(255),
+    phone VARCHAR(50),
+    address VARCHAR(255),
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE TABLE purchase_orders (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    supplier_id INT UNSIGNED NOT NULL,
+    reference VARCHAR(100),
+    status VARCHAR(20) NOT NULL DEFAULT 'draft',
+    notes VARCHAR(255),
+    created_by INT UNSIGNED,
+    sent_at TIMESTAMP NULL,
+    received_at TIMESTAMP NULL,
+    closed_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_purchase_orders_supplier_id ON purchase_orders (supplier_id);
+
+CREATE TABLE purchase_order_lines (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    purchase_order_id INT UNSIGNED NOT NULL,
+    product_id INT NOT NULL,
+    quantity_ordered INT NOT NULL,
+    quantity_received INT NOT NULL DEFAULT 0,
+    unit_cost DOUBLE(10,2) NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_purchase_order_lines_order_id ON purchase_order_lines (purchase_order_id);
+
+CREATE TABLE goods_receipts (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    purchase_order_id INT UNSIGNED NOT NULL,
+    purchase_order_line_id INT UNSIGNED NOT NULL,
+    product_id INT NOT NULL,
+    quantity INT NOT NULL,
+    unit_cost DOUBLE(10,2) NOT NULL DEFAULT 0,
+    received_by INT UNSIGNED,
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_goods_receipts_order_id ON goods_receipts (purchase_order_id);
@@ -0,0 +1,17 @@
+-- Migration script to create reorder alerts raised from products.reorder_level
+
+CREATE TABLE reorder_alerts (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    product_id INT NOT NULL,
+    product_name VARCHAR(100),
+    quantity INT NOT NULL,
+    reorder_level INT NOT NULL,
+    daily_velocity DOUBLE(10,2) NOT NULL DEFAULT 0,
+    suggested_quantity INT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_reorder_alerts_product_status ON reorder_alerts (product_id, status);
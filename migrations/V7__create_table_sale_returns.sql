@@ -0,0 +1,22 @@
+-- Migration script to keep voided and returned sales instead of deleting them
+
+ALTER TABLE sales
+    ADD COLUMN returned_quantity INT NOT NULL DEFAULT 0,
+    ADD COLUMN voided_at TIMESTAMP NULL,
+    ADD COLUMN void_reason VARCHAR(255);
+
+CREATE TABLE sale_returns (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    sale_id INT NOT NULL,
+    receipt_id INT UNSIGNED NOT NULL DEFAULT 0,
+    product_id INT,
+    quantity INT NOT NULL,
+    amount DOUBLE(10,2) NOT NULL DEFAULT 0,
+    reason VARCHAR(255) NOT NULL,
+    restocked BOOLEAN NOT NULL DEFAULT TRUE,
+    is_void BOOLEAN NOT NULL DEFAULT FALSE,
+    user_id INT UNSIGNED,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_sale_returns_sale_id ON sale_returns (sale_id);
@@ -0,0 +1,26 @@
+-- Migration script to scope inventory and sales records to an organization.
+-- Existing rows belong to organization 0, the tenant used by platform users.
+
+ALTER TABLE categories ADD COLUMN organization_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN organization_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE pending_deletion_products ADD COLUMN organization_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE sales ADD COLUMN organization_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE receipts ADD COLUMN organization_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE sale_returns ADD COLUMN organization_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE stock_movements ADD COLUMN organization_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE suppliers ADD COLUMN organization_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE purchase_orders ADD COLUMN organization_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE goods_receipts ADD COLUMN organization_id INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE reorder_alerts ADD COLUMN organization_id INT UNSIGNED NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_categories_organization_id ON categories (organization_id);
+CREATE INDEX idx_products_organization_id ON products (organization_id);
+CREATE INDEX idx_pending_deletion_products_organization_id ON pending_deletion_products (organization_id);
+CREATE INDEX idx_sales_organization_id ON sales (organization_id);
+CREATE INDEX idx_receipts_organization_id ON receipts (organization_id);
+CREATE INDEX idx_sale_returns_organization_id ON sale_returns (organization_id);
+CREATE INDEX idx_stock_movements_organization_id ON stock_movements (organization_id);
+CREATE INDEX idx_suppliers_organization_id ON suppliers (organization_id);
+CREATE INDEX idx_purchase_orders_organization_id ON purchase_orders (organization_id);
+CREATE INDEX idx_goods_receipts_organization_id ON goods_receipts (organization_id);
+CREATE INDEX idx_reorder_alerts_organization_id ON reorder_alerts (organization_id);
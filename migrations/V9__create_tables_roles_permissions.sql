@@ -0,0 +1,81 @@
+-- Migration script to move role permissions into the database.
+-- The eight built-in roles keep their IDs so existing users and tokens stay valid.
+
+CREATE TABLE roles (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT UNSIGNED NOT NULL DEFAULT 0,
+    name VARCHAR(100) NOT NULL,
+    description VARCHAR(255),
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_roles_organization_name (organization_id, name)
+);
+
+CREATE TABLE permissions (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description VARCHAR(255)
+);
+
+CREATE TABLE role_permissions (
+    role_id INT UNSIGNED NOT NULL,
+    permission_id INT UNSIGNED NOT NULL,
+    PRIMARY KEY (role_id, permission_id),
+    FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE,
+    FOREIGN KEY (permission_id) REFERENCES permissions (id) ON DELETE CASCADE
+);
+
+INSERT INTO roles (id, organization_id, name, description, is_default) VALUES
+    (1, 0, 'Super Admin', 'Platform owner', TRUE),
+    (2, 0, 'Admin', 'Platform administrator', TRUE),
+    (3, 0, 'Shop Attendant', 'Sells products', TRUE),
+    (4, 0, 'Auditor', 'Read-only access to stock and sales', TRUE),
+    (5, 0, 'Organization', 'Organization account', TRUE),
+    (6, 0, 'Organization Admin', 'Manages an organization, its users and roles', TRUE),
+    (7, 0, 'Organization Shop Attendant', 'Sells products for an organization', TRUE),
+    (8, 0, 'Organization Auditor', 'Read-only access to an organization''s stock and sales', TRUE);
+
+INSERT INTO permissions (name, description) VALUES
+    ('categories:read', 'View categories'),
+    ('categories:write', 'Create, edit and delete categories'),
+    ('products:read', 'View products and low stock alerts'),
+    ('products:write', 'Create, edit and delete products'),
+    ('stock:read', 'View the stock movement ledger'),
+    ('stock:write', 'Adjust stock and rebuild quantities'),
+    ('suppliers:read', 'View suppliers'),
+    ('suppliers:write', 'Create, edit and deactivate suppliers'),
+    ('purchase_orders:read', 'View purchase orders and goods received'),
+    ('purchase_orders:write', 'Create, send, receive and close purchase orders'),
+    ('sales:read', 'View sales and receipts'),
+    ('sales:write', 'Sell products'),
+    ('sales:refund', 'Take customer returns'),
+    ('sales:void', 'Void sales and receipts'),
+    ('reports:read', 'View sales reports'),
+    ('users:read', 'View the organization''s users'),
+    ('users:write', 'Add, edit and deactivate the organization''s users'),
+    ('roles:write', 'Define the organization''s custom roles');
+
+-- Admins get every inventory and sales permission
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (1, 2, 6)
+  AND p.name NOT IN ('users:read', 'users:write', 'roles:write');
+
+-- Organization admins also manage their users and roles
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT 6, p.id FROM permissions p
+WHERE p.name IN ('users:read', 'users:write', 'roles:write');
+
+-- Shop attendants sell and take returns
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (3, 7)
+  AND p.name IN ('categories:read', 'products:read', 'sales:read', 'sales:write', 'sales:refund');
+
+-- Auditors read everything but change nothing
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.id IN (4, 8)
+  AND p.name IN ('categories:read', 'products:read', 'stock:read', 'suppliers:read',
+                 'purchase_orders:read', 'sales:read', 'reports:read');
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Account token purposes
+const (
+	AccountTokenInvitation    = "invitation"
+	AccountTokenPasswordReset = "password_reset"
+)
+
+// AccountToken lets a user set their password from an emailed link, either
+// to accept an invitation or to reset a forgotten password. Only a hash of the
+// token is stored, and each token works once.
+type AccountToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"type:char(64);uniqueIndex;not null" json:"-"`
+	Purpose   string     `gorm:"type:varchar(20);not null" json:"purpose"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedBy uint       `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
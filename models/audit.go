@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit event actions
+const (
+	AuditRequest                 = "request"
+	AuditUserCreated             = "user.created"
+	AuditUserUpdated             = "user.updated"
+	AuditUserDeleted             = "user.deleted"
+	AuditUserActivated           = "user.activated"
+	AuditUserDeactivated         = "user.deactivated"
+	AuditUserUnlocked            = "user.unlocked"
+	AuditOrganizationCreated     = "organization.created"
+	AuditOrganizationActivated   = "organization.activated"
+	AuditOrganizationDeactivated = "organization.deactivated"
+	AuditOrganizationUpdated     = "organization.updated"
+	AuditProductCreated          = "product.created"
+	AuditProductUpdated          = "product.updated"
+	AuditProductPriceChanged     = "product.price_changed"
+	AuditProductDeleted          = "product.deleted"
+	AuditProductPendingDeletion  = "product.pending_deletion"
+	AuditProductRestored         = "product.restored"
+	AuditSaleVoided              = "sale.voided"
+	AuditRoleCreated             = "role.created"
+	AuditRoleUpdated             = "role.updated"
+	AuditRoleDeleted             = "role.deleted"
+	AuditTaxRateCreated          = "tax_rate.created"
+	AuditTaxRateUpdated          = "tax_rate.updated"
+	AuditTaxRateDeleted          = "tax_rate.deleted"
+	AuditPromotionCreated        = "promotion.created"
+	AuditPromotionUpdated        = "promotion.updated"
+	AuditPriceApproved           = "price.approved"
+	AuditStoreCreditIssued       = "store_credit.issued"
+	AuditTillOpened              = "till.opened"
+	AuditTillEntryAdded          = "till.entry_added"
+	AuditTillClosed              = "till.closed"
+	AuditCustomerCreated         = "customer.created"
+	AuditCustomerUpdated         = "customer.updated"
+	AuditInvoicePaid             = "invoice.paid"
+)
+
+// AuditEvent records who changed what. Services write one per change with
+// the fields that changed; middleware writes a request event for every
+// authenticated mutation, so nothing goes unrecorded.
+type AuditEvent struct {
+	ID             uint   `gorm:"primaryKey" json:"id"`
+	OrganizationID uint   `gorm:"index" json:"organization_id"`
+	ActorID        uint   `gorm:"index" json:"actor_id"`
+	ActorRoleID    uint   `json:"actor_role_id"`
+	Action         string `gorm:"type:varchar(64);index;not null" json:"action"`
+	EntityType     string `gorm:"type:varchar(64)" json:"entity_type,omitempty"`
+	EntityID       uint   `json:"entity_id,omitempty"`
+	// Changes maps each changed field to its before and after values
+	Changes   json.RawMessage `gorm:"type:json" json:"changes,omitempty"`
+	Method    string          `gorm:"type:varchar(10)" json:"method,omitempty"`
+	Path      string          `gorm:"type:varchar(255)" json:"path,omitempty"`
+	Status    int             `json:"status,omitempty"`
+	IP        string          `gorm:"type:varchar(45)" json:"ip"`
+	RequestID string          `gorm:"type:varchar(64);index" json:"request_id"`
+	CreatedAt time.Time       `gorm:"index" json:"created_at"`
+}
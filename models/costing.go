@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Costing methods an organization can value its stock with
+const (
+	CostingFIFO            = "fifo"
+	CostingWeightedAverage = "weighted_average"
+)
+
+// CostLayer is a batch of stock that came in at one unit cost. Outgoing stock
+// uses up the oldest layers first, so Remaining across a product's layers
+// always matches its quantity.
+type CostLayer struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	ProductID      int       `gorm:"index" json:"product_id"`
+	MovementID     uint      `json:"movement_id"`
+	Quantity       int       `json:"quantity"`
+	Remaining      int       `json:"remaining"`
+	UnitCost       Money     `json:"unit_cost"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -0,0 +1,70 @@
+package models
+
+import "time"
+
+// Invoice statuses
+const (
+	InvoiceOpen = "open"
+	InvoicePaid = "paid"
+)
+
+// Customer is someone an organization sells to by name, on credit or not
+type Customer struct {
+	ID             uint   `gorm:"primaryKey" json:"id"`
+	OrganizationID uint   `gorm:"index" json:"organization_id"`
+	Name           string `gorm:"type:varchar(255);not null" json:"name"`
+	Email          string `gorm:"type:varchar(255)" json:"email,omitempty"`
+	Phone          string `gorm:"type:varchar(50)" json:"phone,omitempty"`
+	Address        string `json:"address,omitempty"`
+	TaxNumber      string `gorm:"type:varchar(64)" json:"tax_number,omitempty"`
+	// CreditLimit caps what the customer may owe; zero means no limit
+	CreditLimit Money `json:"credit_limit"`
+	// PaymentTermsDays is how long after a credit sale its invoice falls due
+	PaymentTermsDays int       `json:"payment_terms_days"`
+	IsActive         bool      `json:"is_active"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Invoice is what a customer owes for the part of a checkout put on their
+// account. Returns credit it; payments pay it off.
+type Invoice struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	OrganizationID uint       `gorm:"index" json:"organization_id"`
+	CustomerID     uint       `gorm:"index" json:"customer_id"`
+	ReceiptID      uint       `gorm:"index" json:"receipt_id"`
+	Currency       string     `gorm:"type:char(3)" json:"currency"`
+	Amount         Money      `json:"amount"`
+	CreditedAmount Money      `json:"credited_amount"`
+	PaidAmount     Money      `json:"paid_amount"`
+	Status         string     `gorm:"type:varchar(10);not null" json:"status"`
+	IssuedAt       time.Time  `json:"issued_at"`
+	DueDate        time.Time  `json:"due_date"`
+	PaidAt         *time.Time `json:"paid_at,omitempty"`
+}
+
+// Balance is what is still owed on the invoice. Returns only credit what is
+// owed; any more is refunded through the invoice's payments.
+func (i Invoice) Balance() Money {
+	return i.Amount - i.CreditedAmount - i.PaidAmount
+}
+
+// InvoicePayment is money a customer pays towards an invoice
+type InvoicePayment struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	InvoiceID      uint      `gorm:"index" json:"invoice_id"`
+	CustomerID     uint      `gorm:"index" json:"customer_id"`
+	Tender         string    `gorm:"type:varchar(20);not null" json:"tender"`
+	Amount         Money     `json:"amount"`
+	Reference      string    `json:"reference,omitempty"`
+	Provider       string    `gorm:"type:varchar(32)" json:"provider,omitempty"`
+	ProviderRef    string    `json:"provider_ref,omitempty"`
+	TillSessionID  uint      `json:"till_session_id,omitempty"`
+	UserID         uint      `json:"user_id"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	// RefundedAmount is what returns after the invoice was paid gave back
+	// through this payment
+	RefundedAmount Money `json:"refunded_amount"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// MFA challenge purposes
+const (
+	MFAChallengeVerify = "verify"
+	MFAChallengeEnroll = "enroll"
+)
+
+// MFAChallenge is handed out after a correct password when a second factor is
+// still needed. Only a hash of the token is stored.
+type MFAChallenge struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"type:char(64);uniqueIndex;not null" json:"-"`
+	Purpose   string     `gorm:"type:varchar(20);not null" json:"purpose"`
+	Attempts  int        `json:"attempts"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RecoveryCode is a single-use code that stands in for a TOTP code
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"type:varchar(255);not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MFARequiredRole makes two-factor authentication mandatory for a role within
+// an organization. Organization 0 is the platform itself.
+type MFARequiredRole struct {
+	OrganizationID uint `gorm:"primaryKey;autoIncrement:false" json:"organization_id"`
+	RoleID         uint `gorm:"primaryKey;autoIncrement:false" json:"role_id"`
+}
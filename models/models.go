@@ -4,40 +4,125 @@ import "time"
 
 // Category struct with product_description added
 type Category struct {
-	CategoryID         int    `json:"category_id"`
+	CategoryID         int    `gorm:"primaryKey" json:"category_id"`
+	OrganizationID     uint   `gorm:"index" json:"organization_id"`
 	CategoryName       string `json:"category_name"`
 	ProductName        string `json:"product_name"`
 	ProductDescription string `json:"product_description"`
+	// TaxCode is the tax rate of the category's products that have none of their own
+	TaxCode string `gorm:"type:varchar(32)" json:"tax_code"`
 }
 
 type Product struct {
-	ProductID          int     `json:"product_id"`
-	CategoryName       string  `json:"category_name"`
-	ProductName        string  `json:"product_name"`
-	ProductCode        string  `json:"product_code"`
-	ProductDescription string  `json:"product_description"`
-	Date               string  `json:"date"` // Assuming date is a string in your database
-	Quantity           int     `json:"quantity"`
-	ReorderLevel       int     `json:"reorder_level"`
-	Price              float64 `json:"price"`
+	ProductID          int    `gorm:"primaryKey" json:"product_id"`
+	OrganizationID     uint   `gorm:"index" json:"organization_id"`
+	CategoryName       string `json:"category_name"`
+	ProductName        string `json:"product_name"`
+	ProductCode        string `json:"product_code"`
+	ProductDescription string `json:"product_description"`
+	Date               string `json:"date"` // Assuming date is a string in your database
+	Quantity           int    `json:"quantity"`
+	ReorderLevel       int    `json:"reorder_level"`
+	Price              Money  `json:"price"`
+	// AverageCost is the weighted average unit cost of the stock on hand
+	AverageCost Money `json:"average_cost"`
+	// TaxCode overrides the tax rate of the product's category
+	TaxCode string `gorm:"type:varchar(32)" json:"tax_code"`
 }
 
+// Sale is a single sold line; lines sold together share a ReceiptID.
+// LineTotal is the gross the customer paid, split into NetAmount and TaxAmount.
 type Sale struct {
-	SaleID       int       `json:"sale_id"`
-	Name         string    `json:"name"`
-	Price        float64   `json:"price"`
-	Quantity     int       `json:"quantity"`
-	UserID       string    `json:"user_id"`
-	Date         time.Time `json:"date"`
-	CategoryName string    `json:"category_name"`
+	SaleID         int       `gorm:"primaryKey" json:"sale_id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	ReceiptID      uint      `gorm:"index" json:"receipt_id"`
+	ProductID      int       `json:"product_id"`
+	Name           string    `json:"name"`
+	Price          Money     `json:"price"`
+	Quantity       int       `json:"quantity"`
+	LineTotal      Money     `json:"line_total"`
+	UserID         string    `json:"user_id"`
+	Date           time.Time `json:"date"`
+	CategoryName   string    `json:"category_name"`
+	Currency       string    `gorm:"type:char(3)" json:"currency"`
+
+	// CostOfGoods is what the units sold cost, posted when the sale is made
+	CostOfGoods      Money      `json:"cost_of_goods"`
+	ReturnedQuantity int        `json:"returned_quantity"`
+	VoidedAt         *time.Time `json:"voided_at,omitempty"`
+	VoidReason       string     `json:"void_reason,omitempty"`
+
+	// The tax rate the line was sold at and whether Price included it
+	TaxCode          string  `gorm:"type:varchar(32)" json:"tax_code"`
+	TaxClass         string  `gorm:"type:varchar(20)" json:"tax_class"`
+	TaxRate          Percent `json:"tax_rate"`
+	PriceIncludesTax bool    `json:"price_includes_tax"`
+	NetAmount        Money   `json:"net_amount"`
+	TaxAmount        Money   `json:"tax_amount"`
+
+	// ListPrice is the product's price when sold. Price differs from it when
+	// the cashier overrode it, and DiscountAmount comes off the line before tax.
+	ListPrice          Money `json:"list_price"`
+	PromotionID        uint  `json:"promotion_id,omitempty"`
+	DiscountAmount     Money `json:"discount_amount"`
+	OverrideApprovedBy uint  `json:"override_approved_by,omitempty"`
+
+	// TillSessionID is the cashier's till session the sale was made in, if any
+	TillSessionID uint `json:"till_session_id,omitempty"`
+
+	// CustomerID is who the sale was made to; zero for anonymous sales
+	CustomerID uint `json:"customer_id,omitempty"`
 }
 
-type SaleByCategory struct {
-	SaleID       int     `json:"sale_id"`
-	Name         string  `json:"name"`
-	Price        float64 `json:"price"`
-	Quantity     int     `json:"quantity"`
-	UserID       string  `json:"user_id"`
-	Date         string  `json:"date"`
-	CategoryName string  `json:"category_name"`
+// SaleReturn records stock coming back against a sale line, either from a
+// customer return or from voiding the sale. The original sale is kept for audit.
+type SaleReturn struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	SaleID         int       `gorm:"index" json:"sale_id"`
+	ReceiptID      uint      `json:"receipt_id"`
+	ProductID      int       `json:"product_id"`
+	Quantity       int       `json:"quantity"`
+	Amount         Money     `json:"amount"`
+	Reason         string    `json:"reason"`
+	Restocked      bool      `json:"restocked"`
+	IsVoid         bool      `json:"is_void"`
+	UserID         uint      `json:"user_id"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+
+	// TaxAmount is the part of Amount that was tax
+	TaxAmount Money `json:"tax_amount"`
+
+	// Refunds give Amount back through the receipt's payments
+	Refunds []PaymentRefund `gorm:"foreignKey:SaleReturnID" json:"refunds,omitempty"`
+}
+
+// Receipt is the header for one checkout covering one or more sale lines
+type Receipt struct {
+	ReceiptID      uint      `gorm:"primaryKey" json:"receipt_id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	UserID         uint      `json:"user_id"`
+	TotalQuantity  int       `json:"total_quantity"`
+	TotalAmount    Money     `json:"total_amount"`
+	TotalTax       Money     `json:"total_tax"`
+	TotalDiscount  Money     `json:"total_discount"`
+	Currency       string    `gorm:"type:char(3)" json:"currency"`
+	Date           time.Time `json:"date"`
+	Lines          []Sale    `gorm:"foreignKey:ReceiptID" json:"lines,omitempty"`
+
+	// ChangeDue is the cash handed back to the customer
+	ChangeDue Money     `json:"change_due"`
+	Payments  []Payment `gorm:"foreignKey:ReceiptID" json:"payments,omitempty"`
+
+	// TillSessionID is the cashier's till session the checkout was made in, if any
+	TillSessionID uint `json:"till_session_id,omitempty"`
+
+	// CustomerID is who the checkout was for; Invoice is set when part of it
+	// was put on their account
+	CustomerID uint     `json:"customer_id,omitempty"`
+	Invoice    *Invoice `gorm:"foreignKey:ReceiptID" json:"invoice,omitempty"`
+
+	// IdempotencyKey is the client's key for the checkout, or a random one,
+	// so a retried checkout is recognised instead of charged again
+	IdempotencyKey string `gorm:"type:varchar(64)" json:"idempotency_key,omitempty"`
 }
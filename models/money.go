@@ -0,0 +1,194 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact decimal amount in ten-thousandths of a currency unit, so
+// unit costs keep four decimal places. Amounts charged to customers are
+// rounded to their currency's minor unit with Round. It is stored in
+// DECIMAL(19,4) columns and written to JSON as a plain decimal number.
+type Money int64
+
+// MoneyScale is the number of Money in one currency unit
+const MoneyScale = 10000
+
+const moneyDecimals = 4
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// ParseMoney reads a decimal amount such as "12.5", "-0.0375" or "1.2e3". More than
+// four decimal places are rounded half away from zero.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	// Only plain decimals, with an exponent as JSON allows
+	mantissa, exponent, hasExponent := strings.Cut(strings.ToLower(s), "e")
+	digits := strings.TrimLeft(mantissa, "+-")
+	if len(mantissa)-len(digits) > 1 || digits == "" || digits == "." ||
+		strings.Trim(digits, "0123456789.") != "" || strings.Count(digits, ".") > 1 {
+		return 0, ErrInvalidMoney
+	}
+	if hasExponent {
+		if _, err := strconv.Atoi(exponent); err != nil || len(exponent) > 3 {
+			return 0, ErrInvalidMoney
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, ErrInvalidMoney
+	}
+	r.Mul(r, big.NewRat(MoneyScale, 1))
+	q, ok := roundRat(r)
+	if !ok {
+		return 0, ErrInvalidMoney
+	}
+	return Money(q), nil
+}
+
+// roundRat rounds half away from zero to an int64
+func roundRat(r *big.Rat) (int64, bool) {
+	num, den := r.Num(), r.Denom()
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	}
+	return q.Int64(), q.IsInt64()
+}
+
+// Mul is the amount times a quantity
+func (m Money) Mul(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// Div splits the amount into n parts, rounded half away from zero
+func (m Money) Div(n int) Money {
+	if n == 0 {
+		return 0
+	}
+	return Money(divRound(int64(m), int64(n)))
+}
+
+// MulFrac is the amount times num/den, rounded half away from zero
+func (m Money) MulFrac(num, den int64) Money {
+	if den == 0 {
+		return 0
+	}
+	r := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(num)), big.NewInt(den))
+	q, _ := roundRat(r)
+	return Money(q)
+}
+
+// Round rounds to the minor unit of a currency, e.g. cents for USD
+func (m Money) Round(currency string) Money {
+	step := int64(1)
+	for i := CurrencyDecimals(currency); i < moneyDecimals; i++ {
+		step *= 10
+	}
+	return Money(divRound(int64(m), step) * step)
+}
+
+// Float64 is the amount as a float, for ratios and percentages only
+func (m Money) Float64() float64 {
+	return float64(m) / MoneyScale
+}
+
+// String formats the amount with as few decimals as it needs, at least two
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	units, frac := v/MoneyScale, v%MoneyScale
+	decimals := fmt.Sprintf("%04d", frac)
+	decimals = strings.TrimRight(decimals, "0")
+	for len(decimals) < 2 {
+		decimals += "0"
+	}
+	return sign + strconv.FormatInt(units, 10) + "." + decimals
+}
+
+// MarshalJSON writes the exact decimal as a JSON number
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number or a string holding a decimal, without
+// going through a float
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Value stores the amount as an exact decimal string
+func (m Money) Value() (driver.Value, error) {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%04d", sign, v/MoneyScale, v%MoneyScale), nil
+}
+
+// Scan reads a DECIMAL column or an aggregate over one
+func (m *Money) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case []byte:
+		parsed, err := ParseMoney(string(v))
+		*m = parsed
+		return err
+	case string:
+		parsed, err := ParseMoney(v)
+		*m = parsed
+		return err
+	case int64:
+		*m = Money(v * MoneyScale)
+		return nil
+	case float64:
+		parsed, err := ParseMoney(strconv.FormatFloat(v, 'f', -1, 64))
+		*m = parsed
+		return err
+	default:
+		return fmt.Errorf("cannot scan %T into Money", value)
+	}
+}
+
+func divRound(a, b int64) int64 {
+	if b < 0 {
+		a, b = -a, -b
+	}
+	q, r := a/b, a%b
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= b {
+		if a < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
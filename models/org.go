@@ -12,10 +12,19 @@ type Organization struct {
 	City        string     `json:"city"`
 	State       string     `json:"state"`
 	Country     string     `json:"country"`
-	Password    string     `json: "password"`
+	Password    string     `json:"password"`
 	RoleID      uint       `json:"role_id"`
 	IsActive    bool       `json:"is_active" gorm:"default:true"`
 	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"createdAt"`
 	UpdatedAt   time.Time  `gorm:"autoUpdateTime" json:"updatedAt"`
 	DeletedAt   *time.Time `gorm:"index" json:"deletedAt,omitempty"`
+
+	// CostingMethod values stock and costs sales: CostingFIFO or CostingWeightedAverage
+	CostingMethod string `gorm:"type:varchar(20);default:fifo" json:"costing_method"`
+	// Currency is the ISO 4217 code every amount of the organization is in
+	Currency string `gorm:"type:char(3)" json:"currency"`
+	// PricesIncludeTax is whether product prices are gross or net of tax
+	PricesIncludeTax bool `json:"prices_include_tax"`
+	// DefaultTaxCode is the tax rate of products and categories without one
+	DefaultTaxCode string `gorm:"type:varchar(32)" json:"default_tax_code"`
 }
@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// Tenders a customer can pay with
+const (
+	TenderCash        = "cash"
+	TenderCard        = "card"
+	TenderMobileMoney = "mobile_money"
+	TenderStoreCredit = "store_credit"
+	// TenderAccount puts the amount on the customer's account, to pay later
+	TenderAccount = "account"
+)
+
+// Payment is one tender towards a receipt. Amount is what it paid towards
+// the total; a cash payment can hand over more and get Change back.
+type Payment struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	ReceiptID      uint      `gorm:"index" json:"receipt_id"`
+	Tender         string    `gorm:"type:varchar(20);not null" json:"tender"`
+	Amount         Money     `json:"amount"`
+	Tendered       Money     `json:"tendered"`
+	Change         Money     `json:"change"`
+	Currency       string    `gorm:"type:char(3)" json:"currency"`
+	Reference      string    `json:"reference,omitempty"`
+	Provider       string    `gorm:"type:varchar(32)" json:"provider,omitempty"`
+	ProviderRef    string    `json:"provider_ref,omitempty"`
+	RefundedAmount Money     `json:"refunded_amount"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	// TillSessionID is the till the payment went into, if any
+	TillSessionID uint `json:"till_session_id,omitempty"`
+}
+
+// Refund statuses. Card and mobile money refunds are pending until the
+// provider takes them, after the return commits; the rest settle at once.
+const (
+	RefundPending = "pending"
+	RefundSettled = "settled"
+	RefundFailed  = "failed"
+)
+
+// PaymentRefund is money given back through the payment it was taken with
+type PaymentRefund struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	PaymentID      uint      `gorm:"index" json:"payment_id"`
+	SaleReturnID   uint      `gorm:"index" json:"sale_return_id"`
+	Tender         string    `gorm:"type:varchar(20);not null" json:"tender"`
+	Amount         Money     `json:"amount"`
+	ProviderRef    string    `json:"provider_ref,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	// TillSessionID is the till a cash refund came out of, if any
+	TillSessionID uint `json:"till_session_id,omitempty"`
+
+	// Status is whether the money has gone back yet. ChargeRef and Currency
+	// are what a pending refund is settled against at the provider.
+	Status    string `gorm:"type:varchar(20);not null" json:"status"`
+	ChargeRef string `json:"-"`
+	Currency  string `gorm:"type:char(3)" json:"currency"`
+
+	// InvoicePaymentID is set instead of PaymentID when a return on a paid
+	// invoice gives money back through what the customer paid it with
+	InvoicePaymentID uint `gorm:"index" json:"invoice_payment_id,omitempty"`
+}
+
+// StoreCredit is a balance a customer can spend, found by its code. Refunds
+// of store credit payments go back onto it.
+type StoreCredit struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	Code           string    `gorm:"type:varchar(32);not null" json:"code"`
+	Balance        Money     `json:"balance"`
+	Currency       string    `gorm:"type:char(3)" json:"currency"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Kinds of promotion
+const (
+	// PromotionPercent takes Value percent off each unit
+	PromotionPercent = "percent"
+	// PromotionFixed takes Value off each unit
+	PromotionFixed = "fixed"
+	// PromotionBuyXGetY gives GetQuantity units free for every BuyQuantity bought
+	PromotionBuyXGetY = "buy_x_get_y"
+)
+
+// Promotion is a discount applied automatically at checkout, or only with its
+// coupon code when it has one. It covers one product, every product of a
+// category, or everything when neither is set.
+type Promotion struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	OrganizationID uint       `gorm:"index" json:"organization_id"`
+	Name           string     `gorm:"not null" json:"name"`
+	Kind           string     `gorm:"type:varchar(20);not null" json:"kind"`
+	Value          Money      `json:"value"`
+	BuyQuantity    int        `json:"buy_quantity"`
+	GetQuantity    int        `json:"get_quantity"`
+	ProductID      int        `json:"product_id"`
+	CategoryName   string     `json:"category_name"`
+	CouponCode     string     `gorm:"type:varchar(64)" json:"coupon_code"`
+	StartsAt       *time.Time `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at"`
+	IsActive       bool       `json:"is_active"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// PriceApproval lets a cashier sell a product below the override threshold.
+// A manager issues it for one product and the lowest price they allow; only a
+// hash of the token is stored, and it works once.
+type PriceApproval struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	OrganizationID uint       `gorm:"index" json:"organization_id"`
+	ProductID      int        `json:"product_id"`
+	MinPrice       Money      `json:"min_price"`
+	TokenHash      string     `gorm:"type:char(64);uniqueIndex;not null" json:"-"`
+	ApprovedBy     uint       `json:"approved_by"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	UsedAt         *time.Time `json:"used_at,omitempty"`
+	SaleID         int        `json:"sale_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import "time"
+
+// Purchase order states. An order moves draft -> sent -> partially_received -> received -> closed.
+// A draft or sent order that will not be fulfilled is cancelled instead.
+const (
+	PurchaseOrderStatusDraft             = "draft"
+	PurchaseOrderStatusSent              = "sent"
+	PurchaseOrderStatusPartiallyReceived = "partially_received"
+	PurchaseOrderStatusReceived          = "received"
+	PurchaseOrderStatusClosed            = "closed"
+	PurchaseOrderStatusCancelled         = "cancelled"
+)
+
+type Supplier struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	Name           string    `gorm:"not null" json:"name"`
+	ContactName    string    `json:"contact_name"`
+	Email          string    `json:"email"`
+	Phone          string    `json:"phone"`
+	Address        string    `json:"address"`
+	IsActive       bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type PurchaseOrder struct {
+	ID             uint                `gorm:"primaryKey" json:"id"`
+	OrganizationID uint                `gorm:"index" json:"organization_id"`
+	SupplierID     uint                `gorm:"index" json:"supplier_id"`
+	Reference      string              `json:"reference"`
+	Status         string              `gorm:"type:varchar(20);default:draft" json:"status"`
+	Notes          string              `json:"notes"`
+	CreatedBy      uint                `json:"created_by"`
+	SentAt         *time.Time          `json:"sent_at,omitempty"`
+	ReceivedAt     *time.Time          `json:"received_at,omitempty"`
+	ClosedAt       *time.Time          `json:"closed_at,omitempty"`
+	CancelledAt    *time.Time          `json:"cancelled_at,omitempty"`
+	CreatedAt      time.Time           `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time           `gorm:"autoUpdateTime" json:"updated_at"`
+	Lines          []PurchaseOrderLine `gorm:"foreignKey:PurchaseOrderID" json:"lines,omitempty"`
+}
+
+// PurchaseOrderLine keeps the cost agreed on the order; what each delivery
+// actually cost is recorded on its GoodsReceipt.
+type PurchaseOrderLine struct {
+	ID               uint  `gorm:"primaryKey" json:"id"`
+	PurchaseOrderID  uint  `gorm:"index" json:"purchase_order_id"`
+	ProductID        int   `json:"product_id"`
+	QuantityOrdered  int   `json:"quantity_ordered"`
+	QuantityReceived int   `json:"quantity_received"`
+	UnitCost         Money `json:"unit_cost"`
+}
+
+// GoodsReceipt records stock that actually arrived against a purchase order line
+type GoodsReceipt struct {
+	ID                  uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID      uint      `gorm:"index" json:"organization_id"`
+	PurchaseOrderID     uint      `gorm:"index" json:"purchase_order_id"`
+	PurchaseOrderLineID uint      `json:"purchase_order_line_id"`
+	ProductID           int       `json:"product_id"`
+	Quantity            int       `json:"quantity"`
+	UnitCost            Money     `json:"unit_cost"`
+	ReceivedBy          uint      `json:"received_by"`
+	ReceivedAt          time.Time `gorm:"autoCreateTime" json:"received_at"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// RateLimit counts attempts for a key until ResetAt. It backs the
+// database rate limiter shared by every instance.
+type RateLimit struct {
+	Bucket  string    `gorm:"primaryKey;type:varchar(255)" json:"bucket"`
+	Hits    int       `json:"hits"`
+	ResetAt time.Time `gorm:"index" json:"reset_at"`
+}
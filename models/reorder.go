@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+const (
+	ReorderAlertStatusOpen     = "open"
+	ReorderAlertStatusResolved = "resolved"
+)
+
+// ReorderAlert flags a product whose quantity has dropped to or below its ReorderLevel
+type ReorderAlert struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	OrganizationID    uint       `gorm:"index" json:"organization_id"`
+	ProductID         int        `gorm:"index" json:"product_id"`
+	ProductName       string     `json:"product_name"`
+	Quantity          int        `json:"quantity"`
+	ReorderLevel      int        `json:"reorder_level"`
+	DailyVelocity     float64    `json:"daily_velocity"`
+	SuggestedQuantity int        `json:"suggested_quantity"`
+	Status            string     `gorm:"type:varchar(20);default:open" json:"status"`
+	CreatedAt         time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+	ResolvedAt        *time.Time `json:"resolved_at,omitempty"`
+}
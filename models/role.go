@@ -1,5 +1,8 @@
 package models
 
+import "time"
+
+// IDs of the default roles seeded by the roles migration
 const (
 	SuperAdminRoleID                = 1
 	AdminRoleID                     = 2
@@ -10,3 +13,57 @@ const (
 	OrganizationShopAttendantRoleID = 7
 	OrganizationAuditorRoleID       = 8
 )
+
+// Permissions checked by RequirePermission. Each is a row in the permissions table.
+const (
+	PermissionCategoriesRead      = "categories:read"
+	PermissionCategoriesWrite     = "categories:write"
+	PermissionProductsRead        = "products:read"
+	PermissionProductsWrite       = "products:write"
+	PermissionStockRead           = "stock:read"
+	PermissionStockWrite          = "stock:write"
+	PermissionSuppliersRead       = "suppliers:read"
+	PermissionSuppliersWrite      = "suppliers:write"
+	PermissionPurchaseOrdersRead  = "purchase_orders:read"
+	PermissionPurchaseOrdersWrite = "purchase_orders:write"
+	PermissionSalesRead           = "sales:read"
+	PermissionSalesWrite          = "sales:write"
+	PermissionSalesRefund         = "sales:refund"
+	PermissionSalesVoid           = "sales:void"
+	PermissionReportsRead         = "reports:read"
+	PermissionUsersRead           = "users:read"
+	PermissionUsersWrite          = "users:write"
+	PermissionRolesWrite          = "roles:write"
+	PermissionAuditRead           = "audit:read"
+	PermissionTaxRead             = "tax:read"
+	PermissionTaxWrite            = "tax:write"
+	PermissionPromotionsRead      = "promotions:read"
+	PermissionPromotionsWrite     = "promotions:write"
+	PermissionPricesApprove       = "prices:approve"
+	PermissionTillOperate         = "till:operate"
+	PermissionTillManage          = "till:manage"
+	PermissionCustomersRead       = "customers:read"
+	PermissionCustomersWrite      = "customers:write"
+	PermissionInvoicesPay         = "invoices:pay"
+	PermissionStoreCreditsIssue   = "store_credits:issue"
+)
+
+// Role groups permissions. Default roles have no organization and cannot be
+// changed through the API; organizations define their own custom roles.
+type Role struct {
+	ID             uint         `gorm:"primaryKey" json:"id"`
+	OrganizationID uint         `json:"organization_id"`
+	Name           string       `gorm:"not null" json:"name"`
+	Description    string       `json:"description"`
+	IsDefault      bool         `json:"is_default"`
+	Permissions    []Permission `gorm:"many2many:role_permissions" json:"permissions,omitempty"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// Permission is a single action a role may be granted, such as products:write
+type Permission struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	Name        string `gorm:"unique;not null" json:"name"`
+	Description string `json:"description"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Reasons recorded against a stock movement
+const (
+	MovementReasonSale       = "sale"
+	MovementReasonReceipt    = "receipt"
+	MovementReasonAdjustment = "adjustment"
+	MovementReasonReturn     = "return"
+	MovementReasonWriteOff   = "write_off"
+)
+
+// StockMovement is an immutable ledger entry for a single change to a product's quantity.
+// products.quantity is a cached projection of the sum of Delta for that product.
+type StockMovement struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	ProductID      int       `gorm:"index" json:"product_id"`
+	Delta          int       `json:"delta"`
+	QuantityAfter  int       `json:"quantity_after"`
+	Reason         string    `gorm:"type:varchar(20)" json:"reason"`
+	UserID         uint      `json:"user_id"`
+	ReferenceType  string    `gorm:"type:varchar(50)" json:"reference_type"`
+	ReferenceID    uint      `json:"reference_id"`
+	Note           string    `json:"note"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+
+	// UnitCost is what each unit came in or went out at; ValueDelta is the
+	// change in stock value, signed like Delta
+	UnitCost   Money `json:"unit_cost"`
+	ValueDelta Money `json:"value_delta"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Tax classes of a rate. Zero-rated sales are taxable at 0% and count towards
+// taxable turnover; exempt sales are outside the tax altogether.
+const (
+	TaxClassTaxable   = "taxable"
+	TaxClassZeroRated = "zero_rated"
+	TaxClassExempt    = "exempt"
+)
+
+// Percent is a percentage such as 20 for 20%, exact to four decimal places
+// like Money
+type Percent = Money
+
+// TaxRate is a rate an organization charges, looked up by its code. A product
+// uses its own tax code, else its category's, else the organization's default.
+type TaxRate struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	Code           string    `gorm:"type:varchar(32);not null" json:"code"`
+	Name           string    `json:"name"`
+	Class          string    `gorm:"type:varchar(20);not null" json:"class"`
+	Rate           Percent   `json:"rate"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
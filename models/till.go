@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Till session statuses
+const (
+	TillSessionOpen   = "open"
+	TillSessionClosed = "closed"
+)
+
+// Kinds of till entry
+const (
+	TillEntryCashIn  = "cash_in"
+	TillEntryCashOut = "cash_out"
+)
+
+// TillSession is a cashier's shift on a till, from opening with a float to
+// closing with the cash counted. Sales and cash refunds the cashier makes
+// while it is open belong to it.
+type TillSession struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	OrganizationID uint       `gorm:"index" json:"organization_id"`
+	UserID         uint       `gorm:"index" json:"user_id"`
+	Status         string     `gorm:"type:varchar(10);not null" json:"status"`
+	Currency       string     `gorm:"type:char(3)" json:"currency"`
+	OpeningFloat   Money      `json:"opening_float"`
+	OpenedAt       time.Time  `json:"opened_at"`
+	ClosedAt       *time.Time `json:"closed_at,omitempty"`
+	ClosedBy       uint       `json:"closed_by,omitempty"`
+	// ExpectedCash, CountedCash and OverShort are set on closing. OverShort
+	// is counted less expected: positive when the drawer is over.
+	ExpectedCash Money  `json:"expected_cash"`
+	CountedCash  Money  `json:"counted_cash"`
+	OverShort    Money  `json:"over_short"`
+	Note         string `json:"note,omitempty"`
+}
+
+// TillEntry is cash put into or taken out of a till other than by a sale,
+// such as a top-up of change or a drop to the safe
+type TillEntry struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"index" json:"organization_id"`
+	TillSessionID  uint      `gorm:"index" json:"till_session_id"`
+	Kind           string    `gorm:"type:varchar(10);not null" json:"kind"`
+	Amount         Money     `json:"amount"`
+	Reason         string    `json:"reason"`
+	UserID         uint      `json:"user_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// RefreshToken is a long-lived token exchanged for a new access token. Only a
+// hash of the token is stored. Each refresh rotates it: the old token is
+// revoked and points at its replacement.
+type RefreshToken struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	UserID       uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash    string     `gorm:"type:char(64);uniqueIndex;not null" json:"-"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	ReplacedByID *uint      `json:"replaced_by_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// RevokedToken blocks an access token by its jti until the token would have expired anyway
+type RevokedToken struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TokenID   string    `gorm:"type:varchar(64);uniqueIndex;not null" json:"token_id"`
+	UserID    uint      `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
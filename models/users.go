@@ -6,18 +6,28 @@ import (
 )
 
 type User struct {
-	ID             uint           `json:"id" gorm:"primaryKey"`
-	Username       string         `json:"username" gorm:"type:varchar(255);unique_index"`
-	Email          string         `json:"email" gorm:"type:varchar(255);unique_index"`
-	Password       string         `json:"password" gorm:"type:varchar(255)"`
-	FirstName      string         `json:"first_name" gorm:"type:varchar(255)"`
-	LastName       string         `json:"last_name" gorm:"type:varchar(255)"`
-	RoleID         uint           `json:"role_id"`
-	OrganizationID uint           `json:"organization_id,omitempty"` // Nullable
-	IsActive       bool           `json:"is_active" gorm:"default:true"`
-	CreatedAt      time.Time      `json:"created_at"`
-	UpdatedAt      time.Time      `json:"updated_at"`
-	DeletedAt      gorm.DeletedAt `json:"deleted_at"`
-	CreatedBy      uint           `json:"created_by"`
-	UpdatedBy      uint           `json: updated_by`
+	ID             uint   `json:"id" gorm:"primaryKey"`
+	Username       string `json:"username" gorm:"type:varchar(255);unique_index"`
+	Email          string `json:"email" gorm:"type:varchar(255);unique_index"`
+	Password       string `json:"password" gorm:"type:varchar(255)"`
+	FirstName      string `json:"first_name" gorm:"type:varchar(255)"`
+	LastName       string `json:"last_name" gorm:"type:varchar(255)"`
+	RoleID         uint   `json:"role_id"`
+	OrganizationID uint   `json:"organization_id,omitempty"` // Nullable
+	IsActive       bool   `json:"is_active" gorm:"default:true"`
+	// TokensValidAfter invalidates every token issued up to and including it
+	TokensValidAfter *time.Time `json:"-"`
+	// TOTPSecret is set when enrollment starts; TOTPEnabled once a code confirms it
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPLastStep int64  `json:"-"`
+	// FailedLoginAttempts counts wrong passwords since the last successful login;
+	// LockedUntil is set once too many are made in a row
+	FailedLoginAttempts int            `json:"failed_login_attempts"`
+	LockedUntil         *time.Time     `json:"locked_until,omitempty"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `json:"deleted_at"`
+	CreatedBy           uint           `json:"created_by"`
+	UpdatedBy           uint           `json:"updated_by"`
 }
@@ -0,0 +1,122 @@
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+
+	"stock/models"
+)
+
+// FakeProvider approves payments without moving any money, for development
+// and tests. Charges whose reference starts with "decline" are declined.
+// Retried charges and refunds with the same idempotency key return the
+// original result.
+//
+// Charges are only kept in memory. A refund of a fake charge taken before a
+// restart is approved as asked, since there is nothing left to check it
+// against. Refunds of references that are not fake charges are declined.
+type FakeProvider struct {
+	mu       sync.Mutex
+	order    []string
+	charges  map[string]*FakeCharge
+	attempts map[string]string
+}
+
+// FakeCharge is a charge the fake provider took
+type FakeCharge struct {
+	Ref      string
+	Charge   Charge
+	Refunded models.Money
+}
+
+// NewFakeProvider returns a fake provider with no charges
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{charges: map[string]*FakeCharge{}, attempts: map[string]string{}}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) Charge(ctx context.Context, charge Charge) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ref, ok := p.attempts[charge.IdempotencyKey]; ok {
+		return ref, nil
+	}
+	if charge.Tender != models.TenderCard && charge.Tender != models.TenderMobileMoney {
+		return "", ErrUnsupportedTender
+	}
+	if charge.Amount <= 0 || strings.HasPrefix(charge.Reference, "decline") {
+		return "", ErrDeclined
+	}
+
+	ref, err := fakeRef("fake_ch_")
+	if err != nil {
+		return "", err
+	}
+	p.charges[ref] = &FakeCharge{Ref: ref, Charge: charge}
+	p.order = append(p.order, ref)
+	if charge.IdempotencyKey != "" {
+		p.attempts[charge.IdempotencyKey] = ref
+	}
+	log.Printf("Fake payment %s: %s %s by %s", ref, charge.Amount, charge.Currency, charge.Tender)
+	return ref, nil
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, refund Refund) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ref, ok := p.attempts[refund.IdempotencyKey]; ok {
+		return ref, nil
+	}
+	if refund.Amount <= 0 || !strings.HasPrefix(refund.ChargeRef, "fake_ch_") {
+		return "", ErrDeclined
+	}
+	charge, ok := p.charges[refund.ChargeRef]
+	if ok && charge.Refunded+refund.Amount > charge.Charge.Amount {
+		return "", ErrDeclined
+	}
+
+	ref, err := fakeRef("fake_re_")
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		charge.Refunded += refund.Amount
+	} else {
+		log.Printf("Fake refund of %s, a charge from before the last restart", refund.ChargeRef)
+	}
+	if refund.IdempotencyKey != "" {
+		p.attempts[refund.IdempotencyKey] = ref
+	}
+	log.Printf("Fake refund %s: %s %s of %s", ref, refund.Amount, refund.Currency, refund.ChargeRef)
+	return ref, nil
+}
+
+// Charges returns a copy of every charge taken so far
+func (p *FakeProvider) Charges() []FakeCharge {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	charges := make([]FakeCharge, 0, len(p.order))
+	for _, ref := range p.order {
+		charges = append(charges, *p.charges[ref])
+	}
+	return charges
+}
+
+// fakeRef returns a random reference, so those issued before a restart are
+// never handed out again
+func fakeRef(prefix string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,85 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"stock/models"
+)
+
+var (
+	// ErrDeclined is returned when a provider refuses a charge or refund
+	ErrDeclined = errors.New("payment declined")
+	// ErrUnsupportedTender is returned for a tender a provider cannot take
+	ErrUnsupportedTender = errors.New("tender is not supported by the payment provider")
+)
+
+// Charge asks a provider to take an amount from the customer
+type Charge struct {
+	Tender   string
+	Amount   models.Money
+	Currency string
+	// Reference identifies what to charge, such as a card token or a mobile
+	// money number, as collected by the till
+	Reference string
+	// IdempotencyKey is the same when a charge is retried, so it is taken once
+	IdempotencyKey string
+}
+
+// Refund asks a provider to give back part or all of an earlier charge
+type Refund struct {
+	ChargeRef      string
+	Amount         models.Money
+	Currency       string
+	IdempotencyKey string
+}
+
+// Provider takes card and mobile money payments through a payment gateway.
+// Cash and store credit never reach a provider.
+type Provider interface {
+	// Name identifies the provider on the payments it took
+	Name() string
+	// Charge takes a payment and returns the provider's reference for it
+	Charge(ctx context.Context, charge Charge) (string, error)
+	// Refund gives back money from a charge and returns the refund's reference
+	Refund(ctx context.Context, refund Refund) (string, error)
+}
+
+var (
+	mu      sync.RWMutex
+	current Provider = NewFakeProvider()
+)
+
+// Default returns the provider the application takes payments with
+func Default() Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// SetDefault replaces the provider the application takes payments with
+func SetDefault(p Provider) {
+	mu.Lock()
+	current = p
+	mu.Unlock()
+}
+
+// FromEnv builds a provider from PAYMENT_PROVIDER. Only "fake" is built in;
+// real gateways implement Provider and are set with SetDefault. It may only be
+// left unset, falling back to the fake, when APP_ENV is development.
+func FromEnv() (Provider, error) {
+	switch provider := os.Getenv("PAYMENT_PROVIDER"); provider {
+	case "fake":
+		return NewFakeProvider(), nil
+	case "":
+		if os.Getenv("APP_ENV") != "development" {
+			return nil, fmt.Errorf("PAYMENT_PROVIDER is required unless APP_ENV is development")
+		}
+		return NewFakeProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_PROVIDER %q", provider)
+	}
+}
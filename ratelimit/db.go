@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"stock/models"
+)
+
+// DBLimiter keeps counts in the rate_limits table so every instance shares them
+type DBLimiter struct {
+	db *gorm.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewDBLimiter(db *gorm.DB) *DBLimiter {
+	return &DBLimiter{db: db}
+}
+
+func (l *DBLimiter) Hit(key string, window time.Duration) (int, time.Time, error) {
+	now := time.Now()
+	l.sweep(now)
+
+	// MySQL applies the assignments left to right, so hits is reset before
+	// reset_at moves on to the next window
+	err := l.db.Exec(
+		`INSERT INTO rate_limits (bucket, hits, reset_at) VALUES (?, 1, ?)
+		ON DUPLICATE KEY UPDATE
+			hits = IF(reset_at <= ?, 1, hits + 1),
+			reset_at = IF(hits = 1, VALUES(reset_at), reset_at)`,
+		key, now.Add(window), now,
+	).Error
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var bucket models.RateLimit
+	if err := l.db.Where("bucket = ?", key).First(&bucket).Error; err != nil {
+		return 0, time.Time{}, err
+	}
+	return bucket.Hits, bucket.ResetAt, nil
+}
+
+// sweep deletes finished windows about once a minute
+func (l *DBLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) < time.Minute {
+		l.mu.Unlock()
+		return
+	}
+	l.lastSweep = now
+	l.mu.Unlock()
+
+	if err := l.db.Where("reset_at <= ?", now).Delete(&models.RateLimit{}).Error; err != nil {
+		log.Printf("Rate limiter sweep error: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryLimiter keeps counts in this process. Each instance counts on its
+// own, so use DBLimiter when several instances serve traffic.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	windows   map[string]*memoryWindow
+	lastSweep time.Time
+}
+
+type memoryWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{windows: map[string]*memoryWindow{}}
+}
+
+func (m *MemoryLimiter) Hit(key string, window time.Duration) (int, time.Time, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+	w, ok := m.windows[key]
+	if !ok || !now.Before(w.resetAt) {
+		w = &memoryWindow{resetAt: now.Add(window)}
+		m.windows[key] = w
+	}
+	w.count++
+	return w.count, w.resetAt, nil
+}
+
+// sweep drops finished windows about once a minute so idle keys do not pile up
+func (m *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for key, w := range m.windows {
+		if !now.Before(w.resetAt) {
+			delete(m.windows, key)
+		}
+	}
+}
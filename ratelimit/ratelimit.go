@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Limiter counts attempts per key in fixed windows
+type Limiter interface {
+	// Hit records an attempt for key and returns how many attempts the
+	// current window has seen and when the window ends
+	Hit(key string, window time.Duration) (count int, resetAt time.Time, err error)
+}
+
+// LimitedError is returned when a key has used up its attempts
+type LimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LimitedError) Error() string {
+	return fmt.Sprintf("too many attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+var (
+	mu      sync.RWMutex
+	current Limiter = NewMemoryLimiter()
+)
+
+// Default returns the limiter the application uses
+func Default() Limiter {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// SetDefault replaces the limiter the application uses
+func SetDefault(l Limiter) {
+	mu.Lock()
+	current = l
+	mu.Unlock()
+}
+
+// FromEnv builds a limiter from RATE_LIMIT_BACKEND: "memory" (default) keeps
+// counts in this process, "database" shares them between instances.
+func FromEnv(db *gorm.DB) (Limiter, error) {
+	switch backend := os.Getenv("RATE_LIMIT_BACKEND"); backend {
+	case "", "memory":
+		return NewMemoryLimiter(), nil
+	case "database":
+		return NewDBLimiter(db), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_BACKEND %q", backend)
+	}
+}
+
+// Check records an attempt for key on the default limiter and returns a
+// *LimitedError once more than limit attempts were made in the window. If the
+// backend fails the attempt is let through, so an outage cannot lock everyone out.
+func Check(key string, limit int, window time.Duration) error {
+	if limit <= 0 {
+		return nil
+	}
+	count, resetAt, err := Default().Hit(key, window)
+	if err != nil {
+		log.Printf("Rate limiter error for %q: %v", key, err)
+		return nil
+	}
+	if count > limit {
+		return &LimitedError{RetryAfter: time.Until(resetAt)}
+	}
+	return nil
+}
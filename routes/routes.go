@@ -5,60 +5,171 @@ import (
 	"stock/controllers"
 	"stock/middlewares"
 	"stock/models"
+	"stock/services"
 )
 
 // RegisterRoutes initializes all the routes for the Echo server
 func RegisterRoutes(e *echo.Echo) {
-	//Define CRUD endpoints for categories with admin middleware
-	categoryGroup := e.Group("/categories")
-	categoryGroup.Use(middlewares.AdminMiddleware) // Apply middleware
-	categoryGroup.GET("", controllers.GetCategories)
-	categoryGroup.GET("/:category_id", controllers.GetCategoryByID)
-	categoryGroup.POST("", controllers.CreateCategories)
-	categoryGroup.PUT("/:category_id", controllers.UpdateCategory)
-	categoryGroup.DELETE("/:id", controllers.DeleteCategoryByID)
-
-	// Define CRUD endpoints for products with admin middleware
-	productGroup := e.Group("/products")
-	productGroup.Use(middlewares.AdminMiddleware) // Apply middleware
-	productGroup.GET("", controllers.GetProducts)
-	productGroup.GET("/:product_id", controllers.GetProductByID)
-	productGroup.POST("", controllers.AddProduct)
-	productGroup.PUT("/:product_id", controllers.UpdateProduct)
-	productGroup.DELETE("/:product_id", controllers.DeleteProduct)
-	productGroup.DELETE("/:product_id/pending-deletion", controllers.MoveProductToPendingDeletion)
-	productGroup.PUT("/:product_id/recover", controllers.MoveProductFromPendingDeletion)
+	// Inventory routes are scoped to the organization of the authenticated user
+	tenant := []echo.MiddlewareFunc{middlewares.AuthMiddleware(), middlewares.TenantMiddleware}
 
-	// Define CRUD endpoints for sales
-	e.GET("/sales", controllers.GetSales)
-	e.GET("/sales/:sale_id", controllers.GetSaleByID)
-	e.POST("/sales", controllers.AddSale)
-	e.DELETE("/sales/:sale_id", controllers.DeleteSale)
-	e.GET("/salebycategory/:category_name", controllers.FetchSalesByCategory)
-	e.GET("/salebycategory/:date", controllers.FetchSalesByDate)
-	e.GET("/salebycategory/:user_id", controllers.FetchSalesByUserID)
+	can := middlewares.RequirePermission
+
+	// Define CRUD endpoints for categories
+	categoryGroup := e.Group("/categories", tenant...)
+	categoryGroup.GET("", controllers.GetCategories, can(models.PermissionCategoriesRead))
+	categoryGroup.GET("/:category_id", controllers.GetCategoryByID, can(models.PermissionCategoriesRead))
+	categoryGroup.POST("", controllers.CreateCategories, can(models.PermissionCategoriesWrite))
+	categoryGroup.PUT("/:category_id", controllers.UpdateCategory, can(models.PermissionCategoriesWrite))
+	categoryGroup.DELETE("/:id", controllers.DeleteCategoryByID, can(models.PermissionCategoriesWrite))
+
+	// Define CRUD endpoints for products
+	productGroup := e.Group("/products", tenant...)
+	productGroup.GET("", controllers.GetProducts, can(models.PermissionProductsRead))
+	productGroup.GET("/low-stock", controllers.GetLowStockProducts, can(models.PermissionProductsRead))
+	productGroup.GET("/:product_id", controllers.GetProductByID, can(models.PermissionProductsRead))
+	productGroup.POST("", controllers.AddProduct, can(models.PermissionProductsWrite))
+	productGroup.PUT("/:product_id", controllers.UpdateProduct, can(models.PermissionProductsWrite))
+	productGroup.DELETE("/:product_id", controllers.DeleteProduct, can(models.PermissionProductsWrite))
+	productGroup.DELETE("/:product_id/pending-deletion", controllers.MoveProductToPendingDeletion, can(models.PermissionProductsWrite))
+	productGroup.PUT("/:product_id/recover", controllers.MoveProductFromPendingDeletion, can(models.PermissionProductsWrite))
+	productGroup.GET("/:product_id/movements", controllers.GetProductMovements, can(models.PermissionStockRead))
+	productGroup.POST("/:product_id/movements", controllers.AdjustProductStock, can(models.PermissionStockWrite))
+	productGroup.POST("/:product_id/movements/rebuild", controllers.RebuildProductQuantity, can(models.PermissionStockWrite))
 	// Endpoint for selling products
-	e.POST("/products/:product_id/sell/:quantity_sold", controllers.SellProduct)
+	productGroup.POST("/:product_id/sell/:quantity_sold", controllers.SellProduct, can(models.PermissionSalesWrite))
+
+	// Define CRUD endpoints for suppliers
+	supplierGroup := e.Group("/suppliers", tenant...)
+	supplierGroup.GET("", controllers.GetSuppliers, can(models.PermissionSuppliersRead))
+	supplierGroup.GET("/:supplier_id", controllers.GetSupplierByID, can(models.PermissionSuppliersRead))
+	supplierGroup.POST("", controllers.CreateSupplier, can(models.PermissionSuppliersWrite))
+	supplierGroup.PUT("/:supplier_id", controllers.UpdateSupplier, can(models.PermissionSuppliersWrite))
+	supplierGroup.DELETE("/:supplier_id", controllers.DeactivateSupplier, can(models.PermissionSuppliersWrite))
+
+	// Define purchase order endpoints
+	purchaseOrderGroup := e.Group("/purchase-orders", tenant...)
+	purchaseOrderGroup.GET("", controllers.GetPurchaseOrders, can(models.PermissionPurchaseOrdersRead))
+	purchaseOrderGroup.GET("/:purchase_order_id", controllers.GetPurchaseOrderByID, can(models.PermissionPurchaseOrdersRead))
+	purchaseOrderGroup.POST("", controllers.CreatePurchaseOrder, can(models.PermissionPurchaseOrdersWrite))
+	purchaseOrderGroup.POST("/suggested", controllers.DraftSuggestedPurchaseOrder, can(models.PermissionPurchaseOrdersWrite))
+	purchaseOrderGroup.PUT("/:purchase_order_id", controllers.UpdatePurchaseOrder, can(models.PermissionPurchaseOrdersWrite))
+	purchaseOrderGroup.POST("/:purchase_order_id/send", controllers.SendPurchaseOrder, can(models.PermissionPurchaseOrdersWrite))
+	purchaseOrderGroup.POST("/:purchase_order_id/receive", controllers.ReceivePurchaseOrder, can(models.PermissionPurchaseOrdersWrite))
+	purchaseOrderGroup.POST("/:purchase_order_id/close", controllers.ClosePurchaseOrder, can(models.PermissionPurchaseOrdersWrite))
+	purchaseOrderGroup.POST("/:purchase_order_id/cancel", controllers.CancelPurchaseOrder, can(models.PermissionPurchaseOrdersWrite))
+	purchaseOrderGroup.GET("/:purchase_order_id/receipts", controllers.GetPurchaseOrderReceipts, can(models.PermissionPurchaseOrdersRead))
+
+	// Define CRUD endpoints for sales
+	saleGroup := e.Group("/sales", tenant...)
+	saleGroup.GET("", controllers.GetSales, can(models.PermissionSalesRead))
+	saleGroup.GET("/:sale_id", controllers.GetSaleByID, can(models.PermissionSalesRead))
+	saleGroup.POST("/checkout", controllers.Checkout, can(models.PermissionSalesWrite))
+	saleGroup.POST("/price-approvals", controllers.CreatePriceApproval, can(models.PermissionPricesApprove))
+	saleGroup.GET("/receipts/:receipt_id", controllers.GetReceiptByID, can(models.PermissionSalesRead))
+	saleGroup.DELETE("/:sale_id", controllers.DeleteSale, can(models.PermissionSalesVoid))
+	saleGroup.POST("/:sale_id/void", controllers.VoidSale, can(models.PermissionSalesVoid))
+	saleGroup.POST("/:sale_id/returns", controllers.ReturnSale, can(models.PermissionSalesRefund))
+	saleGroup.GET("/:sale_id/returns", controllers.GetSaleReturns, can(models.PermissionSalesRead))
+	saleGroup.POST("/receipts/:receipt_id/void", controllers.VoidReceipt, can(models.PermissionSalesVoid))
+
+	// Cashiers run their own till sessions; managers see and close everyone's
+	tillGroup := e.Group("/till-sessions", tenant...)
+	tillGroup.GET("", controllers.GetTillSessions, can(models.PermissionTillManage))
+	tillGroup.POST("", controllers.OpenTillSession, can(models.PermissionTillOperate))
+	tillGroup.GET("/current", controllers.GetCurrentTillSession, can(models.PermissionTillOperate))
+	tillGroup.GET("/:till_session_id", controllers.GetTillSessionReport, can(models.PermissionTillOperate))
+	tillGroup.POST("/:till_session_id/entries", controllers.AddTillEntry, can(models.PermissionTillOperate))
+	tillGroup.POST("/:till_session_id/close", controllers.CloseTillSession, can(models.PermissionTillOperate))
+
+	// Customers can be named on sales and buy on account
+	customerGroup := e.Group("/customers", tenant...)
+	customerGroup.GET("", controllers.GetCustomers, can(models.PermissionCustomersRead))
+	customerGroup.POST("", controllers.CreateCustomer, can(models.PermissionCustomersWrite))
+	customerGroup.GET("/:customer_id", controllers.GetCustomer, can(models.PermissionCustomersRead))
+	customerGroup.PUT("/:customer_id", controllers.UpdateCustomer, can(models.PermissionCustomersWrite))
+	customerGroup.GET("/:customer_id/statement", controllers.GetCustomerStatement, can(models.PermissionCustomersRead))
+
+	// Invoices are raised by checkouts put on account and paid off over time
+	invoiceGroup := e.Group("/invoices", tenant...)
+	invoiceGroup.GET("", controllers.GetInvoices, can(models.PermissionCustomersRead))
+	invoiceGroup.GET("/:invoice_id", controllers.GetInvoice, can(models.PermissionCustomersRead))
+	invoiceGroup.POST("/:invoice_id/payments", controllers.PayInvoice, can(models.PermissionInvoicesPay))
+
+	// Store credit is a tender at checkout, found by its code; only managers issue it
+	storeCreditGroup := e.Group("/store-credits", tenant...)
+	storeCreditGroup.POST("", controllers.IssueStoreCredit, can(models.PermissionStoreCreditsIssue))
+	storeCreditGroup.GET("/:code", controllers.GetStoreCredit, can(models.PermissionSalesRead))
+
+	// Promotions are applied to sale lines at checkout
+	promotionGroup := e.Group("/promotions", tenant...)
+	promotionGroup.GET("", controllers.GetPromotions, can(models.PermissionPromotionsRead))
+	promotionGroup.POST("", controllers.CreatePromotion, can(models.PermissionPromotionsWrite))
+	promotionGroup.PUT("/:promotion_id", controllers.UpdatePromotion, can(models.PermissionPromotionsWrite))
+	promotionGroup.DELETE("/:promotion_id", controllers.DeactivatePromotion, can(models.PermissionPromotionsWrite))
+
+	// Sales reports aggregate in the database
+	reportGroup := e.Group("/reports", tenant...)
+	reportGroup.GET("/sales/summary", controllers.GetSalesSummary, can(models.PermissionReportsRead))
+	reportGroup.GET("/sales/products", controllers.GetProductSalesRanking, can(models.PermissionReportsRead))
+	reportGroup.GET("/gross-margin", controllers.GetGrossMargin, can(models.PermissionReportsRead))
+	reportGroup.GET("/inventory-valuation", controllers.GetInventoryValuation, can(models.PermissionReportsRead))
+	reportGroup.GET("/tax", controllers.GetTaxReport, can(models.PermissionReportsRead))
+	reportGroup.GET("/till/over-short", controllers.GetTillOverShort, can(models.PermissionReportsRead))
+	reportGroup.GET("/receivables/aged", controllers.GetAgedReceivables, can(models.PermissionReportsRead))
+
+	// Tax rates are looked up by code from products, categories and the organization default
+	taxRateGroup := e.Group("/tax-rates", tenant...)
+	taxRateGroup.GET("", controllers.GetTaxRates, can(models.PermissionTaxRead))
+	taxRateGroup.POST("", controllers.CreateTaxRate, can(models.PermissionTaxWrite))
+	taxRateGroup.PUT("/:tax_rate_id", controllers.UpdateTaxRate, can(models.PermissionTaxWrite))
+	taxRateGroup.DELETE("/:tax_rate_id", controllers.DeleteTaxRate, can(models.PermissionTaxWrite))
+
+	// The audit trail is read-only
+	auditGroup := e.Group("/audit", tenant...)
+	auditGroup.GET("/events", controllers.GetAuditEvents, can(models.PermissionAuditRead))
 }
 
 func SetupRoutes(e *echo.Echo) {
+	// Every authentication endpoint shares a per-IP budget of attempts
+	authLimit := middlewares.RateLimit("auth", services.AuthIPLimit, services.AuthIPWindow)
+
 	// Public routes
-	e.POST("/superadmin/login", controllers.SuperAdminLogin)
-	e.POST("/superadmin/logout", controllers.SuperAdminLogout)
-	e.POST("admin/login", controllers.AdminLogin)
-	e.POST("admin/logout", controllers.AdminLogout)
-	e.POST("/login", controllers.Login)
-	e.POST("/logout", controllers.Logout)
-	e.POST("auditor/login", controllers.AuditorLogin)
-	e.POST("auditor/logout", controllers.AuditorLogout)
+	e.POST("/auth/login", controllers.Login, authLimit)
+	e.POST("/auth/refresh", controllers.RefreshToken, authLimit)
+	e.POST("/auth/login/verify", controllers.VerifyLogin, authLimit)
+	e.POST("/auth/login/enroll", controllers.BeginLoginEnrollment, authLimit)
+	e.POST("/auth/login/enroll/confirm", controllers.ConfirmLoginEnrollment, authLimit)
+	e.POST("/auth/password/forgot", controllers.ForgotPassword, authLimit)
+	e.POST("/auth/password/reset", controllers.ResetPassword, authLimit)
+	e.POST("/auth/invitation/accept", controllers.AcceptInvitation, authLimit)
+	e.GET("/.well-known/jwks.json", controllers.GetJWKS)
+
+	// Authenticated session routes
+	e.POST("/auth/logout", controllers.Logout, middlewares.AuthMiddleware())
+	e.POST("/auth/password", controllers.ChangePassword, middlewares.AuthMiddleware(), authLimit)
+	e.GET("/auth/me", controllers.GetProfile, middlewares.AuthMiddleware())
+	e.POST("/auth/2fa/setup", controllers.BeginMFAEnrollment, middlewares.AuthMiddleware())
+	e.POST("/auth/2fa/confirm", controllers.ConfirmMFAEnrollment, middlewares.AuthMiddleware(), authLimit)
+	e.POST("/auth/2fa/disable", controllers.DisableMFA, middlewares.AuthMiddleware(), authLimit)
+	e.POST("/auth/2fa/recovery-codes", controllers.RegenerateRecoveryCodes, middlewares.AuthMiddleware(), authLimit)
+
+	// Deprecated per-role login and logout paths, kept for older clients
+	for _, prefix := range []string{"", "/superadmin", "/admin", "/auditor"} {
+		e.POST(prefix+"/login", controllers.Login, authLimit)
+		e.POST(prefix+"/logout", controllers.Logout, middlewares.AuthMiddleware())
+	}
 
 	// Super Admin routes
 	superadmin := e.Group("/superadmin")
-	superadmin.POST("/signup", controllers.SuperAdminSignup)
+	superadmin.POST("/signup", controllers.SuperAdminSignup, authLimit)
 	superadmin.Use(middlewares.AuthMiddleware(models.SuperAdminRoleID)) // Ensure SuperAdmin is authorized
 	superadmin.POST("/addadmin", controllers.AddAdmin)
 	superadmin.POST("/addorganization", controllers.SuperAdminAddOrganization)
 	superadmin.POST("/addorganizationadmin", controllers.SuperAdminAddOrganizationAdmin)
+	// Platform users have no organization, so these manage the platform policy
+	superadmin.GET("/mfa-policy", controllers.GetMFAPolicy)
+	superadmin.PUT("/mfa-policy", controllers.UpdateMFAPolicy)
 
 	// Admin routes
 	adminGroup := e.Group("/admin")
@@ -67,25 +178,44 @@ func SetupRoutes(e *echo.Echo) {
 	adminGroup.GET("/user/:id", controllers.GetUserByID)
 	adminGroup.PUT("/user/:id", controllers.EditUser)
 	adminGroup.DELETE("/user/:id", controllers.SoftDeleteUser)
+	adminGroup.POST("/user/:id/invitation", controllers.ResendInvitation)
 	adminGroup.GET("/user", controllers.AdminViewAllUsers)
 	adminGroup.GET("/organization/:id", controllers.GetOrganizationByID)
 	adminGroup.GET("/organizations", controllers.GetAllOrganizations)
 	adminGroup.GET("/users/active", controllers.GetActiveUsers)
-	adminGroup.PUT("/user/activate", controllers.ActivateUser)
-	adminGroup.PUT("/user/deactivate", controllers.DeactivateUser)
+	adminGroup.PUT("/user/:id/activate", controllers.ActivateUser)
+	adminGroup.PUT("/user/:id/deactivate", controllers.DeactivateUser)
 	adminGroup.GET("/users/inactive", controllers.GetInactiveUsers)
+	adminGroup.GET("/users/locked", controllers.GetLockedUsers)
+	adminGroup.PUT("/user/:id/unlock", controllers.UnlockUser)
 	adminGroup.GET("/organizations/active", controllers.GetActiveOrganizations)
 	adminGroup.GET("/organizations/inactive", controllers.GetInactiveOrganizations)
 	adminGroup.PUT("/organization/:id/activate", controllers.ActivateOrganization)
 	adminGroup.PUT("/organization/:id/deactivate", controllers.DeactivateOrganization)
 	//adminGroup.DELETE("/organization:id", controllers.AdminDeleteOrganization)
 
+	// Organization admin routes act on the admin's own organization
+	can := middlewares.RequirePermission
 	orgAdminGroup := e.Group("/orgadmin")
-	orgAdminGroup.Use(middlewares.AuthMiddleware(models.OrganizationAdminRoleID))
-	orgAdminGroup.POST("/adduser", controllers.OrganizationAdminAddUser)
-	orgAdminGroup.PUT("/user/:id", controllers.OrganizationAdminEditUser)
-	orgAdminGroup.GET("/users", controllers.OrganizationAdminGetUsers)
-	orgAdminGroup.GET("/user/:id", controllers.OrganizationAdminGetUserByID)
-	orgAdminGroup.DELETE("/user/:id", controllers.OrganizationAdminSoftDeleteUser)
-	orgAdminGroup.PATCH("/users/:id/activate-deactivate", controllers.OrganizationAdminActivateDeactivateUser)
+	orgAdminGroup.Use(middlewares.AuthMiddleware(), middlewares.TenantMiddleware)
+	orgAdminGroup.POST("/adduser", controllers.OrganizationAdminAddUser, can(models.PermissionUsersWrite))
+	orgAdminGroup.PUT("/user/:id", controllers.OrganizationAdminEditUser, can(models.PermissionUsersWrite))
+	orgAdminGroup.GET("/users", controllers.OrganizationAdminGetUsers, can(models.PermissionUsersRead))
+	orgAdminGroup.GET("/user/:id", controllers.OrganizationAdminGetUserByID, can(models.PermissionUsersRead))
+	orgAdminGroup.DELETE("/user/:id", controllers.OrganizationAdminSoftDeleteUser, can(models.PermissionUsersWrite))
+	orgAdminGroup.POST("/user/:id/invitation", controllers.OrganizationAdminResendInvitation, can(models.PermissionUsersWrite))
+	orgAdminGroup.PATCH("/users/:id/activate-deactivate", controllers.OrganizationAdminActivateDeactivateUser, can(models.PermissionUsersWrite))
+	orgAdminGroup.GET("/users/locked", controllers.OrganizationAdminGetLockedUsers, can(models.PermissionUsersRead))
+	orgAdminGroup.PUT("/user/:id/unlock", controllers.OrganizationAdminUnlockUser, can(models.PermissionUsersWrite))
+	orgAdminGroup.GET("/permissions", controllers.GetPermissions, can(models.PermissionRolesWrite))
+	orgAdminGroup.GET("/roles", controllers.GetRoles, can(models.PermissionUsersRead))
+	orgAdminGroup.POST("/roles", controllers.CreateRole, can(models.PermissionRolesWrite))
+	orgAdminGroup.PUT("/roles/:role_id", controllers.UpdateRole, can(models.PermissionRolesWrite))
+	orgAdminGroup.DELETE("/roles/:role_id", controllers.DeleteRole, can(models.PermissionRolesWrite))
+	orgAdminGroup.GET("/mfa-policy", controllers.GetMFAPolicy, can(models.PermissionRolesWrite))
+	orgAdminGroup.PUT("/mfa-policy", controllers.UpdateMFAPolicy, can(models.PermissionRolesWrite))
+	orgAdminGroup.GET("/costing-method", controllers.OrganizationAdminGetCostingMethod, can(models.PermissionStockRead))
+	orgAdminGroup.PUT("/costing-method", controllers.OrganizationAdminUpdateCostingMethod, can(models.PermissionStockWrite))
+	orgAdminGroup.GET("/tax-settings", controllers.OrganizationAdminGetTaxSettings, can(models.PermissionTaxRead))
+	orgAdminGroup.PUT("/tax-settings", controllers.OrganizationAdminUpdateTaxSettings, can(models.PermissionTaxWrite))
 }
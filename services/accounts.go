@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/mailer"
+	"stock/models"
+	"stock/utils"
+)
+
+var (
+	ErrAccountTokenInvalid = errors.New("link is invalid or expired")
+	ErrEmailRequired       = errors.New("an email address is required to invite a user")
+	ErrInvitationAccepted  = errors.New("invitation has already been accepted")
+	ErrNotInvited          = errors.New("user was not invited")
+)
+
+// Lifetimes of emailed account links
+var (
+	InvitationTTL    = 7 * 24 * time.Hour
+	PasswordResetTTL = time.Hour
+)
+
+// InviteUser prepares a new user who will choose their own password. The
+// password is set to a random value nobody knows until the invitation is
+// accepted. Call it before the user is created, then CreateInvitation.
+func InviteUser(user *models.User) error {
+	if user.Email == "" {
+		return ErrEmailRequired
+	}
+	placeholder, err := utils.RandomToken(32)
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := utils.HashPassword(placeholder)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	return nil
+}
+
+// CreateInvitation issues the user a link to set their password and returns
+// the email carrying it, to send with SendAccountEmail once the transaction
+// has committed. Earlier invitations stop working.
+func CreateInvitation(tx *gorm.DB, user *models.User, invitedBy uint) (*mailer.Message, error) {
+	if user.Email == "" {
+		return nil, ErrEmailRequired
+	}
+	var accepted int64
+	if err := tx.Model(&models.AccountToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NOT NULL", user.ID, models.AccountTokenInvitation).
+		Count(&accepted).Error; err != nil {
+		return nil, err
+	}
+	if accepted > 0 {
+		return nil, ErrInvitationAccepted
+	}
+
+	token, err := createAccountToken(tx, user.ID, models.AccountTokenInvitation, InvitationTTL, invitedBy)
+	if err != nil {
+		return nil, err
+	}
+	return &mailer.Message{
+		To:      user.Email,
+		Subject: "You have been invited",
+		Body: fmt.Sprintf("Hello %s,\n\nAn account has been created for you with the username %s. "+
+			"Choose your password here:\n\n%s\n\nThe link expires in %s.\n",
+			displayName(user), user.Username, accountLink("/accept-invitation", token), InvitationTTL),
+	}, nil
+}
+
+// RenewInvitation issues a fresh invitation to a user who was invited but has
+// not accepted yet
+func RenewInvitation(tx *gorm.DB, user *models.User, invitedBy uint) (*mailer.Message, error) {
+	var invitations int64
+	if err := tx.Model(&models.AccountToken{}).
+		Where("user_id = ? AND purpose = ?", user.ID, models.AccountTokenInvitation).
+		Count(&invitations).Error; err != nil {
+		return nil, err
+	}
+	if invitations == 0 {
+		return nil, ErrNotInvited
+	}
+	return CreateInvitation(tx, user, invitedBy)
+}
+
+// RequestPasswordReset issues a reset link to the active user with the given
+// email and returns the email carrying it, or nil when there is no such user.
+// The caller answers the same either way, so the response does not reveal
+// which addresses have accounts.
+func RequestPasswordReset(tx *gorm.DB, email string) (*mailer.Message, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil, nil
+	}
+
+	var user models.User
+	if err := tx.Where("email = ? AND is_active = ?", email, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Password reset requested for unknown email")
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	token, err := createAccountToken(tx, user.ID, models.AccountTokenPasswordReset, PasswordResetTTL, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nSomeone asked to reset the password for %s. "+
+			"If it was you, choose a new password here:\n\n%s\n\n"+
+			"The link expires in %s. If you did not ask for this you can ignore this email.\n",
+			displayName(&user), user.Username, accountLink("/reset-password", token), PasswordResetTTL),
+	}, nil
+}
+
+// SendAccountEmail sends an invitation or reset email. Call it after the
+// transaction that issued the link has committed, so a link is never mailed
+// for a token that was rolled back. A nil message sends nothing.
+func SendAccountEmail(msg *mailer.Message) error {
+	if msg == nil {
+		return nil
+	}
+	return mailer.Default().Send(*msg)
+}
+
+// RedeemAccountToken sets the password of the user a token was issued to and
+// uses up the token. Existing sessions are signed out.
+func RedeemAccountToken(tx *gorm.DB, token, purpose, password string) (*models.User, error) {
+	var stored models.AccountToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&stored).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAccountTokenInvalid
+		}
+		return nil, err
+	}
+	if stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrAccountTokenInvalid
+	}
+
+	var user models.User
+	if err := tx.First(&user, stored.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAccountTokenInvalid
+		}
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, ErrUserInactive
+	}
+
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&user).Update("password", hashedPassword).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&stored).Update("used_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+	if err := RevokeUserTokens(tx, user.ID); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// createAccountToken issues a token and retires the user's other open tokens
+// for the same purpose
+func createAccountToken(tx *gorm.DB, userID uint, purpose string, ttl time.Duration, createdBy uint) (string, error) {
+	now := time.Now()
+	if err := tx.Model(&models.AccountToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", userID, purpose, now).
+		Update("expires_at", now).Error; err != nil {
+		return "", err
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	stored := models.AccountToken{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		Purpose:   purpose,
+		ExpiresAt: now.Add(ttl),
+		CreatedBy: createdBy,
+	}
+	if err := tx.Create(&stored).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// accountLink builds a link into the web app, which is at APP_URL
+func accountLink(path, token string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:8000"
+	}
+	return strings.TrimRight(base, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+func displayName(user *models.User) string {
+	if user.FirstName != "" {
+		return user.FirstName
+	}
+	return user.Username
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+
+	"gorm.io/gorm"
+	"stock/models"
+)
+
+// Actor is who made a change and the request it came in with
+type Actor struct {
+	UserID uint
+	RoleID uint
+	// OrganizationID is the organization whose audit trail the event goes to.
+	// Platform admins acting on an organization's data set it to that organization.
+	OrganizationID uint
+	IP             string
+	RequestID      string
+}
+
+// auditIgnoredFields change on every write and say nothing about the change
+var auditIgnoredFields = map[string]bool{
+	"updated_at": true,
+}
+
+// auditRedactedFields are recorded as changed without their values
+var auditRedactedFields = map[string]bool{
+	"password": true,
+}
+
+// RecordAudit writes an audit event for a change to an entity. before is nil
+// for a create and after is nil for a delete. Pass the transaction making the
+// change so the event is only kept if the change is.
+func RecordAudit(db *gorm.DB, actor Actor, action, entityType string, entityID uint, before, after interface{}) error {
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		return err
+	}
+	return db.Create(&models.AuditEvent{
+		OrganizationID: actor.OrganizationID,
+		ActorID:        actor.UserID,
+		ActorRoleID:    actor.RoleID,
+		Action:         action,
+		EntityType:     entityType,
+		EntityID:       entityID,
+		Changes:        changes,
+		IP:             actor.IP,
+		RequestID:      actor.RequestID,
+	}).Error
+}
+
+// RecordAuditLogged is RecordAudit for changes made outside a transaction,
+// where a failure to audit cannot undo the change any more
+func RecordAuditLogged(db *gorm.DB, actor Actor, action, entityType string, entityID uint, before, after interface{}) {
+	if err := RecordAudit(db, actor, action, entityType, entityID, before, after); err != nil {
+		log.Printf("Audit error for %s %s %d: %v", action, entityType, entityID, err)
+	}
+}
+
+// RecordProductUpdate audits an edit to a product. A price change also gets
+// its own event so price history can be followed on its own.
+func RecordProductUpdate(db *gorm.DB, actor Actor, before, after *models.Product) error {
+	if err := RecordAudit(db, actor, models.AuditProductUpdated, "product", uint(after.ProductID), before, after); err != nil {
+		return err
+	}
+	if before.Price == after.Price {
+		return nil
+	}
+	return RecordAudit(db, actor, models.AuditProductPriceChanged, "product", uint(after.ProductID),
+		map[string]interface{}{"price": before.Price}, map[string]interface{}{"price": after.Price})
+}
+
+// auditChanges compares the JSON form of two snapshots and returns the fields
+// that differ as {"field": {"before": ..., "after": ...}}
+func auditChanges(before, after interface{}) (json.RawMessage, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]map[string]interface{}{}
+	for _, fields := range []map[string]interface{}{beforeFields, afterFields} {
+		for name := range fields {
+			if auditIgnoredFields[name] || changes[name] != nil {
+				continue
+			}
+			b, a := beforeFields[name], afterFields[name]
+			if reflect.DeepEqual(b, a) {
+				continue
+			}
+			if auditRedactedFields[name] {
+				changes[name] = map[string]interface{}{"changed": true}
+				continue
+			}
+			changes[name] = map[string]interface{}{"before": b, "after": a}
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(changes)
+}
+
+func auditFields(v interface{}) (map[string]interface{}, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return map[string]interface{}{}, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
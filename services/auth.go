@@ -0,0 +1,191 @@
+package services
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"stock/models"
+	"stock/utils"
+)
+
+var (
+	ErrInvalidCredentials   = errors.New("invalid username, email or password")
+	ErrOrganizationInactive = errors.New("organization is deactivated")
+)
+
+// dummyPasswordHash is compared against when no user matches a login
+const dummyPasswordHash = "$2a$10$4jugJzB83wfP968eMMqz/Og3WUNxm2rhRe0kcZkA0SFmfZ95vz7fS"
+
+// Profile describes who a user is and what they may do
+type Profile struct {
+	ID           uint                 `json:"id"`
+	Username     string               `json:"username"`
+	Email        string               `json:"email"`
+	FirstName    string               `json:"first_name"`
+	LastName     string               `json:"last_name"`
+	Role         ProfileRole          `json:"role"`
+	Organization *ProfileOrganization `json:"organization,omitempty"`
+	Permissions  []string             `json:"permissions"`
+}
+
+// ProfileRole is the role part of a Profile
+type ProfileRole struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// ProfileOrganization is the organization part of a Profile. Platform users have none.
+type ProfileOrganization struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// LoginResult is returned by a login step. When a second factor is still
+// needed it carries an MFA token instead of a token pair.
+type LoginResult struct {
+	*TokenPair
+	Profile *Profile `json:"profile,omitempty"`
+	// MFARequired asks for a TOTP or recovery code for MFAToken
+	MFARequired bool `json:"mfa_required,omitempty"`
+	// MFAEnrollmentRequired asks the user to enroll before signing in, because
+	// their organization requires it for their role
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
+	MFAToken              string `json:"mfa_token,omitempty"`
+}
+
+// Authenticate checks a password against the user with the given username or
+// email. Soft-deleted and inactive users and users of a deactivated
+// organization cannot sign in, and wrong passwords count towards a lockout.
+func Authenticate(db *gorm.DB, identifier, password string) (*models.User, error) {
+	if identifier == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	var user models.User
+	if err := db.Where("username = ? OR email = ?", identifier, identifier).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Spend as long as a real check so timing does not reveal unknown users
+			utils.CheckPasswordHash(password, dummyPasswordHash)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if accountLocked(&user) {
+		return nil, ErrAccountLocked
+	}
+	if err := utils.CheckPasswordHash(password, user.Password); err != nil {
+		if err := recordLoginFailure(db, &user); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+	if err := clearLoginFailures(db, &user); err != nil {
+		return nil, err
+	}
+	if err := checkSignIn(db, &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// checkSignIn refuses inactive users and users of a deactivated organization.
+// It is checked at login and again every time a session is refreshed.
+func checkSignIn(db *gorm.DB, user *models.User) error {
+	if !user.IsActive {
+		return ErrUserInactive
+	}
+
+	if user.OrganizationID != 0 {
+		var org models.Organization
+		if err := db.First(&org, user.OrganizationID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrOrganizationInactive
+			}
+			return err
+		}
+		if !org.IsActive || org.DeletedAt != nil {
+			return ErrOrganizationInactive
+		}
+	}
+	return nil
+}
+
+// Login authenticates the user by password. Users without two-factor
+// authentication get a token pair at once; the others get an MFA challenge.
+func Login(db *gorm.DB, identifier, password string) (*LoginResult, error) {
+	user, err := Authenticate(db, identifier, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		token, err := CreateMFAChallenge(db, user.ID, models.MFAChallengeVerify)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFARequired: true, MFAToken: token}, nil
+	}
+
+	required, err := MFARequired(db, user)
+	if err != nil {
+		return nil, err
+	}
+	if required {
+		token, err := CreateMFAChallenge(db, user.ID, models.MFAChallengeEnroll)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAEnrollmentRequired: true, MFAToken: token}, nil
+	}
+
+	return CompleteLogin(db, user)
+}
+
+// CompleteLogin issues the token pair and profile for a fully authenticated user
+func CompleteLogin(db *gorm.DB, user *models.User) (*LoginResult, error) {
+	pair, err := IssueTokens(db, user)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := LoadProfile(db, user)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{TokenPair: pair, Profile: profile}, nil
+}
+
+// LoadProfile gathers the user's role, organization and permissions
+func LoadProfile(db *gorm.DB, user *models.User) (*Profile, error) {
+	profile := &Profile{
+		ID:          user.ID,
+		Username:    user.Username,
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		Role:        ProfileRole{ID: user.RoleID},
+		Permissions: []string{},
+	}
+
+	var role models.Role
+	if err := db.Preload("Permissions").First(&role, user.RoleID).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	} else {
+		profile.Role.Name = role.Name
+		for _, permission := range role.Permissions {
+			profile.Permissions = append(profile.Permissions, permission.Name)
+		}
+	}
+
+	if user.OrganizationID != 0 {
+		var org models.Organization
+		if err := db.First(&org, user.OrganizationID).Error; err != nil {
+			return nil, err
+		}
+		profile.Organization = &ProfileOrganization{ID: org.ID, Name: org.Name}
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/models"
+	"stock/utils"
+)
+
+var (
+	ErrEmptyBasket            = errors.New("basket has no lines")
+	ErrInvalidQuantity        = errors.New("quantity must be greater than zero")
+	ErrInvalidIdempotencyKey  = errors.New("idempotency key must be at most 64 characters")
+	ErrCheckoutAlreadyHandled = errors.New("a checkout with this idempotency key was already made")
+)
+
+// CheckoutLine is one requested line of a basket. Price overrides the
+// product's price; lowering it by more than PriceOverrideApprovalPercent
+// needs a manager's ApprovalToken.
+type CheckoutLine struct {
+	ProductID     int           `json:"product_id"`
+	Quantity      int           `json:"quantity"`
+	Price         *models.Money `json:"price,omitempty"`
+	ApprovalToken string        `json:"approval_token,omitempty"`
+}
+
+// Basket is what a customer buys in one checkout, with any coupon codes
+// they hand over and the payments they make. CustomerID names the customer,
+// who is needed to put anything on account; DueDate overrides their payment
+// terms for it. A client that may retry the checkout sends the same
+// IdempotencyKey each time.
+type Basket struct {
+	Lines          []CheckoutLine `json:"lines"`
+	CouponCodes    []string       `json:"coupon_codes"`
+	Payments       []PaymentInput `json:"payments"`
+	CustomerID     uint           `json:"customer_id"`
+	DueDate        *time.Time     `json:"due_date"`
+	IdempotencyKey string         `json:"idempotency_key,omitempty"`
+}
+
+// FindCheckout returns the receipt an organization's checkout with an
+// idempotency key made, or nil if there is none
+func FindCheckout(db *gorm.DB, orgID uint, key string) (*models.Receipt, error) {
+	var receipt models.Receipt
+	err := db.Preload("Lines").Preload("Payments").Preload("Invoice").
+		Where("organization_id = ? AND idempotency_key = ?", orgID, key).First(&receipt).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// ShortLine describes a basket line that cannot be fulfilled
+type ShortLine struct {
+	ProductID int `json:"product_id"`
+	Available int `json:"available"`
+	Requested int `json:"requested"`
+}
+
+// InsufficientStockError lists every line of a basket that is short
+type InsufficientStockError struct {
+	Lines []ShortLine
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient quantity for %d line(s)", len(e.Lines))
+}
+
+func (e *InsufficientStockError) Unwrap() error {
+	return ErrInsufficientStock
+}
+
+// Checkout sells a basket in one transaction. All affected products are locked
+// up front and the whole basket is rejected if any line is short. Each line
+// gets the best running promotion unless its price was overridden. The
+// payments must cover the total; they are taken last so that a basket
+// rejected for any other reason never charges the customer. A basket whose
+// idempotency key was already checked out is rejected with
+// ErrCheckoutAlreadyHandled.
+func Checkout(tx *gorm.DB, orgID uint, userID uint, basket Basket) (*models.Receipt, error) {
+	if len(basket.Lines) == 0 {
+		return nil, ErrEmptyBasket
+	}
+	if len(basket.IdempotencyKey) > 64 {
+		return nil, ErrInvalidIdempotencyKey
+	}
+
+	// Merge repeated products so stock is checked against the combined
+	// quantity. Merged lines must agree on any price override; the first
+	// line's approval token is the one used.
+	var order []int
+	requested := make(map[int]int)
+	overrides := make(map[int]CheckoutLine)
+	for _, line := range basket.Lines {
+		if line.Quantity <= 0 {
+			return nil, ErrInvalidQuantity
+		}
+		if _, seen := requested[line.ProductID]; !seen {
+			order = append(order, line.ProductID)
+			if line.Price != nil {
+				overrides[line.ProductID] = line
+			}
+		} else {
+			override, overridden := overrides[line.ProductID]
+			if overridden != (line.Price != nil) || (overridden && *override.Price != *line.Price) {
+				return nil, ErrConflictingPrices
+			}
+		}
+		requested[line.ProductID] += line.Quantity
+	}
+
+	// Lock in primary key order so concurrent checkouts cannot deadlock
+	ids := append([]int(nil), order...)
+	sort.Ints(ids)
+	var products []models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id IN ? AND organization_id = ?", ids, orgID).Order("product_id").Find(&products).Error; err != nil {
+		return nil, err
+	}
+	if len(products) != len(ids) {
+		return nil, ErrProductNotFound
+	}
+
+	byID := make(map[int]*models.Product, len(products))
+	for i := range products {
+		byID[products[i].ProductID] = &products[i]
+	}
+
+	var short []ShortLine
+	for _, id := range order {
+		if byID[id].Quantity < requested[id] {
+			short = append(short, ShortLine{ProductID: id, Available: byID[id].Quantity, Requested: requested[id]})
+		}
+	}
+	if len(short) > 0 {
+		return nil, &InsufficientStockError{Lines: short}
+	}
+
+	// Checked once the products are locked, so a retry racing the original
+	// waits for it and then sees its receipt
+	key := basket.IdempotencyKey
+	if key == "" {
+		random, err := utils.RandomToken(16)
+		if err != nil {
+			return nil, err
+		}
+		key = random
+	} else {
+		var repeats int64
+		if err := tx.Model(&models.Receipt{}).Where("organization_id = ? AND idempotency_key = ?", orgID, key).
+			Count(&repeats).Error; err != nil {
+			return nil, err
+		}
+		if repeats > 0 {
+			return nil, ErrCheckoutAlreadyHandled
+		}
+	}
+
+	currency, err := OrganizationCurrency(tx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	taxes, err := newTaxResolver(tx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	promotions, err := loadPromotions(tx, orgID, now, basket.CouponCodes)
+	if err != nil {
+		return nil, err
+	}
+	// Share-lock the cashier's till session so it cannot close under the sale
+	till, err := openTillSession(tx.Clauses(clause.Locking{Strength: "SHARE"}), orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	receipt := models.Receipt{OrganizationID: orgID, UserID: userID, Date: now, Currency: currency, IdempotencyKey: key}
+	if till != nil {
+		receipt.TillSessionID = till.ID
+	}
+	if basket.CustomerID != 0 {
+		customer, err := FindCustomer(tx, orgID, basket.CustomerID)
+		if err != nil {
+			return nil, err
+		}
+		if !customer.IsActive {
+			return nil, ErrCustomerInactive
+		}
+		receipt.CustomerID = customer.ID
+	}
+	if err := tx.Create(&receipt).Error; err != nil {
+		return nil, err
+	}
+
+	for _, id := range order {
+		product := byID[id]
+		rate, err := taxes.rateFor(product)
+		if err != nil {
+			return nil, err
+		}
+		sale := models.Sale{
+			OrganizationID: orgID,
+			ReceiptID:      receipt.ReceiptID,
+			ProductID:      product.ProductID,
+			Name:           product.ProductName,
+			Price:          product.Price,
+			Quantity:       requested[id],
+			UserID:         strconv.Itoa(int(userID)),
+			Date:           now,
+			CategoryName:   product.CategoryName,
+			Currency:       currency,
+			ListPrice:      product.Price,
+			TillSessionID:  receipt.TillSessionID,
+			CustomerID:     receipt.CustomerID,
+		}
+
+		var approval *models.PriceApproval
+		if override, ok := overrides[id]; ok {
+			if approval, err = overridePrice(tx, product, *override.Price, override.ApprovalToken); err != nil {
+				return nil, err
+			}
+			sale.Price = *override.Price
+			if approval != nil {
+				sale.OverrideApprovedBy = approval.ApprovedBy
+			}
+		} else if promotion, discount := promotions.best(product, sale.Price, sale.Quantity, currency); promotion != nil {
+			sale.PromotionID, sale.DiscountAmount = promotion.ID, discount
+		}
+
+		taxes.applyTax(&sale, rate)
+		if err := tx.Create(&sale).Error; err != nil {
+			return nil, err
+		}
+		if approval != nil {
+			if err := tx.Model(approval).Update("sale_id", sale.SaleID).Error; err != nil {
+				return nil, err
+			}
+		}
+
+		movement, err := ApplyMovement(tx, product, Movement{
+			Delta:         -sale.Quantity,
+			Reason:        models.MovementReasonSale,
+			UserID:        userID,
+			ReferenceType: "sale",
+			ReferenceID:   uint(sale.SaleID),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Post the cost of goods sold now, at the cost the stock left at
+		sale.CostOfGoods = -movement.ValueDelta
+		if err := tx.Model(&models.Sale{}).Where("sale_id = ?", sale.SaleID).Update("cost_of_goods", sale.CostOfGoods).Error; err != nil {
+			return nil, err
+		}
+
+		receipt.TotalQuantity += sale.Quantity
+		receipt.TotalAmount += sale.LineTotal
+		receipt.TotalTax += sale.TaxAmount
+		receipt.TotalDiscount += sale.DiscountAmount
+		receipt.Lines = append(receipt.Lines, sale)
+	}
+
+	if err := takePayments(tx, &receipt, basket.Payments, basket.DueDate); err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&receipt).Updates(map[string]interface{}{
+		"total_quantity": receipt.TotalQuantity,
+		"total_amount":   receipt.TotalAmount,
+		"total_tax":      receipt.TotalTax,
+		"total_discount": receipt.TotalDiscount,
+		"change_due":     receipt.ChangeDue,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	return &receipt, nil
+}
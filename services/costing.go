@@ -0,0 +1,239 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"gorm.io/gorm"
+	"stock/models"
+)
+
+var ErrInvalidCostingMethod = errors.New("costing method must be fifo or weighted_average")
+
+// Stock is costed both ways all the time: cost layers are used up oldest
+// first and each product keeps a weighted average cost. The organization's
+// costing method picks which one values stock and prices cost of goods sold,
+// so it can be changed without rebuilding anything.
+
+// OrganizationCostingMethod returns the costing method of an organization
+func OrganizationCostingMethod(db *gorm.DB, orgID uint) (string, error) {
+	var methods []string
+	if err := db.Model(&models.Organization{}).Where("id = ?", orgID).Pluck("costing_method", &methods).Error; err != nil {
+		return "", err
+	}
+	if len(methods) == 1 && methods[0] == models.CostingWeightedAverage {
+		return models.CostingWeightedAverage, nil
+	}
+	return models.CostingFIFO, nil
+}
+
+// SetOrganizationCostingMethod changes how an organization values its stock
+func SetOrganizationCostingMethod(db *gorm.DB, orgID uint, method string) error {
+	if method != models.CostingFIFO && method != models.CostingWeightedAverage {
+		return ErrInvalidCostingMethod
+	}
+	return db.Model(&models.Organization{}).Where("id = ?", orgID).Update("costing_method", method).Error
+}
+
+// costMovement works out what a movement is worth and updates the product's
+// cost layers and average cost to match. Incoming stock comes in at
+// m.UnitCost, or at the current average cost when none is given. It returns
+// the unit cost and the signed change in stock value.
+func costMovement(tx *gorm.DB, product *models.Product, m Movement) (models.Money, models.Money, error) {
+	if m.Delta > 0 {
+		unitCost := m.UnitCost
+		if unitCost <= 0 {
+			unitCost = product.AverageCost
+		}
+		if product.Quantity <= 0 {
+			product.AverageCost = unitCost
+		} else {
+			total := product.AverageCost.Mul(product.Quantity) + unitCost.Mul(m.Delta)
+			product.AverageCost = total.Div(product.Quantity + m.Delta)
+		}
+		return unitCost, unitCost.Mul(m.Delta), nil
+	}
+
+	quantity := -m.Delta
+	fifoCost, err := consumeCostLayers(tx, product, quantity)
+	if err != nil {
+		return 0, 0, err
+	}
+	method, err := OrganizationCostingMethod(tx, product.OrganizationID)
+	if err != nil {
+		return 0, 0, err
+	}
+	cost := fifoCost
+	if method == models.CostingWeightedAverage {
+		cost = product.AverageCost.Mul(quantity)
+	}
+	return cost.Div(quantity), -cost, nil
+}
+
+// addCostLayer records incoming stock as a new layer
+func addCostLayer(tx *gorm.DB, movement *models.StockMovement) error {
+	return tx.Create(&models.CostLayer{
+		OrganizationID: movement.OrganizationID,
+		ProductID:      movement.ProductID,
+		MovementID:     movement.ID,
+		Quantity:       movement.Delta,
+		Remaining:      movement.Delta,
+		UnitCost:       movement.UnitCost,
+	}).Error
+}
+
+// consumeCostLayers uses up quantity from the oldest layers and returns what
+// it cost. Stock on hand without layers is costed at the average cost.
+func consumeCostLayers(tx *gorm.DB, product *models.Product, quantity int) (models.Money, error) {
+	var layers []models.CostLayer
+	if err := tx.Where("product_id = ? AND remaining > 0", product.ProductID).Order("id").Find(&layers).Error; err != nil {
+		return 0, err
+	}
+
+	var cost models.Money
+	left := quantity
+	for _, layer := range layers {
+		if left == 0 {
+			break
+		}
+		take := layer.Remaining
+		if take > left {
+			take = left
+		}
+		if err := tx.Model(&models.CostLayer{}).Where("id = ?", layer.ID).
+			Update("remaining", layer.Remaining-take).Error; err != nil {
+			return 0, err
+		}
+		cost += layer.UnitCost.Mul(take)
+		left -= take
+	}
+	cost += product.AverageCost.Mul(left)
+	return cost, nil
+}
+
+// ProductValuation is what one product's stock on hand is worth
+type ProductValuation struct {
+	ProductID    int          `json:"product_id"`
+	ProductName  string       `json:"product_name"`
+	CategoryName string       `json:"category_name"`
+	Quantity     int          `json:"quantity"`
+	UnitCost     models.Money `json:"unit_cost"`
+	Value        models.Money `json:"value"`
+}
+
+// CategoryValuation totals the stock of one category
+type CategoryValuation struct {
+	CategoryName string       `json:"category_name"`
+	Quantity     int          `json:"quantity"`
+	Value        models.Money `json:"value"`
+}
+
+// InventoryValuation is what the stock on hand is worth, each organization's
+// under its own costing method
+type InventoryValuation struct {
+	Products   []ProductValuation  `json:"products"`
+	Categories []CategoryValuation `json:"categories"`
+	Quantity   int                 `json:"quantity"`
+	Value      models.Money        `json:"value"`
+}
+
+// ValueInventory values the stock of an organization, or of every
+// organization when orgID is 0
+func ValueInventory(db *gorm.DB, orgID uint) (*InventoryValuation, error) {
+	query := db.Table("products AS p").
+		Select("p.product_id, p.product_name, p.category_name, p.quantity, "+
+			"CASE WHEN o.costing_method = ? THEN p.quantity * p.average_cost ELSE COALESCE(l.value, 0) END AS value",
+			models.CostingWeightedAverage).
+		Joins("JOIN organizations AS o ON o.id = p.organization_id").
+		Joins("LEFT JOIN (SELECT product_id, SUM(remaining * unit_cost) AS value FROM cost_layers" +
+			" WHERE remaining > 0 GROUP BY product_id) AS l ON l.product_id = p.product_id").
+		Where("p.quantity > 0")
+	if orgID != 0 {
+		query = query.Where("p.organization_id = ?", orgID)
+	}
+
+	valuation := &InventoryValuation{Products: []ProductValuation{}, Categories: []CategoryValuation{}}
+	if err := query.Order("p.product_id").Scan(&valuation.Products).Error; err != nil {
+		return nil, err
+	}
+
+	categories := map[string]*CategoryValuation{}
+	for i := range valuation.Products {
+		product := &valuation.Products[i]
+		product.UnitCost = product.Value.Div(product.Quantity)
+
+		category := categories[product.CategoryName]
+		if category == nil {
+			category = &CategoryValuation{CategoryName: product.CategoryName}
+			categories[product.CategoryName] = category
+		}
+		category.Quantity += product.Quantity
+		category.Value += product.Value
+		valuation.Quantity += product.Quantity
+		valuation.Value += product.Value
+	}
+	for _, category := range categories {
+		valuation.Categories = append(valuation.Categories, *category)
+	}
+	sort.Slice(valuation.Categories, func(i, j int) bool {
+		return valuation.Categories[i].CategoryName < valuation.Categories[j].CategoryName
+	})
+	return valuation, nil
+}
+
+// MarginGroup is the gross margin of a product or category over a period.
+// Revenue and cost of goods are net of returns; voided sales are left out.
+type MarginGroup struct {
+	Key           string       `json:"key"`
+	Label         string       `json:"label,omitempty"`
+	Units         int64        `json:"units"`
+	Revenue       models.Money `json:"revenue"`
+	CostOfGoods   models.Money `json:"cost_of_goods"`
+	Margin        models.Money `json:"margin"`
+	MarginPercent *float64     `json:"margin_percent"`
+}
+
+// GrossMargins totals revenue, cost of goods sold and margin over a period by
+// product or category
+func GrossMargins(db *gorm.DB, period SalesPeriod, groupBy string) ([]MarginGroup, MarginGroup, error) {
+	var keySQL, labelSQL string
+	switch groupBy {
+	case "product":
+		keySQL, labelSQL = "CAST(product_id AS CHAR)", "MAX(name)"
+	case "category":
+		keySQL, labelSQL = "category_name", "''"
+	default:
+		return nil, MarginGroup{}, ErrInvalidGrouping
+	}
+
+	groups := []MarginGroup{}
+	err := periodSales(db, period).
+		Select(keySQL + " AS `key`, " + labelSQL + " AS label, " + netUnitsSQL + " AS units, " + netRevenueSQL + " AS revenue, " +
+			"COALESCE(SUM(" + keptSQL("cost_of_goods") + "), 0) AS cost_of_goods").
+		Group("`key`").Order("`key`").Scan(&groups).Error
+	if err != nil {
+		return nil, MarginGroup{}, err
+	}
+
+	var totals MarginGroup
+	for i := range groups {
+		group := &groups[i]
+		group.Margin = group.Revenue - group.CostOfGoods
+		group.MarginPercent = marginPercent(group.Margin, group.Revenue)
+		totals.Units += group.Units
+		totals.Revenue += group.Revenue
+		totals.CostOfGoods += group.CostOfGoods
+	}
+	totals.Margin = totals.Revenue - totals.CostOfGoods
+	totals.MarginPercent = marginPercent(totals.Margin, totals.Revenue)
+	return groups, totals, nil
+}
+
+func marginPercent(margin, revenue models.Money) *float64 {
+	if revenue == 0 {
+		return nil
+	}
+	percent := math.Round(margin.Float64()/revenue.Float64()*10000) / 100
+	return &percent
+}
@@ -0,0 +1,21 @@
+package services
+
+import (
+	"gorm.io/gorm"
+	"stock/models"
+)
+
+// DefaultCurrency is given to organizations created without a currency
+var DefaultCurrency = "USD"
+
+// OrganizationCurrency returns the currency an organization's amounts are in
+func OrganizationCurrency(db *gorm.DB, orgID uint) (string, error) {
+	var currencies []string
+	if err := db.Model(&models.Organization{}).Where("id = ?", orgID).Pluck("currency", &currencies).Error; err != nil {
+		return "", err
+	}
+	if len(currencies) == 0 || currencies[0] == "" {
+		return DefaultCurrency, nil
+	}
+	return currencies[0], nil
+}
@@ -0,0 +1,499 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/models"
+	"stock/payments"
+)
+
+var (
+	ErrCustomerNotFound      = errors.New("customer not found")
+	ErrInvalidCustomer       = errors.New("customer needs a name, and credit limit and payment terms must not be negative")
+	ErrCustomerInactive      = errors.New("customer is inactive")
+	ErrCustomerRequired      = errors.New("account payments need a customer")
+	ErrCreditLimitExceeded   = errors.New("sale would take the customer over their credit limit")
+	ErrInvoiceNotFound       = errors.New("invoice not found")
+	ErrInvoicePaid           = errors.New("invoice is already paid")
+	ErrInvalidInvoicePayment = errors.New("invoice payments need a tender of cash, card or mobile_money and an amount above zero")
+	ErrInvoiceOverpaid       = errors.New("payment is more than the invoice's balance")
+)
+
+// DefaultPaymentTermsDays is how long credit sales have to be paid when a
+// customer is created without payment terms
+var DefaultPaymentTermsDays = 30
+
+// SaveCustomer validates and creates or updates a customer
+func SaveCustomer(db *gorm.DB, customer *models.Customer) error {
+	customer.Name = strings.TrimSpace(customer.Name)
+	customer.Email = strings.TrimSpace(customer.Email)
+	customer.Phone = strings.TrimSpace(customer.Phone)
+	if customer.Name == "" || customer.CreditLimit < 0 || customer.PaymentTermsDays < 0 {
+		return ErrInvalidCustomer
+	}
+	if customer.ID == 0 && customer.PaymentTermsDays == 0 {
+		customer.PaymentTermsDays = DefaultPaymentTermsDays
+	}
+	return db.Save(customer).Error
+}
+
+// FindCustomer loads an organization's customer
+func FindCustomer(db *gorm.DB, orgID uint, id uint) (*models.Customer, error) {
+	var customer models.Customer
+	err := db.Where("id = ? AND organization_id = ?", id, orgID).First(&customer).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCustomerNotFound
+		}
+		return nil, err
+	}
+	return &customer, nil
+}
+
+// LockCustomer loads an organization's customer with a row lock held until
+// the transaction ends
+func LockCustomer(tx *gorm.DB, orgID uint, id uint) (*models.Customer, error) {
+	return FindCustomer(tx.Clauses(clause.Locking{Strength: "UPDATE"}), orgID, id)
+}
+
+// CustomerBalance is what a customer owes across their open invoices
+func CustomerBalance(db *gorm.DB, orgID uint, customerID uint) (models.Money, error) {
+	var balance models.Money
+	err := db.Model(&models.Invoice{}).
+		Where("organization_id = ? AND customer_id = ? AND status = ?", orgID, customerID, models.InvoiceOpen).
+		Select("COALESCE(SUM(amount - credited_amount - paid_amount), 0)").Scan(&balance).Error
+	return balance, err
+}
+
+// chargeAccount puts a payment on the receipt customer's account, on the
+// receipt's invoice. The invoice falls due on dueDate, or after the
+// customer's payment terms.
+func chargeAccount(tx *gorm.DB, receipt *models.Receipt, amount models.Money, dueDate *time.Time) error {
+	if receipt.CustomerID == 0 {
+		return ErrCustomerRequired
+	}
+	customer, err := LockCustomer(tx, receipt.OrganizationID, receipt.CustomerID)
+	if err != nil {
+		return err
+	}
+	if customer.CreditLimit > 0 {
+		owed, err := CustomerBalance(tx, receipt.OrganizationID, customer.ID)
+		if err != nil {
+			return err
+		}
+		if owed+amount > customer.CreditLimit {
+			return ErrCreditLimitExceeded
+		}
+	}
+
+	if receipt.Invoice != nil {
+		receipt.Invoice.Amount += amount
+		return tx.Model(receipt.Invoice).Update("amount", receipt.Invoice.Amount).Error
+	}
+	invoice := models.Invoice{
+		OrganizationID: receipt.OrganizationID,
+		CustomerID:     customer.ID,
+		ReceiptID:      receipt.ReceiptID,
+		Currency:       receipt.Currency,
+		Amount:         amount,
+		Status:         models.InvoiceOpen,
+		IssuedAt:       receipt.Date,
+		DueDate:        receipt.Date.AddDate(0, 0, customer.PaymentTermsDays),
+	}
+	if dueDate != nil {
+		invoice.DueDate = *dueDate
+	}
+	if err := tx.Create(&invoice).Error; err != nil {
+		return err
+	}
+	receipt.Invoice = &invoice
+	return nil
+}
+
+// creditAccount takes a return off the invoice of the receipt it was put on
+// account with. Only what is still owed is credited; the rest was already
+// paid, so it goes back through the invoice's payments, newest first. Cash
+// comes out of the till, card and mobile money refunds are left pending.
+func creditAccount(tx *gorm.DB, saleReturn *models.SaleReturn, amount models.Money, till *models.TillSession) ([]models.PaymentRefund, error) {
+	var invoice models.Invoice
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("organization_id = ? AND receipt_id = ?", saleReturn.OrganizationID, saleReturn.ReceiptID).First(&invoice).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, err
+	}
+
+	credit := amount
+	if owed := invoice.Balance(); credit > owed {
+		credit = owed
+	}
+	if credit > 0 {
+		invoice.CreditedAmount += credit
+		if err := settleInvoice(tx, &invoice, map[string]interface{}{"credited_amount": invoice.CreditedAmount}); err != nil {
+			return nil, err
+		}
+	}
+	left := amount - credit
+	if left <= 0 {
+		return nil, nil
+	}
+
+	var paid []models.InvoicePayment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("invoice_id = ? AND amount > refunded_amount", invoice.ID).
+		Order("id DESC").Find(&paid).Error; err != nil {
+		return nil, err
+	}
+	var refunds []models.PaymentRefund
+	for i := range paid {
+		if left == 0 {
+			break
+		}
+		payment := &paid[i]
+		share := payment.Amount - payment.RefundedAmount
+		if share > left {
+			share = left
+		}
+
+		refund := models.PaymentRefund{
+			OrganizationID:   invoice.OrganizationID,
+			InvoicePaymentID: payment.ID,
+			SaleReturnID:     saleReturn.ID,
+			Tender:           payment.Tender,
+			Amount:           share,
+			Status:           models.RefundSettled,
+			Currency:         invoice.Currency,
+		}
+		switch payment.Tender {
+		case models.TenderCash:
+			if till != nil {
+				refund.TillSessionID = till.ID
+			}
+		case models.TenderCard, models.TenderMobileMoney:
+			refund.Status, refund.ChargeRef = models.RefundPending, payment.ProviderRef
+		}
+		if err := tx.Create(&refund).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&models.InvoicePayment{}).Where("id = ?", payment.ID).
+			Update("refunded_amount", payment.RefundedAmount+share).Error; err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+		left -= share
+	}
+	return refunds, nil
+}
+
+// settleInvoice saves the changed columns of an invoice, marking it paid once
+// nothing is owed on it
+func settleInvoice(tx *gorm.DB, invoice *models.Invoice, changes map[string]interface{}) error {
+	if invoice.Status == models.InvoiceOpen && invoice.Balance() <= 0 {
+		now := time.Now()
+		invoice.Status, invoice.PaidAt = models.InvoicePaid, &now
+		changes["status"], changes["paid_at"] = invoice.Status, now
+	}
+	return tx.Model(invoice).Updates(changes).Error
+}
+
+// FindInvoice loads an organization's invoice
+func FindInvoice(db *gorm.DB, orgID uint, id uint) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := db.Where("id = ? AND organization_id = ?", id, orgID).First(&invoice).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// LockInvoice loads an organization's invoice with a row lock held until the
+// transaction ends
+func LockInvoice(tx *gorm.DB, orgID uint, id uint) (*models.Invoice, error) {
+	return FindInvoice(tx.Clauses(clause.Locking{Strength: "UPDATE"}), orgID, id)
+}
+
+// PayInvoice records a payment towards an invoice, in part or in full. Cash
+// goes into the open till session of the user taking it; card and mobile
+// money are charged through the payment provider.
+func PayInvoice(tx *gorm.DB, invoice *models.Invoice, input PaymentInput, userID uint) (*models.InvoicePayment, error) {
+	if invoice.Status != models.InvoiceOpen {
+		return nil, ErrInvoicePaid
+	}
+	switch input.Tender {
+	case models.TenderCash, models.TenderCard, models.TenderMobileMoney:
+	default:
+		return nil, ErrInvalidInvoicePayment
+	}
+	if input.Amount <= 0 {
+		return nil, ErrInvalidInvoicePayment
+	}
+	if input.Amount > invoice.Balance() {
+		return nil, ErrInvoiceOverpaid
+	}
+
+	payment := models.InvoicePayment{
+		OrganizationID: invoice.OrganizationID,
+		InvoiceID:      invoice.ID,
+		CustomerID:     invoice.CustomerID,
+		Tender:         input.Tender,
+		Amount:         input.Amount,
+		Reference:      input.Reference,
+		UserID:         userID,
+	}
+	if input.Tender == models.TenderCash {
+		till, err := openTillSession(tx.Clauses(clause.Locking{Strength: "SHARE"}), invoice.OrganizationID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if till != nil {
+			payment.TillSessionID = till.ID
+		}
+	} else {
+		provider := payments.Default()
+		ref, err := chargeInvoice(provider, invoice, input)
+		if err != nil {
+			return nil, err
+		}
+		payment.Provider, payment.ProviderRef = provider.Name(), ref
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		CancelInvoicePayment(invoice, &payment)
+		return nil, err
+	}
+
+	invoice.PaidAmount += payment.Amount
+	if err := settleInvoice(tx, invoice, map[string]interface{}{"paid_amount": invoice.PaidAmount}); err != nil {
+		CancelInvoicePayment(invoice, &payment)
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// chargeInvoice charges a card or mobile money payment towards an invoice. Each
+// attempt has its own idempotency key: one whose transaction failed has its
+// charge refunded, and a retry must not be handed that charge back.
+func chargeInvoice(provider payments.Provider, invoice *models.Invoice, input PaymentInput) (string, error) {
+	key, err := attemptKey(fmt.Sprintf("invoice-%d", invoice.ID))
+	if err != nil {
+		return "", err
+	}
+	ref, err := provider.Charge(context.Background(), payments.Charge{
+		Tender:         input.Tender,
+		Amount:         input.Amount,
+		Currency:       invoice.Currency,
+		Reference:      input.Reference,
+		IdempotencyKey: key,
+	})
+	if err != nil {
+		return "", &PaymentDeclinedError{Tender: input.Tender, Err: err}
+	}
+	return ref, nil
+}
+
+// CancelInvoicePayment refunds the provider charge of an invoice payment
+// whose transaction failed to commit
+func CancelInvoicePayment(invoice *models.Invoice, payment *models.InvoicePayment) {
+	if payment.ProviderRef == "" {
+		return
+	}
+	refundCharges(payments.Default(), []models.Payment{{
+		Amount:      payment.Amount,
+		Currency:    invoice.Currency,
+		ProviderRef: payment.ProviderRef,
+	}})
+}
+
+// CustomerAging is how long what a customer owes has been due
+type CustomerAging struct {
+	CustomerID uint         `json:"customer_id"`
+	Name       string       `json:"name"`
+	Current    models.Money `json:"current"`
+	Days1To30  models.Money `gorm:"column:days_1_30" json:"days_1_30"`
+	Days31To60 models.Money `gorm:"column:days_31_60" json:"days_31_60"`
+	Days61To90 models.Money `gorm:"column:days_61_90" json:"days_61_90"`
+	Over90     models.Money `gorm:"column:over_90" json:"over_90"`
+	Total      models.Money `json:"total"`
+}
+
+// AgedReceivables buckets the balances of open invoices by how many days
+// past their due date they are on the day asOf. Invoices not yet due are
+// current. orgID 0 covers every organization.
+func AgedReceivables(db *gorm.DB, orgID uint, asOf time.Time) ([]CustomerAging, CustomerAging, error) {
+	const balance = "invoices.amount - invoices.credited_amount - invoices.paid_amount"
+	const overdue = "DATEDIFF(?, invoices.due_date)"
+	bucket := func(condition string) string {
+		return "COALESCE(SUM(CASE WHEN " + condition + " THEN " + balance + " ELSE 0 END), 0)"
+	}
+	day := asOf.Format("2006-01-02")
+
+	query := db.Model(&models.Invoice{}).
+		Joins("JOIN customers ON customers.id = invoices.customer_id").
+		Where("invoices.status = ?", models.InvoiceOpen)
+	if orgID != 0 {
+		query = query.Where("invoices.organization_id = ?", orgID)
+	}
+
+	rows := []CustomerAging{}
+	err := query.Select("invoices.customer_id, MAX(customers.name) AS name, "+
+		bucket(overdue+" <= 0")+" AS `current`, "+
+		bucket(overdue+" BETWEEN 1 AND 30")+" AS days_1_30, "+
+		bucket(overdue+" BETWEEN 31 AND 60")+" AS days_31_60, "+
+		bucket(overdue+" BETWEEN 61 AND 90")+" AS days_61_90, "+
+		bucket(overdue+" > 90")+" AS over_90, "+
+		"COALESCE(SUM("+balance+"), 0) AS total", day, day, day, day, day).
+		Group("invoices.customer_id").Order("total DESC, invoices.customer_id").Scan(&rows).Error
+	if err != nil {
+		return nil, CustomerAging{}, err
+	}
+
+	var totals CustomerAging
+	for _, row := range rows {
+		totals.Current += row.Current
+		totals.Days1To30 += row.Days1To30
+		totals.Days31To60 += row.Days31To60
+		totals.Days61To90 += row.Days61To90
+		totals.Over90 += row.Over90
+		totals.Total += row.Total
+	}
+	return rows, totals, nil
+}
+
+// Kinds of statement line
+const (
+	StatementInvoice = "invoice"
+	StatementPayment = "payment"
+	StatementCredit  = "credit"
+	StatementRefund  = "refund"
+)
+
+// StatementLine is one movement on a customer's account. Invoices and money
+// refunded to the customer are debits; payments and returns are credits.
+type StatementLine struct {
+	Date      time.Time    `json:"date"`
+	Kind      string       `json:"kind"`
+	InvoiceID uint         `json:"invoice_id"`
+	Reference string       `json:"reference"`
+	Debit     models.Money `json:"debit"`
+	Credit    models.Money `json:"credit"`
+	Balance   models.Money `json:"balance"`
+}
+
+// Statement is a customer's account over a period
+type Statement struct {
+	Customer       models.Customer `json:"customer"`
+	OpeningBalance models.Money    `json:"opening_balance"`
+	Lines          []StatementLine `json:"lines"`
+	ClosingBalance models.Money    `json:"closing_balance"`
+}
+
+// CustomerStatement lists the movements on a customer's account in a period,
+// with the balance brought forward from before it
+func CustomerStatement(db *gorm.DB, customer *models.Customer, period SalesPeriod) (*Statement, error) {
+	var all []StatementLine
+	var invoices []models.Invoice
+	if err := db.Where("customer_id = ? AND issued_at < ?", customer.ID, period.To).Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+	for _, invoice := range invoices {
+		all = append(all, StatementLine{
+			Date:      invoice.IssuedAt,
+			Kind:      StatementInvoice,
+			InvoiceID: invoice.ID,
+			Reference: fmt.Sprintf("Receipt %d, due %s", invoice.ReceiptID, invoice.DueDate.Format("2006-01-02")),
+			Debit:     invoice.Amount,
+		})
+	}
+
+	var paid []models.InvoicePayment
+	if err := db.Where("customer_id = ? AND created_at < ?", customer.ID, period.To).Find(&paid).Error; err != nil {
+		return nil, err
+	}
+	for _, payment := range paid {
+		all = append(all, StatementLine{
+			Date:      payment.CreatedAt,
+			Kind:      StatementPayment,
+			InvoiceID: payment.InvoiceID,
+			Reference: strings.TrimSpace(payment.Tender + " " + payment.Reference),
+			Credit:    payment.Amount,
+		})
+	}
+
+	var credits []struct {
+		CreatedAt    time.Time
+		InvoiceID    uint
+		SaleReturnID uint
+		Amount       models.Money
+	}
+	if err := db.Table("payment_refunds").
+		Joins("JOIN payments ON payments.id = payment_refunds.payment_id").
+		Joins("JOIN invoices ON invoices.receipt_id = payments.receipt_id").
+		Where("invoices.customer_id = ? AND payment_refunds.tender = ? AND payment_refunds.created_at < ?",
+			customer.ID, models.TenderAccount, period.To).
+		Select("payment_refunds.created_at, invoices.id AS invoice_id, payment_refunds.sale_return_id, payment_refunds.amount").
+		Scan(&credits).Error; err != nil {
+		return nil, err
+	}
+	for _, credit := range credits {
+		all = append(all, StatementLine{
+			Date:      credit.CreatedAt,
+			Kind:      StatementCredit,
+			InvoiceID: credit.InvoiceID,
+			Reference: fmt.Sprintf("Return %d", credit.SaleReturnID),
+			Credit:    credit.Amount,
+		})
+	}
+
+	// Returns after an invoice was paid give the excess back through its payments
+	var refunds []struct {
+		CreatedAt    time.Time
+		InvoiceID    uint
+		SaleReturnID uint
+		Tender       string
+		Amount       models.Money
+	}
+	if err := db.Table("payment_refunds").
+		Joins("JOIN invoice_payments ON invoice_payments.id = payment_refunds.invoice_payment_id").
+		Where("invoice_payments.customer_id = ? AND payment_refunds.created_at < ?", customer.ID, period.To).
+		Select("payment_refunds.created_at, invoice_payments.invoice_id, payment_refunds.sale_return_id, " +
+			"payment_refunds.tender, payment_refunds.amount").
+		Scan(&refunds).Error; err != nil {
+		return nil, err
+	}
+	for _, refund := range refunds {
+		all = append(all, StatementLine{
+			Date:      refund.CreatedAt,
+			Kind:      StatementRefund,
+			InvoiceID: refund.InvoiceID,
+			Reference: fmt.Sprintf("Return %d, %s", refund.SaleReturnID, refund.Tender),
+			Debit:     refund.Amount,
+		})
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Date.Before(all[j].Date)
+	})
+	statement := &Statement{Customer: *customer, Lines: []StatementLine{}}
+	var balance models.Money
+	for _, line := range all {
+		balance += line.Debit - line.Credit
+		if line.Date.Before(period.From) {
+			statement.OpeningBalance = balance
+			continue
+		}
+		line.Balance = balance
+		statement.Lines = append(statement.Lines, line)
+	}
+	statement.ClosingBalance = balance
+	return statement, nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+
+	"stock/models"
+	"stock/payments"
+)
+
+func TestChargeInvoiceRetryAfterFailedCommit(t *testing.T) {
+	provider := payments.NewFakeProvider()
+	payments.SetDefault(provider)
+	defer payments.SetDefault(payments.NewFakeProvider())
+
+	invoice := &models.Invoice{ID: 5, Currency: "USD", Amount: money(t, "50")}
+	input := PaymentInput{Tender: models.TenderCard, Amount: money(t, "20"), Reference: "tok_visa"}
+
+	// The first attempt is charged, then refunded when its transaction fails
+	ref, err := chargeInvoice(provider, invoice, input)
+	if err != nil {
+		t.Fatalf("chargeInvoice: %v", err)
+	}
+	CancelInvoicePayment(invoice, &models.InvoicePayment{Amount: input.Amount, ProviderRef: ref})
+
+	// The retry finds the invoice unchanged and must be charged afresh
+	retried, err := chargeInvoice(provider, invoice, input)
+	if err != nil {
+		t.Fatalf("retried chargeInvoice: %v", err)
+	}
+	if retried == ref {
+		t.Fatalf("retry was handed the refunded charge %s", ref)
+	}
+
+	charges := provider.Charges()
+	if len(charges) != 2 {
+		t.Fatalf("provider took %d charges, want 2", len(charges))
+	}
+	if charges[0].Refunded != input.Amount {
+		t.Errorf("cancelled charge refunded %s, want %s", charges[0].Refunded, input.Amount)
+	}
+	if charges[1].Ref != retried || charges[1].Refunded != 0 {
+		t.Errorf("retried charge %+v, want %s and not refunded", charges[1], retried)
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"stock/models"
+	"stock/ratelimit"
+	"stock/utils"
+)
+
+var ErrAccountLocked = errors.New("account is temporarily locked")
+
+// Lockout policy: an account is locked for LockoutDuration after
+// MaxLoginFailures wrong passwords in a row
+var (
+	MaxLoginFailures = 5
+	LockoutDuration  = 15 * time.Minute
+)
+
+// Throttling of the authentication endpoints, per client IP and per account
+var (
+	AuthIPLimit       = 20
+	AuthIPWindow      = time.Minute
+	AuthAccountLimit  = 10
+	AuthAccountWindow = 15 * time.Minute
+)
+
+// ThrottleAccount counts an attempt against an account, named by the
+// username or email the client sent, whether or not the account exists
+func ThrottleAccount(action, identifier string) error {
+	key := "account:" + action + ":" + strings.ToLower(strings.TrimSpace(identifier))
+	return ratelimit.Check(key, AuthAccountLimit, AuthAccountWindow)
+}
+
+// accountLocked reports whether the user is inside a lockout
+func accountLocked(user *models.User) bool {
+	return user.LockedUntil != nil && time.Now().Before(*user.LockedUntil)
+}
+
+// recordLoginFailure counts a wrong password and locks the account once the
+// limit is reached. The count starts over after a lockout.
+func recordLoginFailure(db *gorm.DB, user *models.User) error {
+	if err := db.Model(&models.User{}).Where("id = ?", user.ID).
+		Update("failed_login_attempts", gorm.Expr("failed_login_attempts + 1")).Error; err != nil {
+		return err
+	}
+	if err := db.Select("failed_login_attempts").First(user, user.ID).Error; err != nil {
+		return err
+	}
+	if MaxLoginFailures <= 0 || user.FailedLoginAttempts < MaxLoginFailures {
+		return nil
+	}
+
+	lockedUntil := time.Now().Add(LockoutDuration)
+	if err := db.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          lockedUntil,
+	}).Error; err != nil {
+		return err
+	}
+	log.Printf("UserID %d locked until %s after %d failed logins", user.ID, lockedUntil.Format(time.RFC3339), MaxLoginFailures)
+	return nil
+}
+
+// clearLoginFailures resets the count after a successful login
+func clearLoginFailures(db *gorm.DB, user *models.User) error {
+	if user.FailedLoginAttempts == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	return UnlockUser(db, user.ID)
+}
+
+// CheckPassword confirms a signed-in user's password before a sensitive
+// change. A wrong password counts towards the lockout as it does at login,
+// and a locked account is refused.
+func CheckPassword(db *gorm.DB, user *models.User, password string) error {
+	if accountLocked(user) {
+		return ErrAccountLocked
+	}
+	if err := utils.CheckPasswordHash(password, user.Password); err != nil {
+		if err := recordLoginFailure(db, user); err != nil {
+			return err
+		}
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// CheckSecondFactor is VerifySecondFactor for a signed-in user making a
+// sensitive change: a wrong code counts towards the lockout, and a locked
+// account is refused.
+func CheckSecondFactor(db *gorm.DB, user *models.User, code, recoveryCode string) error {
+	if accountLocked(user) {
+		return ErrAccountLocked
+	}
+	err := VerifySecondFactor(db, user, code, recoveryCode)
+	if errors.Is(err, ErrMFAInvalidCode) {
+		if err := recordLoginFailure(db, user); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// UnlockUser lifts a lockout and clears the failure count
+func UnlockUser(db *gorm.DB, userID uint) error {
+	return db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error
+}
+
+// LockedUsers restricts a query to users inside a lockout
+func LockedUsers(db *gorm.DB) *gorm.DB {
+	return db.Where("locked_until > ?", time.Now())
+}
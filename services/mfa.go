@@ -0,0 +1,293 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"stock/models"
+	"stock/utils"
+)
+
+var (
+	ErrMFAChallengeInvalid = errors.New("two-factor challenge is invalid or expired")
+	ErrMFAInvalidCode      = errors.New("invalid two-factor code")
+	ErrMFANotStarted       = errors.New("two-factor enrollment has not been started")
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrMFARequired         = errors.New("two-factor authentication is required for this role")
+)
+
+const (
+	mfaChallengeTTL         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+	recoveryCodeCount       = 10
+)
+
+// MFAEnrollment is what an authenticator app needs to add the account
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// MFARequired reports whether the user's organization makes two-factor
+// authentication mandatory for the user's role
+func MFARequired(db *gorm.DB, user *models.User) (bool, error) {
+	var count int64
+	err := db.Model(&models.MFARequiredRole{}).
+		Where("organization_id = ? AND role_id = ?", user.OrganizationID, user.RoleID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// CreateMFAChallenge issues a short-lived token that stands in for the
+// password while the second factor is verified or enrolled
+func CreateMFAChallenge(db *gorm.DB, userID uint, purpose string) (string, error) {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	challenge := models.MFAChallenge{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	}
+	if err := db.Create(&challenge).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// LoadMFAChallenge returns an open challenge and the user it belongs to
+func LoadMFAChallenge(db *gorm.DB, token, purpose string) (*models.MFAChallenge, *models.User, error) {
+	var challenge models.MFAChallenge
+	if err := db.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&challenge).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrMFAChallengeInvalid
+		}
+		return nil, nil, err
+	}
+	if challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= mfaChallengeMaxAttempts {
+		return nil, nil, ErrMFAChallengeInvalid
+	}
+
+	var user models.User
+	if err := db.First(&user, challenge.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrMFAChallengeInvalid
+		}
+		return nil, nil, err
+	}
+	if !user.IsActive {
+		return nil, nil, ErrUserInactive
+	}
+	return &challenge, &user, nil
+}
+
+// CompleteMFAChallenge marks a challenge used. Each challenge signs in once.
+func CompleteMFAChallenge(db *gorm.DB, challenge *models.MFAChallenge) error {
+	result := db.Model(&models.MFAChallenge{}).Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMFAChallengeInvalid
+	}
+	return nil
+}
+
+// FailMFAChallenge counts a wrong code against the challenge
+func FailMFAChallenge(db *gorm.DB, challenge *models.MFAChallenge) error {
+	return db.Model(&models.MFAChallenge{}).Where("id = ?", challenge.ID).
+		Update("attempts", gorm.Expr("attempts + 1")).Error
+}
+
+// VerifySecondFactor accepts either a current TOTP code or an unused recovery
+// code. A TOTP code is refused if it, or a later one, has already been used.
+func VerifySecondFactor(db *gorm.DB, user *models.User, code, recoveryCode string) error {
+	if !user.TOTPEnabled {
+		return ErrMFANotEnabled
+	}
+
+	if recoveryCode != "" {
+		return useRecoveryCode(db, user.ID, recoveryCode)
+	}
+
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return ErrMFAInvalidCode
+	}
+	result := db.Model(&models.User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMFAInvalidCode
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+// BeginMFAEnrollment stores a new secret for the user. It only takes effect
+// once ConfirmMFAEnrollment sees a code generated from it.
+func BeginMFAEnrollment(db *gorm.DB, user *models.User) (*MFAEnrollment, error) {
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Model(user).Update("totp_secret", secret).Error; err != nil {
+		return nil, err
+	}
+
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "Stock"
+	}
+	account := user.Email
+	if account == "" {
+		account = user.Username
+	}
+	return &MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(secret, issuer, account),
+	}, nil
+}
+
+// ConfirmMFAEnrollment enables two-factor authentication once the user proves
+// their app works, and returns a fresh set of recovery codes
+func ConfirmMFAEnrollment(tx *gorm.DB, user *models.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotStarted
+	}
+
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrMFAInvalidCode
+	}
+	if err := tx.Model(user).Updates(map[string]interface{}{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return RegenerateRecoveryCodes(tx, user.ID)
+}
+
+// DisableMFA turns two-factor authentication off unless the user's role requires it
+func DisableMFA(tx *gorm.DB, user *models.User) error {
+	if !user.TOTPEnabled {
+		return ErrMFANotEnabled
+	}
+	required, err := MFARequired(tx, user)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequired
+	}
+
+	if err := tx.Model(user).Updates(map[string]interface{}{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes. The codes are
+// only ever returned here; the database keeps bcrypt hashes.
+func RegenerateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := utils.RandomToken(5)
+		if err != nil {
+			return nil, err
+		}
+		code := raw[:5] + "-" + raw[5:]
+		hash, err := utils.HashPassword(code)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: hash}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func useRecoveryCode(db *gorm.DB, userID uint, code string) error {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	var stored []models.RecoveryCode
+	if err := db.Where("user_id = ? AND used_at IS NULL", userID).Find(&stored).Error; err != nil {
+		return err
+	}
+	for _, candidate := range stored {
+		if utils.CheckPasswordHash(code, candidate.CodeHash) != nil {
+			continue
+		}
+		result := db.Model(&models.RecoveryCode{}).Where("id = ? AND used_at IS NULL", candidate.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			break
+		}
+		return nil
+	}
+	return ErrMFAInvalidCode
+}
+
+// SetMFARequiredRoles replaces the roles an organization requires two-factor
+// authentication for. Roles must be defaults or the organization's own.
+func SetMFARequiredRoles(tx *gorm.DB, orgID uint, roleIDs []uint) error {
+	if len(roleIDs) > 0 {
+		var count int64
+		if err := tx.Model(&models.Role{}).
+			Where("id IN ? AND (is_default = ? OR organization_id = ?)", roleIDs, true, orgID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if int(count) != len(uniqueUints(roleIDs)) {
+			return ErrRoleNotFound
+		}
+	}
+
+	if err := tx.Where("organization_id = ?", orgID).Delete(&models.MFARequiredRole{}).Error; err != nil {
+		return err
+	}
+	for roleID := range uniqueUints(roleIDs) {
+		if err := tx.Create(&models.MFARequiredRole{OrganizationID: orgID, RoleID: roleID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uniqueUints(values []uint) map[uint]bool {
+	seen := make(map[uint]bool, len(values))
+	for _, v := range values {
+		seen[v] = true
+	}
+	return seen
+}
@@ -0,0 +1,420 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/models"
+	"stock/payments"
+	"stock/utils"
+)
+
+var (
+	ErrInvalidPayment          = errors.New("payments need a tender of cash, card, mobile_money, store_credit or account and an amount above zero")
+	ErrOverpaid                = errors.New("only cash can be paid beyond the total")
+	ErrStoreCreditNotFound     = errors.New("store credit not found")
+	ErrStoreCreditInsufficient = errors.New("store credit balance is too low")
+)
+
+// PaymentInput is a tender the customer hands over at checkout. Reference is
+// the card token or mobile money number for the provider, or the store
+// credit code. Account payments put the amount on the customer's account.
+type PaymentInput struct {
+	Tender    string       `json:"tender"`
+	Amount    models.Money `json:"amount"`
+	Reference string       `json:"reference,omitempty"`
+}
+
+// PaymentShortError is returned when the payments do not cover the total
+type PaymentShortError struct {
+	Total models.Money
+	Paid  models.Money
+}
+
+func (e *PaymentShortError) Error() string {
+	return fmt.Sprintf("payments of %s do not cover the total of %s", e.Paid, e.Total)
+}
+
+// PaymentDeclinedError is returned when the provider refuses a payment
+type PaymentDeclinedError struct {
+	Tender string
+	Err    error
+}
+
+func (e *PaymentDeclinedError) Error() string {
+	return fmt.Sprintf("%s payment failed: %v", e.Tender, e.Err)
+}
+
+func (e *PaymentDeclinedError) Unwrap() error {
+	return e.Err
+}
+
+// takePayments pays for a receipt. The payments must cover its total, and
+// only cash may go over it, with the difference handed back as change. Card
+// and mobile money are charged through the payment provider; if one fails,
+// those already charged are refunded. Account payments are invoiced to the
+// receipt's customer, due on dueDate if it is set.
+func takePayments(tx *gorm.DB, receipt *models.Receipt, inputs []PaymentInput, dueDate *time.Time) error {
+	taken, err := splitPayments(receipt, inputs)
+	if err != nil {
+		return err
+	}
+
+	provider := payments.Default()
+	charged, err := chargePayments(tx, provider, receipt, taken, dueDate)
+	if err != nil {
+		return err
+	}
+
+	for i := range taken {
+		if err := tx.Create(&taken[i]).Error; err != nil {
+			refundCharges(provider, charged)
+			return err
+		}
+	}
+	receipt.Payments = taken
+	return nil
+}
+
+// splitPayments checks the tenders handed over against the receipt's total
+// and turns them into payments, setting the receipt's change due
+func splitPayments(receipt *models.Receipt, inputs []PaymentInput) ([]models.Payment, error) {
+	var paid, cash models.Money
+	for _, input := range inputs {
+		switch input.Tender {
+		case models.TenderCash, models.TenderCard, models.TenderMobileMoney, models.TenderStoreCredit, models.TenderAccount:
+		default:
+			return nil, ErrInvalidPayment
+		}
+		if input.Amount <= 0 {
+			return nil, ErrInvalidPayment
+		}
+		paid += input.Amount
+		if input.Tender == models.TenderCash {
+			cash += input.Amount
+		}
+	}
+	if paid < receipt.TotalAmount {
+		return nil, &PaymentShortError{Total: receipt.TotalAmount, Paid: paid}
+	}
+	change := paid - receipt.TotalAmount
+	if change > cash {
+		return nil, ErrOverpaid
+	}
+	receipt.ChangeDue = change
+
+	// Change comes out of the last cash handed over first
+	taken := make([]models.Payment, len(inputs))
+	for i := len(inputs) - 1; i >= 0; i-- {
+		input := inputs[i]
+		taken[i] = models.Payment{
+			OrganizationID: receipt.OrganizationID,
+			ReceiptID:      receipt.ReceiptID,
+			Tender:         input.Tender,
+			Amount:         input.Amount,
+			Tendered:       input.Amount,
+			Currency:       receipt.Currency,
+			Reference:      input.Reference,
+			TillSessionID:  receipt.TillSessionID,
+		}
+		if input.Tender == models.TenderCash && change > 0 {
+			share := change
+			if share > input.Amount {
+				share = input.Amount
+			}
+			taken[i].Change = share
+			taken[i].Amount -= share
+			change -= share
+		}
+	}
+	return taken, nil
+}
+
+// chargePayments takes the non-cash payments: store credit and account
+// payments in the transaction, card and mobile money through the provider.
+// On failure every provider charge already taken is refunded; on success
+// they are returned so a later failure can refund them too. Provider charges
+// are keyed by the receipt's idempotency key and this attempt: a retry of a
+// checkout whose charges were refunded must be charged afresh.
+func chargePayments(tx *gorm.DB, provider payments.Provider, receipt *models.Receipt, taken []models.Payment, dueDate *time.Time) ([]models.Payment, error) {
+	attempt, err := attemptKey("checkout-" + receipt.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	var charged []models.Payment
+	for i := range taken {
+		payment := &taken[i]
+		if payment.Amount == 0 {
+			continue
+		}
+		switch payment.Tender {
+		case models.TenderStoreCredit:
+			if err := spendStoreCredit(tx, payment); err != nil {
+				refundCharges(provider, charged)
+				return nil, err
+			}
+		case models.TenderAccount:
+			if err := chargeAccount(tx, receipt, payment.Amount, dueDate); err != nil {
+				refundCharges(provider, charged)
+				return nil, err
+			}
+		case models.TenderCard, models.TenderMobileMoney:
+			ref, err := provider.Charge(context.Background(), payments.Charge{
+				Tender:         payment.Tender,
+				Amount:         payment.Amount,
+				Currency:       payment.Currency,
+				Reference:      payment.Reference,
+				IdempotencyKey: fmt.Sprintf("%s-%d", attempt, i),
+			})
+			if err != nil {
+				refundCharges(provider, charged)
+				return nil, &PaymentDeclinedError{Tender: payment.Tender, Err: err}
+			}
+			payment.Provider, payment.ProviderRef = provider.Name(), ref
+			charged = append(charged, *payment)
+		}
+	}
+	return charged, nil
+}
+
+// attemptKey returns a provider idempotency key unique to one attempt at a
+// payment, so a charge refunded after a failed attempt is never reused
+func attemptKey(prefix string) (string, error) {
+	nonce, err := utils.RandomToken(8)
+	if err != nil {
+		return "", err
+	}
+	return prefix + "-" + nonce, nil
+}
+
+// CancelPayments refunds the provider charges of a checkout whose transaction
+// failed to commit. Cash and store credit need nothing: they were never
+// handed over or roll back with the transaction.
+func CancelPayments(receipt *models.Receipt) {
+	var charged []models.Payment
+	for _, payment := range receipt.Payments {
+		if payment.ProviderRef != "" {
+			charged = append(charged, payment)
+		}
+	}
+	refundCharges(payments.Default(), charged)
+}
+
+// refundCharges gives back provider charges of a checkout that did not go through
+func refundCharges(provider payments.Provider, charged []models.Payment) {
+	for _, payment := range charged {
+		if _, err := provider.Refund(context.Background(), payments.Refund{
+			ChargeRef:      payment.ProviderRef,
+			Amount:         payment.Amount,
+			Currency:       payment.Currency,
+			IdempotencyKey: "cancel-" + payment.ProviderRef,
+		}); err != nil {
+			log.Printf("Failed to refund charge %s of a cancelled checkout: %v", payment.ProviderRef, err)
+		}
+	}
+}
+
+// refundPayments gives a sale return's amount back through the payments of
+// its receipt, each up to what it has left to refund. What was put on account
+// is credited first, then the other payments newest first. Sales recorded
+// without payments have nothing to refund through. Card and mobile money
+// refunds are only recorded as pending here: the provider is not called until
+// the return has committed, with SettleRefunds.
+func refundPayments(tx *gorm.DB, saleReturn *models.SaleReturn) ([]models.PaymentRefund, error) {
+	if saleReturn.ReceiptID == 0 || saleReturn.Amount <= 0 {
+		return nil, nil
+	}
+	var paid []models.Payment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("organization_id = ? AND receipt_id = ? AND amount > refunded_amount", saleReturn.OrganizationID, saleReturn.ReceiptID).
+		Order("tender = '" + models.TenderAccount + "' DESC, id DESC").Find(&paid).Error; err != nil {
+		return nil, err
+	}
+
+	// Cash comes out of the till of whoever takes the return
+	till, err := openTillSession(tx.Clauses(clause.Locking{Strength: "SHARE"}), saleReturn.OrganizationID, saleReturn.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var refunds []models.PaymentRefund
+	left := saleReturn.Amount
+	for i := range paid {
+		if left == 0 {
+			break
+		}
+		payment := &paid[i]
+		amount := payment.Amount - payment.RefundedAmount
+		if amount > left {
+			amount = left
+		}
+
+		refund := models.PaymentRefund{
+			OrganizationID: payment.OrganizationID,
+			PaymentID:      payment.ID,
+			SaleReturnID:   saleReturn.ID,
+			Tender:         payment.Tender,
+			Amount:         amount,
+			Status:         models.RefundSettled,
+			Currency:       payment.Currency,
+		}
+		switch payment.Tender {
+		case models.TenderCash:
+			if till != nil {
+				refund.TillSessionID = till.ID
+			}
+		case models.TenderStoreCredit:
+			if err := creditStoreCredit(tx, payment.OrganizationID, payment.Reference, amount); err != nil {
+				return nil, err
+			}
+		case models.TenderAccount:
+			paidBack, err := creditAccount(tx, saleReturn, amount, till)
+			if err != nil {
+				return nil, err
+			}
+			refunds = append(refunds, paidBack...)
+		case models.TenderCard, models.TenderMobileMoney:
+			refund.Status, refund.ChargeRef = models.RefundPending, payment.ProviderRef
+		}
+		if err := tx.Create(&refund).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&models.Payment{}).Where("id = ?", payment.ID).
+			Update("refunded_amount", payment.RefundedAmount+amount).Error; err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+		left -= amount
+	}
+	return refunds, nil
+}
+
+// SettleRefunds takes the pending refunds of a committed return through the
+// payment provider. A refund the provider declines is marked failed for a
+// manager to follow up; one that could not reach it stays pending and is
+// retried by SettlePendingRefunds. The refunds are updated in place.
+func SettleRefunds(db *gorm.DB, refunds []models.PaymentRefund) {
+	provider := payments.Default()
+	for i := range refunds {
+		refund := &refunds[i]
+		if refund.Status != models.RefundPending {
+			continue
+		}
+		err := settleRefund(provider, refund)
+		if err != nil {
+			log.Printf("Failed to refund %s of %s on charge %s: %v", refund.Amount, refund.Tender, refund.ChargeRef, err)
+			if refund.Status == models.RefundPending {
+				continue
+			}
+		}
+		if err := db.Model(&models.PaymentRefund{}).Where("id = ?", refund.ID).Updates(map[string]interface{}{
+			"status":       refund.Status,
+			"provider_ref": refund.ProviderRef,
+		}).Error; err != nil {
+			log.Printf("Failed to record refund ID %d as %s: %v", refund.ID, refund.Status, err)
+		}
+	}
+}
+
+// settleRefund asks the provider for a pending refund. The idempotency key is
+// the refund's own, so a retry cannot pay it out twice.
+func settleRefund(provider payments.Provider, refund *models.PaymentRefund) error {
+	ref, err := provider.Refund(context.Background(), payments.Refund{
+		ChargeRef:      refund.ChargeRef,
+		Amount:         refund.Amount,
+		Currency:       refund.Currency,
+		IdempotencyKey: fmt.Sprintf("refund-%d", refund.ID),
+	})
+	if err != nil {
+		if errors.Is(err, payments.ErrDeclined) {
+			refund.Status = models.RefundFailed
+		}
+		return err
+	}
+	refund.Status, refund.ProviderRef = models.RefundSettled, ref
+	return nil
+}
+
+// SettlePendingRefunds retries every refund left pending, such as those of a
+// return that committed just before the process stopped
+func SettlePendingRefunds(db *gorm.DB) {
+	var refunds []models.PaymentRefund
+	if err := db.Where("status = ?", models.RefundPending).Order("id").Find(&refunds).Error; err != nil {
+		log.Printf("Error querying pending refunds: %v", err)
+		return
+	}
+	if len(refunds) > 0 {
+		log.Printf("Settling %d pending refunds", len(refunds))
+		SettleRefunds(db, refunds)
+	}
+}
+
+// IssueStoreCredit creates a store credit with a new code and an opening balance
+func IssueStoreCredit(db *gorm.DB, orgID uint, amount models.Money) (*models.StoreCredit, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidPayment
+	}
+	currency, err := OrganizationCurrency(db, orgID)
+	if err != nil {
+		return nil, err
+	}
+	code, err := utils.RandomToken(8)
+	if err != nil {
+		return nil, err
+	}
+	credit := models.StoreCredit{
+		OrganizationID: orgID,
+		Code:           strings.ToUpper(code),
+		Balance:        amount.Round(currency),
+		Currency:       currency,
+	}
+	if err := db.Create(&credit).Error; err != nil {
+		return nil, err
+	}
+	return &credit, nil
+}
+
+// FindStoreCredit looks up an organization's store credit by code
+func FindStoreCredit(db *gorm.DB, orgID uint, code string) (*models.StoreCredit, error) {
+	var credit models.StoreCredit
+	err := db.Where("organization_id = ? AND code = ?", orgID, strings.ToUpper(strings.TrimSpace(code))).First(&credit).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrStoreCreditNotFound
+		}
+		return nil, err
+	}
+	return &credit, nil
+}
+
+func lockStoreCredit(tx *gorm.DB, orgID uint, code string) (*models.StoreCredit, error) {
+	return FindStoreCredit(tx.Clauses(clause.Locking{Strength: "UPDATE"}), orgID, code)
+}
+
+// spendStoreCredit takes a payment off the store credit in its reference
+func spendStoreCredit(tx *gorm.DB, payment *models.Payment) error {
+	credit, err := lockStoreCredit(tx, payment.OrganizationID, payment.Reference)
+	if err != nil {
+		return err
+	}
+	if credit.Currency != payment.Currency || credit.Balance < payment.Amount {
+		return ErrStoreCreditInsufficient
+	}
+	payment.Reference = credit.Code
+	return tx.Model(credit).Update("balance", credit.Balance-payment.Amount).Error
+}
+
+// creditStoreCredit puts a refund back onto a store credit
+func creditStoreCredit(tx *gorm.DB, orgID uint, code string, amount models.Money) error {
+	credit, err := lockStoreCredit(tx, orgID, code)
+	if err != nil {
+		return err
+	}
+	return tx.Model(credit).Update("balance", credit.Balance+amount).Error
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"stock/models"
+	"stock/payments"
+)
+
+func money(t *testing.T, s string) models.Money {
+	t.Helper()
+	m, err := models.ParseMoney(s)
+	if err != nil {
+		t.Fatalf("ParseMoney(%q): %v", s, err)
+	}
+	return m
+}
+
+func TestSplitPayments(t *testing.T) {
+	receipt := &models.Receipt{ReceiptID: 7, OrganizationID: 1, Currency: "USD", TotalAmount: money(t, "30")}
+	taken, err := splitPayments(receipt, []PaymentInput{
+		{Tender: models.TenderCard, Amount: money(t, "12.5"), Reference: "tok_visa"},
+		{Tender: models.TenderMobileMoney, Amount: money(t, "7.5"), Reference: "0700000000"},
+		{Tender: models.TenderCash, Amount: money(t, "10")},
+	})
+	if err != nil {
+		t.Fatalf("splitPayments: %v", err)
+	}
+	if len(taken) != 3 {
+		t.Fatalf("got %d payments, want 3", len(taken))
+	}
+	var total models.Money
+	for _, payment := range taken {
+		if payment.ReceiptID != 7 || payment.OrganizationID != 1 || payment.Currency != "USD" {
+			t.Errorf("payment %+v not tied to the receipt", payment)
+		}
+		total += payment.Amount
+	}
+	if total != receipt.TotalAmount || receipt.ChangeDue != 0 {
+		t.Errorf("paid %s with %s change, want %s and none", total, receipt.ChangeDue, receipt.TotalAmount)
+	}
+}
+
+func TestSplitPaymentsChangeFromLastCash(t *testing.T) {
+	receipt := &models.Receipt{TotalAmount: money(t, "26")}
+	taken, err := splitPayments(receipt, []PaymentInput{
+		{Tender: models.TenderCash, Amount: money(t, "20")},
+		{Tender: models.TenderCard, Amount: money(t, "5")},
+		{Tender: models.TenderCash, Amount: money(t, "3")},
+	})
+	if err != nil {
+		t.Fatalf("splitPayments: %v", err)
+	}
+	if receipt.ChangeDue != money(t, "2") {
+		t.Errorf("change due %s, want 2", receipt.ChangeDue)
+	}
+	if taken[2].Change != money(t, "2") || taken[2].Amount != money(t, "1") || taken[2].Tendered != money(t, "3") {
+		t.Errorf("last cash %+v, want 2 change out of 3", taken[2])
+	}
+	if taken[0].Change != 0 || taken[0].Amount != money(t, "20") {
+		t.Errorf("first cash %+v, want no change", taken[0])
+	}
+}
+
+func TestSplitPaymentsRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []PaymentInput
+		want   error
+	}{
+		{"unknown tender", []PaymentInput{{Tender: "cheque", Amount: money(t, "10")}}, ErrInvalidPayment},
+		{"zero amount", []PaymentInput{{Tender: models.TenderCash, Amount: 0}}, ErrInvalidPayment},
+		{"card over total", []PaymentInput{{Tender: models.TenderCard, Amount: money(t, "11")}}, ErrOverpaid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := splitPayments(&models.Receipt{TotalAmount: money(t, "10")}, tt.inputs)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	_, err := splitPayments(&models.Receipt{TotalAmount: money(t, "10")}, []PaymentInput{
+		{Tender: models.TenderCash, Amount: money(t, "4")},
+	})
+	var short *PaymentShortError
+	if !errors.As(err, &short) || short.Paid != money(t, "4") {
+		t.Errorf("got %v, want payments short by 6", err)
+	}
+}
+
+func TestChargePayments(t *testing.T) {
+	provider := payments.NewFakeProvider()
+	receipt := &models.Receipt{ReceiptID: 1, TotalAmount: money(t, "20")}
+	taken, err := splitPayments(receipt, []PaymentInput{
+		{Tender: models.TenderCard, Amount: money(t, "15"), Reference: "tok_visa"},
+		{Tender: models.TenderMobileMoney, Amount: money(t, "5"), Reference: "0700000000"},
+	})
+	if err != nil {
+		t.Fatalf("splitPayments: %v", err)
+	}
+
+	charged, err := chargePayments(nil, provider, receipt, taken, nil)
+	if err != nil {
+		t.Fatalf("chargePayments: %v", err)
+	}
+	if len(charged) != 2 || len(provider.Charges()) != 2 {
+		t.Fatalf("charged %d payments, provider took %d, want 2", len(charged), len(provider.Charges()))
+	}
+	for _, payment := range taken {
+		if payment.Provider != "fake" || payment.ProviderRef == "" {
+			t.Errorf("payment %+v has no provider reference", payment)
+		}
+	}
+}
+
+func TestChargeRetriedCheckout(t *testing.T) {
+	provider := payments.NewFakeProvider()
+	payments.SetDefault(provider)
+	defer payments.SetDefault(payments.NewFakeProvider())
+
+	// A retry is a new checkout with a new receipt ID and the client's same key
+	first := &models.Receipt{ReceiptID: 8, TotalAmount: money(t, "20"), IdempotencyKey: "client-key-1"}
+	taken, err := splitPayments(first, []PaymentInput{{Tender: models.TenderCard, Amount: money(t, "20"), Reference: "tok_visa"}})
+	if err != nil {
+		t.Fatalf("splitPayments: %v", err)
+	}
+	if _, err := chargePayments(nil, provider, first, taken, nil); err != nil {
+		t.Fatalf("chargePayments: %v", err)
+	}
+	// Its transaction fails to commit, so the charge is refunded
+	first.Payments = taken
+	CancelPayments(first)
+
+	retry := &models.Receipt{ReceiptID: 9, TotalAmount: money(t, "20"), IdempotencyKey: "client-key-1"}
+	retaken, err := splitPayments(retry, []PaymentInput{{Tender: models.TenderCard, Amount: money(t, "20"), Reference: "tok_visa"}})
+	if err != nil {
+		t.Fatalf("splitPayments: %v", err)
+	}
+	if _, err := chargePayments(nil, provider, retry, retaken, nil); err != nil {
+		t.Fatalf("retried chargePayments: %v", err)
+	}
+	if retaken[0].ProviderRef == taken[0].ProviderRef {
+		t.Fatalf("retry was handed the refunded charge %s", taken[0].ProviderRef)
+	}
+
+	charges := provider.Charges()
+	if len(charges) != 2 {
+		t.Fatalf("provider took %d charges, want 2", len(charges))
+	}
+	if charges[0].Refunded != charges[0].Charge.Amount || charges[1].Refunded != 0 {
+		t.Errorf("charges %+v, want the first refunded and the retry kept", charges)
+	}
+}
+
+func TestChargePaymentsDeclineRefundsEarlierCharges(t *testing.T) {
+	provider := payments.NewFakeProvider()
+	receipt := &models.Receipt{ReceiptID: 2, TotalAmount: money(t, "20")}
+	taken, err := splitPayments(receipt, []PaymentInput{
+		{Tender: models.TenderCard, Amount: money(t, "15"), Reference: "tok_visa"},
+		{Tender: models.TenderMobileMoney, Amount: money(t, "5"), Reference: "decline_0700000000"},
+	})
+	if err != nil {
+		t.Fatalf("splitPayments: %v", err)
+	}
+
+	_, err = chargePayments(nil, provider, receipt, taken, nil)
+	var declined *PaymentDeclinedError
+	if !errors.As(err, &declined) || declined.Tender != models.TenderMobileMoney || !errors.Is(err, payments.ErrDeclined) {
+		t.Fatalf("got %v, want a declined mobile money payment", err)
+	}
+
+	charges := provider.Charges()
+	if len(charges) != 1 {
+		t.Fatalf("provider took %d charges, want 1", len(charges))
+	}
+	if charges[0].Refunded != charges[0].Charge.Amount {
+		t.Errorf("card charge refunded %s of %s, want all of it", charges[0].Refunded, charges[0].Charge.Amount)
+	}
+}
+
+func TestSettleRefund(t *testing.T) {
+	provider := payments.NewFakeProvider()
+	receipt := &models.Receipt{ReceiptID: 3, TotalAmount: money(t, "40")}
+	taken, err := splitPayments(receipt, []PaymentInput{{Tender: models.TenderCard, Amount: money(t, "40"), Reference: "tok_visa"}})
+	if err != nil {
+		t.Fatalf("splitPayments: %v", err)
+	}
+	if _, err := chargePayments(nil, provider, receipt, taken, nil); err != nil {
+		t.Fatalf("chargePayments: %v", err)
+	}
+
+	refund := models.PaymentRefund{
+		ID:        1,
+		Tender:    models.TenderCard,
+		Amount:    money(t, "25"),
+		Status:    models.RefundPending,
+		ChargeRef: taken[0].ProviderRef,
+	}
+	if err := settleRefund(provider, &refund); err != nil {
+		t.Fatalf("settleRefund: %v", err)
+	}
+	if refund.Status != models.RefundSettled || refund.ProviderRef == "" {
+		t.Errorf("refund %+v, want settled with a provider reference", refund)
+	}
+
+	// Settling the same refund again is not paid out twice
+	again := refund
+	again.Status = models.RefundPending
+	if err := settleRefund(provider, &again); err != nil || again.ProviderRef != refund.ProviderRef {
+		t.Errorf("retried refund %+v (%v), want the original result", again, err)
+	}
+	if refunded := provider.Charges()[0].Refunded; refunded != money(t, "25") {
+		t.Errorf("charge refunded %s, want 25", refunded)
+	}
+
+	// More than is left on the charge is declined and marked failed
+	over := models.PaymentRefund{ID: 2, Amount: money(t, "20"), Status: models.RefundPending, ChargeRef: taken[0].ProviderRef}
+	if err := settleRefund(provider, &over); !errors.Is(err, payments.ErrDeclined) || over.Status != models.RefundFailed {
+		t.Errorf("over refund %+v (%v), want declined and failed", over, err)
+	}
+}
+
+func TestSettleRefundAfterRestart(t *testing.T) {
+	before := payments.NewFakeProvider()
+	receipt := &models.Receipt{ReceiptID: 4, TotalAmount: money(t, "10")}
+	taken, err := splitPayments(receipt, []PaymentInput{{Tender: models.TenderCard, Amount: money(t, "10"), Reference: "tok_visa"}})
+	if err != nil {
+		t.Fatalf("splitPayments: %v", err)
+	}
+	if _, err := chargePayments(nil, before, receipt, taken, nil); err != nil {
+		t.Fatalf("chargePayments: %v", err)
+	}
+
+	// A pending refund is retried by a new process that never saw the charge
+	after := payments.NewFakeProvider()
+	refund := models.PaymentRefund{ID: 1, Amount: money(t, "10"), Status: models.RefundPending, ChargeRef: taken[0].ProviderRef}
+	if err := settleRefund(after, &refund); err != nil || refund.Status != models.RefundSettled {
+		t.Errorf("refund %+v (%v), want settled", refund, err)
+	}
+
+	unknown := models.PaymentRefund{ID: 2, Amount: money(t, "10"), Status: models.RefundPending, ChargeRef: "ch_other"}
+	if err := settleRefund(after, &unknown); !errors.Is(err, payments.ErrDeclined) || unknown.Status != models.RefundFailed {
+		t.Errorf("refund %+v (%v), want declined and failed", unknown, err)
+	}
+}
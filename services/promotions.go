@@ -0,0 +1,235 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/models"
+	"stock/utils"
+)
+
+var (
+	ErrPromotionNotFound = errors.New("promotion not found")
+	ErrInvalidPromotion  = errors.New("promotion needs a name, a kind of percent, fixed or buy_x_get_y, and a value or quantities that fit it")
+	ErrDuplicateCoupon   = errors.New("coupon code is already in use")
+	ErrInvalidCoupon     = errors.New("coupon code is not valid")
+	ErrInvalidPrice      = errors.New("price must not be negative")
+	ErrConflictingPrices = errors.New("lines of the same product must have the same price")
+	ErrApprovalRequired  = errors.New("price override needs a manager's approval")
+	ErrApprovalInvalid   = errors.New("price approval is invalid, expired or does not cover this price")
+)
+
+// PriceOverrideApprovalPercent is how far below the list price, in percent, a
+// cashier may set a price without a manager's approval
+var PriceOverrideApprovalPercent = models.Percent(10 * models.MoneyScale)
+
+// PriceApprovalTTL is how long a manager's price approval can be used for
+var PriceApprovalTTL = 15 * time.Minute
+
+// LockPromotion loads an organization's promotion with a row lock held until
+// the transaction ends
+func LockPromotion(tx *gorm.DB, orgID uint, id uint) (*models.Promotion, error) {
+	var promotion models.Promotion
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND organization_id = ?", id, orgID).First(&promotion).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPromotionNotFound
+		}
+		return nil, err
+	}
+	return &promotion, nil
+}
+
+// SavePromotion validates and creates or updates a promotion. Coupon codes
+// are matched without regard to case and are unique in an organization.
+func SavePromotion(db *gorm.DB, promotion *models.Promotion) error {
+	promotion.Name = strings.TrimSpace(promotion.Name)
+	promotion.CouponCode = strings.ToUpper(strings.TrimSpace(promotion.CouponCode))
+
+	valid := promotion.Name != ""
+	switch promotion.Kind {
+	case models.PromotionPercent:
+		valid = valid && promotion.Value > 0 && promotion.Value <= 100*models.MoneyScale
+	case models.PromotionFixed:
+		valid = valid && promotion.Value > 0
+	case models.PromotionBuyXGetY:
+		valid = valid && promotion.BuyQuantity > 0 && promotion.GetQuantity > 0
+	default:
+		valid = false
+	}
+	if promotion.StartsAt != nil && promotion.EndsAt != nil && !promotion.EndsAt.After(*promotion.StartsAt) {
+		valid = false
+	}
+	if !valid {
+		return ErrInvalidPromotion
+	}
+
+	if promotion.CouponCode != "" {
+		var clashes int64
+		if err := db.Model(&models.Promotion{}).
+			Where("organization_id = ? AND coupon_code = ? AND id <> ?", promotion.OrganizationID, promotion.CouponCode, promotion.ID).
+			Count(&clashes).Error; err != nil {
+			return err
+		}
+		if clashes > 0 {
+			return ErrDuplicateCoupon
+		}
+	}
+	return db.Save(promotion).Error
+}
+
+// promotionSet holds the promotions that apply to one checkout: those running
+// at the time of the sale, and coupon promotions only if their code was given
+type promotionSet struct {
+	promotions []models.Promotion
+}
+
+// loadPromotions finds the promotions running at now. Every coupon code must
+// belong to one of them.
+func loadPromotions(tx *gorm.DB, orgID uint, now time.Time, coupons []string) (*promotionSet, error) {
+	var running []models.Promotion
+	if err := tx.Where("organization_id = ? AND is_active = ?", orgID, true).
+		Where("(starts_at IS NULL OR starts_at <= ?) AND (ends_at IS NULL OR ends_at > ?)", now, now).
+		Order("id").Find(&running).Error; err != nil {
+		return nil, err
+	}
+
+	given := make(map[string]bool, len(coupons))
+	for _, code := range coupons {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			given[code] = false
+		}
+	}
+	set := &promotionSet{}
+	for _, promotion := range running {
+		if promotion.CouponCode != "" {
+			if _, ok := given[promotion.CouponCode]; !ok {
+				continue
+			}
+			given[promotion.CouponCode] = true
+		}
+		set.promotions = append(set.promotions, promotion)
+	}
+	for _, found := range given {
+		if !found {
+			return nil, ErrInvalidCoupon
+		}
+	}
+	return set, nil
+}
+
+// best picks the promotion that takes the most off a line of quantity units
+// at price. Ties go to the oldest promotion, so the same basket always gets
+// the same discount. It returns nil when no promotion covers the product.
+func (s *promotionSet) best(product *models.Product, price models.Money, quantity int, currency string) (*models.Promotion, models.Money) {
+	var best *models.Promotion
+	var bestDiscount models.Money
+	for i := range s.promotions {
+		promotion := &s.promotions[i]
+		if promotion.ProductID != 0 && promotion.ProductID != product.ProductID {
+			continue
+		}
+		if promotion.ProductID == 0 && promotion.CategoryName != "" && promotion.CategoryName != product.CategoryName {
+			continue
+		}
+		if discount := PromotionDiscount(promotion, price, quantity, currency); discount > bestDiscount {
+			best, bestDiscount = promotion, discount
+		}
+	}
+	return best, bestDiscount
+}
+
+// PromotionDiscount is what a promotion takes off quantity units at price,
+// rounded to the currency and never more than the line itself
+func PromotionDiscount(promotion *models.Promotion, price models.Money, quantity int, currency string) models.Money {
+	amount := price.Mul(quantity).Round(currency)
+	var discount models.Money
+	switch promotion.Kind {
+	case models.PromotionPercent:
+		discount = amount.MulFrac(int64(promotion.Value), 100*models.MoneyScale)
+	case models.PromotionFixed:
+		discount = promotion.Value.Mul(quantity)
+	case models.PromotionBuyXGetY:
+		free := quantity / (promotion.BuyQuantity + promotion.GetQuantity) * promotion.GetQuantity
+		discount = price.Mul(free)
+	}
+	discount = discount.Round(currency)
+	if discount > amount {
+		discount = amount
+	}
+	return discount
+}
+
+// IssuePriceApproval lets a cashier sell a product at minPrice or above, once,
+// within PriceApprovalTTL. It returns the token to hand to the cashier.
+func IssuePriceApproval(db *gorm.DB, orgID uint, productID int, minPrice models.Money, approvedBy uint) (string, *models.PriceApproval, error) {
+	if minPrice < 0 {
+		return "", nil, ErrInvalidPrice
+	}
+	var products int64
+	if err := db.Model(&models.Product{}).Where("product_id = ? AND organization_id = ?", productID, orgID).
+		Count(&products).Error; err != nil {
+		return "", nil, err
+	}
+	if products == 0 {
+		return "", nil, ErrProductNotFound
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	approval := models.PriceApproval{
+		OrganizationID: orgID,
+		ProductID:      productID,
+		MinPrice:       minPrice,
+		TokenHash:      hashToken(token),
+		ApprovedBy:     approvedBy,
+		ExpiresAt:      time.Now().Add(PriceApprovalTTL),
+	}
+	if err := db.Create(&approval).Error; err != nil {
+		return "", nil, err
+	}
+	return token, &approval, nil
+}
+
+// overridePrice checks a cashier's price for a product. Prices further below
+// the list price than PriceOverrideApprovalPercent use up a manager's
+// approval, which is returned.
+func overridePrice(tx *gorm.DB, product *models.Product, price models.Money, token string) (*models.PriceApproval, error) {
+	if price < 0 {
+		return nil, ErrInvalidPrice
+	}
+	hundred := int64(100 * models.MoneyScale)
+	floor := product.Price.MulFrac(hundred-int64(PriceOverrideApprovalPercent), hundred)
+	if price >= floor {
+		return nil, nil
+	}
+	if token == "" {
+		return nil, ErrApprovalRequired
+	}
+
+	var approval models.PriceApproval
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ? AND organization_id = ?", hashToken(token), product.OrganizationID).First(&approval).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrApprovalInvalid
+		}
+		return nil, err
+	}
+	if approval.UsedAt != nil || time.Now().After(approval.ExpiresAt) ||
+		approval.ProductID != product.ProductID || price < approval.MinPrice {
+		return nil, ErrApprovalInvalid
+	}
+	now := time.Now()
+	if err := tx.Model(&approval).Update("used_at", now).Error; err != nil {
+		return nil, err
+	}
+	approval.UsedAt = &now
+	return &approval, nil
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/models"
+)
+
+var (
+	ErrPurchaseOrderNotFound = errors.New("purchase order not found")
+	ErrInvalidTransition     = errors.New("invalid purchase order status transition")
+	ErrUnknownOrderLine      = errors.New("line does not belong to the purchase order")
+	ErrOverReceipt           = errors.New("received quantity exceeds outstanding quantity")
+)
+
+// purchaseOrderTransitions lists the states an order may move to from each state
+var purchaseOrderTransitions = map[string][]string{
+	models.PurchaseOrderStatusDraft:             {models.PurchaseOrderStatusSent, models.PurchaseOrderStatusCancelled},
+	models.PurchaseOrderStatusSent:              {models.PurchaseOrderStatusPartiallyReceived, models.PurchaseOrderStatusReceived, models.PurchaseOrderStatusCancelled},
+	models.PurchaseOrderStatusPartiallyReceived: {models.PurchaseOrderStatusPartiallyReceived, models.PurchaseOrderStatusReceived, models.PurchaseOrderStatusClosed},
+	models.PurchaseOrderStatusReceived:          {models.PurchaseOrderStatusClosed},
+}
+
+// CanTransition reports whether a purchase order may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, next := range purchaseOrderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ReceiveLine is the quantity and cost that arrived for one purchase order line
+type ReceiveLine struct {
+	LineID   uint         `json:"line_id"`
+	Quantity int          `json:"quantity"`
+	UnitCost models.Money `json:"unit_cost"`
+}
+
+// LockPurchaseOrder loads a tenant's purchase order and its lines with a row lock on the order
+func LockPurchaseOrder(tx *gorm.DB, orgID uint, orderID uint) (*models.PurchaseOrder, error) {
+	var order models.PurchaseOrder
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Lines").
+		Where("id = ? AND organization_id = ?", orderID, orgID).First(&order).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPurchaseOrderNotFound
+		}
+		return nil, err
+	}
+	return &order, nil
+}
+
+// TransitionPurchaseOrder moves an order to a new status, stamping the matching timestamp
+func TransitionPurchaseOrder(tx *gorm.DB, order *models.PurchaseOrder, status string) error {
+	if !CanTransition(order.Status, status) {
+		return ErrInvalidTransition
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"status": status}
+	switch status {
+	case models.PurchaseOrderStatusSent:
+		order.SentAt = &now
+		updates["sent_at"] = now
+	case models.PurchaseOrderStatusReceived:
+		order.ReceivedAt = &now
+		updates["received_at"] = now
+	case models.PurchaseOrderStatusClosed:
+		order.ClosedAt = &now
+		updates["closed_at"] = now
+	case models.PurchaseOrderStatusCancelled:
+		order.CancelledAt = &now
+		updates["cancelled_at"] = now
+	}
+
+	if err := tx.Model(order).Updates(updates).Error; err != nil {
+		return err
+	}
+	order.Status = status
+	return nil
+}
+
+// ReceivePurchaseOrder books goods received against an order. Each line increases
+// product stock through the ledger and records the unit cost it arrived at on
+// the goods receipt, leaving the ordered cost on the line untouched.
+func ReceivePurchaseOrder(tx *gorm.DB, orgID uint, orderID uint, userID uint, lines []ReceiveLine) (*models.PurchaseOrder, []models.GoodsReceipt, error) {
+	order, err := LockPurchaseOrder(tx, orgID, orderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !CanTransition(order.Status, models.PurchaseOrderStatusPartiallyReceived) &&
+		!CanTransition(order.Status, models.PurchaseOrderStatusReceived) {
+		return nil, nil, ErrInvalidTransition
+	}
+	if len(lines) == 0 {
+		return nil, nil, ErrInvalidQuantity
+	}
+
+	orderLines := make(map[uint]*models.PurchaseOrderLine, len(order.Lines))
+	for i := range order.Lines {
+		orderLines[order.Lines[i].ID] = &order.Lines[i]
+	}
+
+	var receipts []models.GoodsReceipt
+	for _, received := range lines {
+		line, ok := orderLines[received.LineID]
+		if !ok {
+			return nil, nil, ErrUnknownOrderLine
+		}
+		if received.Quantity <= 0 {
+			return nil, nil, ErrInvalidQuantity
+		}
+		if line.QuantityReceived+received.Quantity > line.QuantityOrdered {
+			return nil, nil, ErrOverReceipt
+		}
+
+		unitCost := received.UnitCost
+		if unitCost == 0 {
+			unitCost = line.UnitCost
+		}
+
+		receipt := models.GoodsReceipt{
+			OrganizationID:      order.OrganizationID,
+			PurchaseOrderID:     order.ID,
+			PurchaseOrderLineID: line.ID,
+			ProductID:           line.ProductID,
+			Quantity:            received.Quantity,
+			UnitCost:            unitCost,
+			ReceivedBy:          userID,
+		}
+		if err := tx.Create(&receipt).Error; err != nil {
+			return nil, nil, err
+		}
+
+		if _, err := RecordMovement(tx, Movement{
+			OrganizationID: order.OrganizationID,
+			ProductID:      line.ProductID,
+			Delta:          received.Quantity,
+			Reason:         models.MovementReasonReceipt,
+			UserID:         userID,
+			ReferenceType:  "purchase_order",
+			ReferenceID:    order.ID,
+			UnitCost:       unitCost,
+		}); err != nil {
+			return nil, nil, err
+		}
+
+		line.QuantityReceived += received.Quantity
+		if err := tx.Model(line).Update("quantity_received", line.QuantityReceived).Error; err != nil {
+			return nil, nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	status := models.PurchaseOrderStatusReceived
+	for _, line := range order.Lines {
+		if line.QuantityReceived < line.QuantityOrdered {
+			status = models.PurchaseOrderStatusPartiallyReceived
+			break
+		}
+	}
+	if err := TransitionPurchaseOrder(tx, order, status); err != nil {
+		return nil, nil, err
+	}
+
+	return order, receipts, nil
+}
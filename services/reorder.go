@@ -0,0 +1,200 @@
+package services
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"stock/models"
+)
+
+const (
+	// reorderVelocityDays is the sales history used to estimate daily demand
+	reorderVelocityDays = 30
+	// reorderCoverDays is how many days of demand a suggested purchase should cover
+	reorderCoverDays = 14
+)
+
+// ReorderEvaluator flags products at or below their reorder level. It runs on a
+// schedule and whenever Trigger is called, e.g. after a sale.
+type ReorderEvaluator struct {
+	db       *gorm.DB
+	interval time.Duration
+	trigger  chan struct{}
+	mu       sync.Mutex
+}
+
+var reorderEvaluator *ReorderEvaluator
+
+// NewReorderEvaluator creates an evaluator that runs every interval once started
+func NewReorderEvaluator(db *gorm.DB, interval time.Duration) *ReorderEvaluator {
+	return &ReorderEvaluator{
+		db:       db,
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// StartReorderEvaluator starts the shared evaluator in the background
+func StartReorderEvaluator(db *gorm.DB, interval time.Duration) {
+	reorderEvaluator = NewReorderEvaluator(db, interval)
+	go reorderEvaluator.Run()
+}
+
+// TriggerReorderEvaluation asks the shared evaluator to run soon. It never blocks
+// and does nothing if the evaluator has not been started.
+func TriggerReorderEvaluation() {
+	if reorderEvaluator != nil {
+		reorderEvaluator.Trigger()
+	}
+}
+
+// RefreshReorderAlerts evaluates straight away. It goes through the shared
+// evaluator when it has been started, so it never overlaps a scheduled pass
+// and opens a second alert for the same product.
+func RefreshReorderAlerts(db *gorm.DB) ([]models.ReorderAlert, error) {
+	if reorderEvaluator != nil {
+		return reorderEvaluator.Evaluate()
+	}
+	return EvaluateReorderAlerts(db)
+}
+
+// Trigger schedules an evaluation, coalescing requests that arrive while one is pending
+func (e *ReorderEvaluator) Trigger() {
+	select {
+	case e.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run evaluates on every tick and trigger until the process exits
+func (e *ReorderEvaluator) Run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	e.evaluateAndLog()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.trigger:
+		}
+		e.evaluateAndLog()
+	}
+}
+
+func (e *ReorderEvaluator) evaluateAndLog() {
+	if _, err := e.Evaluate(); err != nil {
+		log.Printf("Reorder evaluation failed: %v", err)
+	}
+}
+
+// Evaluate opens or refreshes an alert for every low product and resolves alerts
+// for products that have been restocked. It returns the open alerts.
+func (e *ReorderEvaluator) Evaluate() ([]models.ReorderAlert, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return EvaluateReorderAlerts(e.db)
+}
+
+// EvaluateReorderAlerts performs a single evaluation pass. Passes must not
+// overlap; callers outside the evaluator use RefreshReorderAlerts.
+func EvaluateReorderAlerts(db *gorm.DB) ([]models.ReorderAlert, error) {
+	var lowProducts []models.Product
+	if err := db.Where("reorder_level > 0 AND quantity <= reorder_level").Find(&lowProducts).Error; err != nil {
+		return nil, err
+	}
+
+	velocities, err := salesVelocities(db, lowProducts)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []models.ReorderAlert
+	if err := db.Where("status = ?", models.ReorderAlertStatusOpen).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	openByProduct := make(map[int]models.ReorderAlert, len(existing))
+	for _, alert := range existing {
+		openByProduct[alert.ProductID] = alert
+	}
+
+	var alerts []models.ReorderAlert
+	low := make(map[int]bool, len(lowProducts))
+	for _, product := range lowProducts {
+		low[product.ProductID] = true
+
+		alert := openByProduct[product.ProductID]
+		alert.OrganizationID = product.OrganizationID
+		alert.ProductID = product.ProductID
+		alert.ProductName = product.ProductName
+		alert.Quantity = product.Quantity
+		alert.ReorderLevel = product.ReorderLevel
+		alert.DailyVelocity = velocities[product.ProductID]
+		alert.SuggestedQuantity = SuggestedReorderQuantity(product.Quantity, product.ReorderLevel, alert.DailyVelocity)
+		alert.Status = models.ReorderAlertStatusOpen
+		if err := db.Save(&alert).Error; err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+
+	now := time.Now()
+	for productID, alert := range openByProduct {
+		if low[productID] {
+			continue
+		}
+		if err := db.Model(&alert).Updates(map[string]interface{}{
+			"status":      models.ReorderAlertStatusResolved,
+			"resolved_at": now,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return alerts, nil
+}
+
+// SuggestedReorderQuantity tops stock up to cover the reorder level plus
+// reorderCoverDays of demand, and never suggests less than the reorder level itself.
+func SuggestedReorderQuantity(quantity, reorderLevel int, dailyVelocity float64) int {
+	target := reorderLevel + int(math.Ceil(dailyVelocity*reorderCoverDays))
+	if target < 2*reorderLevel {
+		target = 2 * reorderLevel
+	}
+	if target <= quantity {
+		return 0
+	}
+	return target - quantity
+}
+
+// salesVelocities returns average units sold per day over the velocity window
+func salesVelocities(db *gorm.DB, products []models.Product) (map[int]float64, error) {
+	velocities := make(map[int]float64, len(products))
+	if len(products) == 0 {
+		return velocities, nil
+	}
+
+	ids := make([]int, len(products))
+	for i, product := range products {
+		ids[i] = product.ProductID
+	}
+
+	var rows []struct {
+		ProductID int
+		Units     int
+	}
+	since := time.Now().AddDate(0, 0, -reorderVelocityDays)
+	if err := db.Model(&models.Sale{}).
+		Select("product_id, SUM(quantity) AS units").
+		Where("product_id IN ? AND date >= ?", ids, since).
+		Group("product_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		velocities[row.ProductID] = math.Round(float64(row.Units)/reorderVelocityDays*100) / 100
+	}
+	return velocities, nil
+}
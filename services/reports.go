@@ -0,0 +1,279 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"stock/models"
+)
+
+var (
+	ErrInvalidGrouping = errors.New("group_by must be one of day, week, month, category, product or cashier")
+	ErrInvalidRanking  = errors.New("by must be units or revenue")
+)
+
+// ReportLocation is the time zone reports are grouped in when a request does not pick one
+var ReportLocation = time.Local
+
+// SalesPeriod is the organization and time range a report covers. From is
+// inclusive and To exclusive; days, weeks and months are those of Location.
+type SalesPeriod struct {
+	// OrganizationID 0 covers every organization
+	OrganizationID uint
+	From           time.Time
+	To             time.Time
+	Location       *time.Location
+}
+
+// Previous is the period of the same number of days just before p
+func (p SalesPeriod) Previous() SalesPeriod {
+	from, to := p.From.In(p.Location), p.To.In(p.Location)
+	days := int(math.Round(to.Sub(from).Hours() / 24))
+	p.To = p.From
+	p.From = from.AddDate(0, 0, -days)
+	return p
+}
+
+// SalesGroup is the sales of one group of a report. Units and revenue are net
+// of returns; voided sales are left out.
+type SalesGroup struct {
+	Key     string       `json:"key"`
+	Label   string       `json:"label,omitempty"`
+	Lines   int64        `json:"lines"`
+	Units   int64        `json:"units"`
+	Revenue models.Money `json:"revenue"`
+}
+
+// SalesSummary groups the sales of a period
+type SalesSummary struct {
+	GroupBy string       `json:"group_by"`
+	Groups  []SalesGroup `json:"groups"`
+	Totals  SalesGroup   `json:"totals"`
+}
+
+// SalesComparison is how a period did against the one before it
+type SalesComparison struct {
+	Units          int64        `json:"units"`
+	Revenue        models.Money `json:"revenue"`
+	UnitsPercent   *float64     `json:"units_percent"`
+	RevenuePercent *float64     `json:"revenue_percent"`
+}
+
+// ProductRank is a product's sales in a period, with the previous period's
+// when a comparison was asked for
+type ProductRank struct {
+	ProductID    int          `json:"product_id"`
+	ProductName  string       `json:"product_name"`
+	CategoryName string       `json:"category_name"`
+	Units        int64        `json:"units"`
+	Revenue      models.Money `json:"revenue"`
+	Previous     *SalesGroup  `json:"previous,omitempty"`
+}
+
+// Revenue is net of tax as well as of returns
+const (
+	netUnitsSQL   = "COALESCE(SUM(quantity - returned_quantity), 0)"
+	netRevenueSQL = "COALESCE(SUM(net_amount * (quantity - returned_quantity) / NULLIF(quantity, 0)), 0)"
+)
+
+// keptSQL is the part of a sale line amount column that was not returned
+func keptSQL(column string) string {
+	return column + " * (quantity - returned_quantity) / NULLIF(quantity, 0)"
+}
+
+// SummarizeSalesBy totals a period's sales grouped by day, week (starting
+// Monday), month, category, product or cashier
+func SummarizeSalesBy(db *gorm.DB, period SalesPeriod, groupBy string) (*SalesSummary, error) {
+	var keySQL, labelSQL string
+	var args []interface{}
+	switch groupBy {
+	case "day", "week", "month":
+		keySQL, args = dateKeySQL(groupBy, period)
+		labelSQL = "''"
+	case "category":
+		keySQL, labelSQL = "category_name", "''"
+	case "product":
+		keySQL, labelSQL = "CAST(product_id AS CHAR)", "MAX(name)"
+	case "cashier":
+		keySQL, labelSQL = "user_id", "''"
+	default:
+		return nil, ErrInvalidGrouping
+	}
+
+	summary := &SalesSummary{GroupBy: groupBy, Groups: []SalesGroup{}}
+	err := periodSales(db, period).
+		Select(fmt.Sprintf("%s AS `key`, %s AS label, COUNT(*) AS `lines`, %s AS units, %s AS revenue",
+			keySQL, labelSQL, netUnitsSQL, netRevenueSQL), args...).
+		Group("`key`").Order("`key`").Scan(&summary.Groups).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range summary.Groups {
+		group := &summary.Groups[i]
+		summary.Totals.Lines += group.Lines
+		summary.Totals.Units += group.Units
+		summary.Totals.Revenue += group.Revenue
+	}
+	return summary, nil
+}
+
+// RankProducts orders a period's products by units or revenue, best sellers
+// first or, with ascending, worst first. Products that did not sell at all
+// rank with zero, so they lead the worst sellers.
+func RankProducts(db *gorm.DB, period SalesPeriod, by string, ascending bool, limit int) ([]ProductRank, error) {
+	if by != "units" && by != "revenue" {
+		return nil, ErrInvalidRanking
+	}
+	direction := "DESC"
+	if ascending {
+		direction = "ASC"
+	}
+
+	query := db.Table("products AS p").
+		Select("p.product_id, p.product_name, p.category_name, "+
+			"COALESCE(SUM(s.quantity - s.returned_quantity), 0) AS units, "+
+			"COALESCE(SUM(s.net_amount * (s.quantity - s.returned_quantity) / NULLIF(s.quantity, 0)), 0) AS revenue").
+		Joins("LEFT JOIN sales AS s ON s.product_id = p.product_id AND s.organization_id = p.organization_id"+
+			" AND s.voided_at IS NULL AND s.date >= ? AND s.date < ?", period.From, period.To)
+	if period.OrganizationID != 0 {
+		query = query.Where("p.organization_id = ?", period.OrganizationID)
+	}
+
+	ranks := []ProductRank{}
+	err := query.Group("p.product_id, p.product_name, p.category_name").
+		Order(by + " " + direction).Order("p.product_id").
+		Limit(limit).Scan(&ranks).Error
+	if err != nil {
+		return nil, err
+	}
+	return ranks, nil
+}
+
+// AddPreviousProductSales fills in each rank's sales in the previous period
+func AddPreviousProductSales(db *gorm.DB, period SalesPeriod, ranks []ProductRank) error {
+	if len(ranks) == 0 {
+		return nil
+	}
+	ids := make([]int, len(ranks))
+	for i, rank := range ranks {
+		ids[i] = rank.ProductID
+	}
+
+	var rows []struct {
+		ProductID int
+		SalesGroup
+	}
+	err := periodSales(db, period.Previous()).
+		Select("product_id, COUNT(*) AS `lines`, "+netUnitsSQL+" AS units, "+netRevenueSQL+" AS revenue").
+		Where("product_id IN ?", ids).Group("product_id").Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	previous := make(map[int]SalesGroup, len(rows))
+	for _, row := range rows {
+		previous[row.ProductID] = row.SalesGroup
+	}
+	for i := range ranks {
+		group := previous[ranks[i].ProductID]
+		group.Key = fmt.Sprint(ranks[i].ProductID)
+		ranks[i].Previous = &group
+	}
+	return nil
+}
+
+// CompareSales is the change from previous to current
+func CompareSales(current, previous SalesGroup) SalesComparison {
+	return SalesComparison{
+		Units:          current.Units - previous.Units,
+		Revenue:        current.Revenue - previous.Revenue,
+		UnitsPercent:   percentChange(float64(current.Units), float64(previous.Units)),
+		RevenuePercent: percentChange(current.Revenue.Float64(), previous.Revenue.Float64()),
+	}
+}
+
+func percentChange(current, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := math.Round((current-previous)/previous*10000) / 100
+	return &change
+}
+
+// dateKeySQL is the key of the day, week (starting Monday) or month a sale
+// was made in, in the period's time zone
+func dateKeySQL(groupBy string, period SalesPeriod) (string, []interface{}) {
+	local, args := localDateSQL("date", period)
+	switch groupBy {
+	case "week":
+		return "DATE_FORMAT(DATE_SUB(" + local + ", INTERVAL WEEKDAY(" + local + ") DAY), '%Y-%m-%d')",
+			append(append([]interface{}{}, args...), args...)
+	case "month":
+		return "DATE_FORMAT(" + local + ", '%Y-%m')", args
+	default:
+		return "DATE_FORMAT(" + local + ", '%Y-%m-%d')", args
+	}
+}
+
+func periodSales(db *gorm.DB, period SalesPeriod) *gorm.DB {
+	query := db.Model(&models.Sale{}).
+		Where("voided_at IS NULL AND date >= ? AND date < ?", period.From, period.To)
+	if period.OrganizationID != 0 {
+		query = query.Where("organization_id = ?", period.OrganizationID)
+	}
+	return query
+}
+
+// localDateSQL shifts column, a DATETIME in the server's time zone, into the
+// period's time zone. The offsets between the two are worked out here rather
+// than with CONVERT_TZ, which needs the MySQL time zone tables loaded; a
+// daylight saving change inside the period becomes a CASE branch.
+func localDateSQL(column string, period SalesPeriod) (string, []interface{}) {
+	shift := func(t time.Time) int {
+		_, local := t.In(period.Location).Zone()
+		_, server := t.In(time.Local).Zone()
+		return local - server
+	}
+
+	// Find each instant in the period where the shift changes
+	var changes []time.Time
+	for day := period.From; day.Before(period.To); {
+		next := day.Add(24 * time.Hour)
+		if next.After(period.To) {
+			next = period.To
+		}
+		if shift(day) != shift(next) {
+			lo, hi := day, next
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if shift(mid) == shift(lo) {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			// Zones change on whole seconds
+			changes = append(changes, hi.Truncate(time.Second))
+		}
+		day = next
+	}
+
+	if len(changes) == 0 {
+		return fmt.Sprintf("DATE_ADD(%s, INTERVAL ? SECOND)", column), []interface{}{shift(period.From)}
+	}
+	var sql strings.Builder
+	var args []interface{}
+	sql.WriteString("DATE_ADD(" + column + ", INTERVAL CASE")
+	for _, change := range changes {
+		sql.WriteString(" WHEN " + column + " < ? THEN ?")
+		args = append(args, change, shift(change.Add(-time.Second)))
+	}
+	sql.WriteString(" ELSE ? END SECOND)")
+	args = append(args, shift(period.To.Add(-time.Second)))
+	return sql.String(), args
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/models"
+)
+
+var (
+	ErrSaleNotFound       = errors.New("sale not found")
+	ErrSaleVoided         = errors.New("sale has already been voided")
+	ErrReturnExceedsSale  = errors.New("return quantity exceeds quantity still on the sale")
+	ErrReasonRequired     = errors.New("a reason is required")
+	ErrSaleWithoutProduct = errors.New("sale did not take stock and cannot be restocked")
+)
+
+// ReturnInput describes goods coming back against a sale line
+type ReturnInput struct {
+	OrganizationID uint
+	SaleID         int
+	Quantity       int
+	Reason         string
+	// Restock puts the returned quantity back on the product. Damaged goods skip it.
+	Restock bool
+	UserID  uint
+}
+
+// LockSale loads a tenant's sale line with a row lock held until the transaction ends
+func LockSale(tx *gorm.DB, orgID uint, saleID int) (*models.Sale, error) {
+	var sale models.Sale
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("sale_id = ? AND organization_id = ?", saleID, orgID).First(&sale).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSaleNotFound
+		}
+		return nil, err
+	}
+	return &sale, nil
+}
+
+// ReturnSale reverses part or all of a sale line
+func ReturnSale(tx *gorm.DB, input ReturnInput) (*models.SaleReturn, error) {
+	if input.Reason == "" {
+		return nil, ErrReasonRequired
+	}
+	if input.Quantity <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+
+	sale, err := LockSale(tx, input.OrganizationID, input.SaleID)
+	if err != nil {
+		return nil, err
+	}
+	if sale.VoidedAt != nil {
+		return nil, ErrSaleVoided
+	}
+	if sale.ReturnedQuantity+input.Quantity > sale.Quantity {
+		return nil, ErrReturnExceedsSale
+	}
+
+	return reverseSale(tx, sale, input, false)
+}
+
+// VoidSale reverses whatever is left on a sale line, marks it voided and
+// records the void in the audit trail
+func VoidSale(tx *gorm.DB, orgID uint, saleID int, reason string, actor Actor) (*models.Sale, *models.SaleReturn, error) {
+	if reason == "" {
+		return nil, nil, ErrReasonRequired
+	}
+
+	sale, err := LockSale(tx, orgID, saleID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sale.VoidedAt != nil {
+		return nil, nil, ErrSaleVoided
+	}
+	before := *sale
+
+	var saleReturn *models.SaleReturn
+	if remaining := sale.Quantity - sale.ReturnedQuantity; remaining > 0 {
+		saleReturn, err = reverseSale(tx, sale, ReturnInput{
+			OrganizationID: orgID,
+			SaleID:         saleID,
+			Quantity:       remaining,
+			Reason:         reason,
+			Restock:        true,
+			UserID:         actor.UserID,
+		}, true)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	now := time.Now()
+	if err := tx.Model(&models.Sale{}).Where("sale_id = ?", saleID).Updates(map[string]interface{}{
+		"voided_at":   now,
+		"void_reason": reason,
+	}).Error; err != nil {
+		return nil, nil, err
+	}
+	sale.VoidedAt = &now
+	sale.VoidReason = reason
+
+	if err := RecordAudit(tx, actor, models.AuditSaleVoided, "sale", uint(saleID), before, sale); err != nil {
+		return nil, nil, err
+	}
+	return sale, saleReturn, nil
+}
+
+// VoidReceipt voids every line on a receipt that has not been voided yet and
+// returns the lines with the refunds made for them
+func VoidReceipt(tx *gorm.DB, orgID uint, receiptID uint, reason string, actor Actor) ([]models.Sale, []models.PaymentRefund, error) {
+	var saleIDs []int
+	if err := tx.Model(&models.Sale{}).Where("receipt_id = ? AND organization_id = ? AND voided_at IS NULL", receiptID, orgID).
+		Order("sale_id").Pluck("sale_id", &saleIDs).Error; err != nil {
+		return nil, nil, err
+	}
+	if len(saleIDs) == 0 {
+		return nil, nil, ErrSaleNotFound
+	}
+
+	var voided []models.Sale
+	var refunds []models.PaymentRefund
+	for _, saleID := range saleIDs {
+		sale, saleReturn, err := VoidSale(tx, orgID, saleID, reason, actor)
+		if err != nil {
+			return nil, nil, err
+		}
+		voided = append(voided, *sale)
+		if saleReturn != nil {
+			refunds = append(refunds, saleReturn.Refunds...)
+		}
+	}
+	return voided, refunds, nil
+}
+
+// saleMovement is the stock movement that took a sale line's units off the
+// shelf, or nil when the line never took stock
+func saleMovement(tx *gorm.DB, sale *models.Sale) (*models.StockMovement, error) {
+	if sale.ProductID == 0 {
+		return nil, nil
+	}
+	var movement models.StockMovement
+	err := tx.Where("organization_id = ? AND product_id = ? AND reason = ? AND reference_type = ? AND reference_id = ?",
+		sale.OrganizationID, sale.ProductID, models.MovementReasonSale, "sale", sale.SaleID).First(&movement).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &movement, nil
+}
+
+// reverseSale records a return against a sale line. Only units that left
+// stock through a sale movement go back on the shelf: a void skips the
+// restock for lines without one, a return asking for it is refused.
+func reverseSale(tx *gorm.DB, sale *models.Sale, input ReturnInput, isVoid bool) (*models.SaleReturn, error) {
+	var movement *models.StockMovement
+	if input.Restock {
+		var err error
+		if movement, err = saleMovement(tx, sale); err != nil {
+			return nil, err
+		}
+		if movement == nil {
+			if !isVoid {
+				return nil, ErrSaleWithoutProduct
+			}
+			input.Restock = false
+		}
+	}
+
+	saleReturn := models.SaleReturn{
+		OrganizationID: sale.OrganizationID,
+		SaleID:         sale.SaleID,
+		ReceiptID:      sale.ReceiptID,
+		ProductID:      sale.ProductID,
+		Quantity:       input.Quantity,
+		Amount:         refundShare(sale.LineTotal, sale.ReturnedQuantity, input.Quantity, sale.Quantity, sale.Currency),
+		TaxAmount:      refundShare(sale.TaxAmount, sale.ReturnedQuantity, input.Quantity, sale.Quantity, sale.Currency),
+		Reason:         input.Reason,
+		Restocked:      input.Restock,
+		IsVoid:         isVoid,
+		UserID:         input.UserID,
+	}
+	if err := tx.Create(&saleReturn).Error; err != nil {
+		return nil, err
+	}
+	refunds, err := refundPayments(tx, &saleReturn)
+	if err != nil {
+		return nil, err
+	}
+	saleReturn.Refunds = refunds
+
+	if input.Restock {
+		// Restocked goods go back in at the cost they left stock at
+		unitCost := movement.UnitCost
+		if _, err := RecordMovement(tx, Movement{
+			OrganizationID: sale.OrganizationID,
+			ProductID:      sale.ProductID,
+			Delta:          input.Quantity,
+			Reason:         models.MovementReasonReturn,
+			UserID:         input.UserID,
+			ReferenceType:  "sale_return",
+			ReferenceID:    saleReturn.ID,
+			Note:           input.Reason,
+			UnitCost:       unitCost,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	sale.ReturnedQuantity += input.Quantity
+	if err := tx.Model(&models.Sale{}).Where("sale_id = ?", sale.SaleID).
+		Update("returned_quantity", sale.ReturnedQuantity).Error; err != nil {
+		return nil, err
+	}
+
+	return &saleReturn, nil
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/models"
+)
+
+var (
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleNameRequired   = errors.New("role name is required")
+	ErrUnknownPermission  = errors.New("unknown permission")
+	ErrDefaultRoleChange  = errors.New("default roles cannot be changed")
+	ErrRoleInUse          = errors.New("role is assigned to users")
+	ErrRoleNotAssignable  = errors.New("role cannot be assigned by this organization")
+	ErrPermissionRequired = errors.New("at least one permission is required")
+)
+
+// RoleHasPermission reports whether a role has been granted the named permission
+func RoleHasPermission(db *gorm.DB, roleID uint, permission string) (bool, error) {
+	var count int64
+	err := db.Table("role_permissions").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Where("role_permissions.role_id = ? AND permissions.name = ?", roleID, permission).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// AssignableRole checks that an organization admin may give a user the role:
+// either one of the default organization staff roles or one of the
+// organization's own custom roles
+func AssignableRole(db *gorm.DB, orgID uint, roleID uint) error {
+	if roleID == models.OrganizationShopAttendantRoleID || roleID == models.OrganizationAuditorRoleID {
+		return nil
+	}
+	var count int64
+	if err := db.Model(&models.Role{}).Where("id = ? AND organization_id = ? AND is_default = ?", roleID, orgID, false).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrRoleNotAssignable
+	}
+	return nil
+}
+
+// SaveRole creates or updates an organization's custom role and replaces its
+// permissions with the named ones. It must be called inside a transaction.
+func SaveRole(tx *gorm.DB, role *models.Role, permissionNames []string) error {
+	if role.Name == "" {
+		return ErrRoleNameRequired
+	}
+	if len(permissionNames) == 0 {
+		return ErrPermissionRequired
+	}
+	if role.IsDefault {
+		return ErrDefaultRoleChange
+	}
+
+	var permissions []models.Permission
+	if err := tx.Where("name IN ?", permissionNames).Find(&permissions).Error; err != nil {
+		return err
+	}
+	if len(permissions) != len(uniqueStrings(permissionNames)) {
+		return ErrUnknownPermission
+	}
+
+	if err := tx.Omit("Permissions").Save(role).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(role).Association("Permissions").Replace(permissions); err != nil {
+		return err
+	}
+	role.Permissions = permissions
+	return nil
+}
+
+// LockRole loads an organization's custom role with a row lock held until the transaction ends
+func LockRole(tx *gorm.DB, orgID uint, roleID uint) (*models.Role, error) {
+	var role models.Role
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND organization_id = ?", roleID, orgID).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, err
+	}
+	if role.IsDefault {
+		return nil, ErrDefaultRoleChange
+	}
+	return &role, nil
+}
+
+// DeleteRole removes an organization's custom role once no user holds it
+func DeleteRole(tx *gorm.DB, orgID uint, roleID uint) error {
+	role, err := LockRole(tx, orgID, roleID)
+	if err != nil {
+		return err
+	}
+
+	var users int64
+	if err := tx.Model(&models.User{}).Where("role_id = ?", role.ID).Count(&users).Error; err != nil {
+		return err
+	}
+	if users > 0 {
+		return ErrRoleInUse
+	}
+
+	if err := tx.Model(role).Association("Permissions").Clear(); err != nil {
+		return err
+	}
+	return tx.Delete(role).Error
+}
+
+func uniqueStrings(values []string) map[string]bool {
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		seen[v] = true
+	}
+	return seen
+}
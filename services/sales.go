@@ -0,0 +1,37 @@
+package services
+
+import (
+	"gorm.io/gorm"
+	"stock/models"
+)
+
+// SaleTotals sums a set of sale lines. Net figures take returns and voids off.
+type SaleTotals struct {
+	Lines         int64        `json:"lines"`
+	Units         int64        `json:"units"`
+	ReturnedUnits int64        `json:"returned_units"`
+	NetUnits      int64        `json:"net_units"`
+	Revenue       models.Money `json:"revenue"`
+	NetRevenue    models.Money `json:"net_revenue"`
+	Tax           models.Money `json:"tax"`
+	NetTax        models.Money `json:"net_tax"`
+}
+
+// SummarizeSales totals the sale lines matched by query. Revenue is what
+// customers paid, tax included.
+func SummarizeSales(query *gorm.DB) (SaleTotals, error) {
+	var totals SaleTotals
+	err := query.Select("COUNT(*) AS `lines`, " +
+		"COALESCE(SUM(quantity), 0) AS units, " +
+		"COALESCE(SUM(returned_quantity), 0) AS returned_units, " +
+		"COALESCE(SUM(line_total), 0) AS revenue, " +
+		"COALESCE(SUM(" + keptSQL("line_total") + "), 0) AS net_revenue, " +
+		"COALESCE(SUM(tax_amount), 0) AS tax, " +
+		"COALESCE(SUM(" + keptSQL("tax_amount") + "), 0) AS net_tax").
+		Scan(&totals).Error
+	if err != nil {
+		return totals, err
+	}
+	totals.NetUnits = totals.Units - totals.ReturnedUnits
+	return totals, nil
+}
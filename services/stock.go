@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"log"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/models"
+)
+
+var (
+	ErrProductNotFound   = errors.New("product not found")
+	ErrInsufficientStock = errors.New("insufficient quantity")
+	ErrInvalidMovement   = errors.New("invalid stock movement")
+)
+
+// Movement describes a change to be appended to the stock ledger
+type Movement struct {
+	// OrganizationID is the tenant that must own the product
+	OrganizationID uint
+	ProductID      int
+	Delta          int
+	Reason         string
+	UserID         uint
+	ReferenceType  string
+	ReferenceID    uint
+	Note           string
+	// UnitCost is what incoming stock cost; zero brings it in at the average cost
+	UnitCost models.Money
+}
+
+var validReasons = map[string]bool{
+	models.MovementReasonSale:       true,
+	models.MovementReasonReceipt:    true,
+	models.MovementReasonAdjustment: true,
+	models.MovementReasonReturn:     true,
+	models.MovementReasonWriteOff:   true,
+}
+
+// LockProduct loads a tenant's product with a row lock held until the transaction ends
+func LockProduct(tx *gorm.DB, orgID uint, productID int) (*models.Product, error) {
+	var product models.Product
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ? AND organization_id = ?", productID, orgID).First(&product).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	return &product, nil
+}
+
+// RecordMovement locks the product, appends the movement to the ledger and
+// refreshes the cached products.quantity. It must be called inside a transaction.
+func RecordMovement(tx *gorm.DB, m Movement) (*models.StockMovement, error) {
+	product, err := LockProduct(tx, m.OrganizationID, m.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	return ApplyMovement(tx, product, m)
+}
+
+// ApplyMovement appends the movement for a product the caller has already
+// locked and costs it against the product's cost layers
+func ApplyMovement(tx *gorm.DB, product *models.Product, m Movement) (*models.StockMovement, error) {
+	if m.Delta == 0 || !validReasons[m.Reason] {
+		return nil, ErrInvalidMovement
+	}
+
+	quantityAfter := product.Quantity + m.Delta
+	if quantityAfter < 0 {
+		log.Printf("Insufficient quantity for product ID %d: Available %d, Requested %d", product.ProductID, product.Quantity, -m.Delta)
+		return nil, ErrInsufficientStock
+	}
+
+	unitCost, valueDelta, err := costMovement(tx, product, m)
+	if err != nil {
+		return nil, err
+	}
+
+	movement := models.StockMovement{
+		OrganizationID: product.OrganizationID,
+		ProductID:      product.ProductID,
+		Delta:          m.Delta,
+		QuantityAfter:  quantityAfter,
+		Reason:         m.Reason,
+		UserID:         m.UserID,
+		ReferenceType:  m.ReferenceType,
+		ReferenceID:    m.ReferenceID,
+		Note:           m.Note,
+		UnitCost:       unitCost,
+		ValueDelta:     valueDelta,
+	}
+	if err := tx.Create(&movement).Error; err != nil {
+		return nil, err
+	}
+	if m.Delta > 0 {
+		if err := addCostLayer(tx, &movement); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Model(&models.Product{}).Where("product_id = ?", product.ProductID).Updates(map[string]interface{}{
+		"quantity":     quantityAfter,
+		"average_cost": product.AverageCost,
+	}).Error; err != nil {
+		return nil, err
+	}
+	product.Quantity = quantityAfter
+
+	return &movement, nil
+}
+
+// RebuildProductQuantity recomputes products.quantity from the ledger and returns the rebuilt value
+func RebuildProductQuantity(tx *gorm.DB, orgID uint, productID int) (int, error) {
+	if _, err := LockProduct(tx, orgID, productID); err != nil {
+		return 0, err
+	}
+
+	var quantity int
+	if err := tx.Model(&models.StockMovement{}).Where("product_id = ?", productID).
+		Select("COALESCE(SUM(delta), 0)").Scan(&quantity).Error; err != nil {
+		return 0, err
+	}
+
+	if err := tx.Model(&models.Product{}).Where("product_id = ?", productID).Update("quantity", quantity).Error; err != nil {
+		return 0, err
+	}
+	return quantity, nil
+}
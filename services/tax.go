@@ -0,0 +1,298 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/models"
+)
+
+var (
+	ErrTaxRateNotFound    = errors.New("tax rate not found")
+	ErrInvalidTaxRate     = errors.New("tax rate needs a code, a class of taxable, zero_rated or exempt, and a rate from 0 to 100")
+	ErrDuplicateTaxCode   = errors.New("tax code is already in use")
+	ErrTaxRateInUse       = errors.New("tax rate is still used by products, categories or the organization default")
+	ErrInvalidTaxGrouping = errors.New("group_by must be day, week or month")
+)
+
+// TaxSettings is how an organization charges tax
+type TaxSettings struct {
+	PricesIncludeTax bool   `json:"prices_include_tax"`
+	DefaultTaxCode   string `json:"default_tax_code"`
+}
+
+// OrganizationTaxSettings returns the tax settings of an organization
+func OrganizationTaxSettings(db *gorm.DB, orgID uint) (TaxSettings, error) {
+	var settings TaxSettings
+	err := db.Model(&models.Organization{}).Where("id = ?", orgID).
+		Select("prices_include_tax, default_tax_code").Scan(&settings).Error
+	return settings, err
+}
+
+// SetOrganizationTaxSettings changes how an organization charges tax. The
+// default tax code, if any, must be one of its rates.
+func SetOrganizationTaxSettings(db *gorm.DB, orgID uint, settings TaxSettings) error {
+	if settings.DefaultTaxCode != "" {
+		if _, err := FindTaxRate(db, orgID, settings.DefaultTaxCode); err != nil {
+			return err
+		}
+	}
+	return db.Model(&models.Organization{}).Where("id = ?", orgID).Updates(map[string]interface{}{
+		"prices_include_tax": settings.PricesIncludeTax,
+		"default_tax_code":   settings.DefaultTaxCode,
+	}).Error
+}
+
+// FindTaxRate looks up an organization's tax rate by code
+func FindTaxRate(db *gorm.DB, orgID uint, code string) (*models.TaxRate, error) {
+	var rate models.TaxRate
+	if err := db.Where("organization_id = ? AND code = ?", orgID, code).First(&rate).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTaxRateNotFound
+		}
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// LockTaxRate loads an organization's tax rate by ID with a row lock held
+// until the transaction ends
+func LockTaxRate(tx *gorm.DB, orgID uint, id uint) (*models.TaxRate, error) {
+	var rate models.TaxRate
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND organization_id = ?", id, orgID).First(&rate).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTaxRateNotFound
+		}
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// SaveTaxRate validates and creates or updates a tax rate. Zero-rated and
+// exempt rates are always 0%.
+func SaveTaxRate(db *gorm.DB, rate *models.TaxRate) error {
+	rate.Code = strings.TrimSpace(rate.Code)
+	switch rate.Class {
+	case "":
+		rate.Class = models.TaxClassTaxable
+	case models.TaxClassZeroRated, models.TaxClassExempt:
+		rate.Rate = 0
+	}
+	if rate.Code == "" || rate.Rate < 0 || rate.Rate > 100*models.MoneyScale ||
+		(rate.Class != models.TaxClassTaxable && rate.Class != models.TaxClassZeroRated && rate.Class != models.TaxClassExempt) {
+		return ErrInvalidTaxRate
+	}
+
+	var clashes int64
+	if err := db.Model(&models.TaxRate{}).
+		Where("organization_id = ? AND code = ? AND id <> ?", rate.OrganizationID, rate.Code, rate.ID).
+		Count(&clashes).Error; err != nil {
+		return err
+	}
+	if clashes > 0 {
+		return ErrDuplicateTaxCode
+	}
+	return db.Save(rate).Error
+}
+
+// DeleteTaxRate removes a tax rate nothing refers to any more. Sales keep a
+// copy of the rate they were made at.
+func DeleteTaxRate(db *gorm.DB, rate *models.TaxRate) error {
+	var products, categories, organizations int64
+	if err := db.Model(&models.Product{}).Where("organization_id = ? AND tax_code = ?", rate.OrganizationID, rate.Code).
+		Count(&products).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&models.Category{}).Where("organization_id = ? AND tax_code = ?", rate.OrganizationID, rate.Code).
+		Count(&categories).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&models.Organization{}).Where("id = ? AND default_tax_code = ?", rate.OrganizationID, rate.Code).
+		Count(&organizations).Error; err != nil {
+		return err
+	}
+	if products+categories+organizations > 0 {
+		return ErrTaxRateInUse
+	}
+	return db.Delete(rate).Error
+}
+
+// LineTax splits the amount of a line, already rounded to the currency, into
+// net, tax and gross. With inclusive prices the gross is fixed and the tax is
+// taken out of it; otherwise the tax is added to the net. A nil rate charges
+// no tax.
+func LineTax(amount models.Money, rate *models.TaxRate, inclusive bool, currency string) (net, tax, gross models.Money) {
+	if rate == nil || rate.Rate == 0 {
+		return amount, 0, amount
+	}
+	hundred := int64(100 * models.MoneyScale)
+	if inclusive {
+		net = amount.MulFrac(hundred, hundred+int64(rate.Rate)).Round(currency)
+		return net, amount - net, amount
+	}
+	tax = amount.MulFrac(int64(rate.Rate), hundred).Round(currency)
+	return amount, tax, amount + tax
+}
+
+// taxResolver finds the tax rate of each product sold in one transaction,
+// loading every rate and category code once
+type taxResolver struct {
+	tx         *gorm.DB
+	orgID      uint
+	settings   TaxSettings
+	rates      map[string]*models.TaxRate
+	categories map[string]string
+}
+
+func newTaxResolver(tx *gorm.DB, orgID uint) (*taxResolver, error) {
+	settings, err := OrganizationTaxSettings(tx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return &taxResolver{tx: tx, orgID: orgID, settings: settings}, nil
+}
+
+// rateFor returns the product's tax rate: its own, else its category's, else
+// the organization default. It is nil when none applies.
+func (r *taxResolver) rateFor(product *models.Product) (*models.TaxRate, error) {
+	if r.rates == nil {
+		var rates []models.TaxRate
+		if err := r.tx.Where("organization_id = ?", r.orgID).Find(&rates).Error; err != nil {
+			return nil, err
+		}
+		var categories []models.Category
+		if err := r.tx.Where("organization_id = ? AND tax_code <> ''", r.orgID).Order("category_id").Find(&categories).Error; err != nil {
+			return nil, err
+		}
+		r.rates = make(map[string]*models.TaxRate, len(rates))
+		for i := range rates {
+			r.rates[rates[i].Code] = &rates[i]
+		}
+		r.categories = make(map[string]string, len(categories))
+		for _, category := range categories {
+			if _, seen := r.categories[category.CategoryName]; !seen {
+				r.categories[category.CategoryName] = category.TaxCode
+			}
+		}
+	}
+
+	code := product.TaxCode
+	if code == "" {
+		code = r.categories[product.CategoryName]
+	}
+	if code == "" {
+		code = r.settings.DefaultTaxCode
+	}
+	if code == "" {
+		return nil, nil
+	}
+	rate, ok := r.rates[code]
+	if !ok {
+		return nil, ErrTaxRateNotFound
+	}
+	return rate, nil
+}
+
+// applyTax prices a sale line at a tax rate after its discount, setting its
+// net, tax and gross
+func (r *taxResolver) applyTax(sale *models.Sale, rate *models.TaxRate) {
+	amount := sale.Price.Mul(sale.Quantity).Round(sale.Currency) - sale.DiscountAmount
+	sale.PriceIncludesTax = r.settings.PricesIncludeTax
+	sale.NetAmount, sale.TaxAmount, sale.LineTotal = LineTax(amount, rate, sale.PriceIncludesTax, sale.Currency)
+	if rate != nil {
+		sale.TaxCode, sale.TaxClass, sale.TaxRate = rate.Code, rate.Class, rate.Rate
+	}
+}
+
+// refundShare is the part of a sale line's amount that units from returned
+// to returned+quantity make up. Shares are differences of rounded running
+// totals, so returning every unit refunds the amount exactly.
+func refundShare(amount models.Money, returned, quantity, sold int, currency string) models.Money {
+	before := amount.MulFrac(int64(returned), int64(sold)).Round(currency)
+	after := amount.MulFrac(int64(returned+quantity), int64(sold)).Round(currency)
+	return after - before
+}
+
+// TaxGroup is the tax collected at one rate, in one period when the report is
+// split by day, week or month. Amounts are net of returns; voided sales are
+// left out. Sales made without a tax rate have an empty code.
+type TaxGroup struct {
+	Period  string         `json:"period,omitempty"`
+	TaxCode string         `json:"tax_code"`
+	Class   string         `json:"class"`
+	Rate    models.Percent `json:"rate"`
+	Lines   int64          `json:"lines"`
+	Net     models.Money   `json:"net"`
+	Tax     models.Money   `json:"tax"`
+	Gross   models.Money   `json:"gross"`
+}
+
+// TaxSummary is the tax collected over a period, by rate and, when asked for,
+// by rate within each day, week or month
+type TaxSummary struct {
+	Rates   []TaxGroup `json:"rates"`
+	Periods []TaxGroup `json:"periods,omitempty"`
+	Totals  TaxGroup   `json:"totals"`
+}
+
+// SummarizeTax totals the net, tax and gross of a period's sales by tax rate.
+// groupBy is empty, day, week or month.
+func SummarizeTax(db *gorm.DB, period SalesPeriod, groupBy string) (*TaxSummary, error) {
+	keySQL, args := "''", []interface{}(nil)
+	switch groupBy {
+	case "":
+	case "day", "week", "month":
+		keySQL, args = dateKeySQL(groupBy, period)
+	default:
+		return nil, ErrInvalidTaxGrouping
+	}
+
+	var groups []TaxGroup
+	err := periodSales(db, period).
+		Select(keySQL+" AS period, tax_code, tax_class AS class, tax_rate AS rate, COUNT(*) AS `lines`, "+
+			"COALESCE(SUM("+keptSQL("net_amount")+"), 0) AS net, "+
+			"COALESCE(SUM("+keptSQL("tax_amount")+"), 0) AS tax, "+
+			"COALESCE(SUM("+keptSQL("line_total")+"), 0) AS gross", args...).
+		Group("period, tax_code, tax_class, tax_rate").Order("period, tax_code, tax_rate").
+		Scan(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &TaxSummary{Rates: []TaxGroup{}}
+	if groupBy != "" {
+		summary.Periods = groups
+	}
+	rates := map[TaxGroup]int{}
+	for _, group := range groups {
+		key := TaxGroup{TaxCode: group.TaxCode, Class: group.Class, Rate: group.Rate}
+		i, seen := rates[key]
+		if !seen {
+			i = len(summary.Rates)
+			rates[key] = i
+			summary.Rates = append(summary.Rates, key)
+		}
+		rate := &summary.Rates[i]
+		rate.Lines += group.Lines
+		rate.Net += group.Net
+		rate.Tax += group.Tax
+		rate.Gross += group.Gross
+		summary.Totals.Lines += group.Lines
+		summary.Totals.Net += group.Net
+		summary.Totals.Tax += group.Tax
+		summary.Totals.Gross += group.Gross
+	}
+	sort.Slice(summary.Rates, func(i, j int) bool {
+		a, b := summary.Rates[i], summary.Rates[j]
+		if a.TaxCode != b.TaxCode {
+			return a.TaxCode < b.TaxCode
+		}
+		return a.Rate < b.Rate
+	})
+	return summary, nil
+}
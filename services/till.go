@@ -0,0 +1,282 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/models"
+)
+
+var (
+	ErrTillSessionNotFound = errors.New("till session not found")
+	ErrTillSessionOpen     = errors.New("user already has an open till session")
+	ErrTillSessionClosed   = errors.New("till session is closed")
+	ErrInvalidTillEntry    = errors.New("till entries need a kind of cash_in or cash_out, an amount above zero and a reason")
+	ErrInvalidCashAmount   = errors.New("cash amounts must not be negative")
+)
+
+// OpenTillSession starts a shift for a cashier with an opening float. A
+// cashier has at most one open session.
+func OpenTillSession(tx *gorm.DB, orgID uint, userID uint, float models.Money) (*models.TillSession, error) {
+	if float < 0 {
+		return nil, ErrInvalidCashAmount
+	}
+	// Lock the cashier so two sessions cannot be opened at once
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, userID).Error; err != nil {
+		return nil, err
+	}
+	open, err := openTillSession(tx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if open != nil {
+		return nil, ErrTillSessionOpen
+	}
+
+	currency, err := OrganizationCurrency(tx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	session := models.TillSession{
+		OrganizationID: orgID,
+		UserID:         userID,
+		Status:         models.TillSessionOpen,
+		Currency:       currency,
+		OpeningFloat:   float.Round(currency),
+		OpenedAt:       time.Now(),
+	}
+	if err := tx.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// openTillSession is the cashier's open session, or nil when they have none
+func openTillSession(tx *gorm.DB, orgID uint, userID uint) (*models.TillSession, error) {
+	var session models.TillSession
+	err := tx.Where("organization_id = ? AND user_id = ? AND status = ?", orgID, userID, models.TillSessionOpen).
+		Order("id DESC").First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// CurrentTillSession is the cashier's open session
+func CurrentTillSession(db *gorm.DB, orgID uint, userID uint) (*models.TillSession, error) {
+	session, err := openTillSession(db, orgID, userID)
+	if err == nil && session == nil {
+		err = ErrTillSessionNotFound
+	}
+	return session, err
+}
+
+// FindTillSession loads an organization's till session
+func FindTillSession(db *gorm.DB, orgID uint, id uint) (*models.TillSession, error) {
+	var session models.TillSession
+	err := db.Where("id = ? AND organization_id = ?", id, orgID).First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTillSessionNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// LockTillSession loads an organization's till session with a row lock held
+// until the transaction ends
+func LockTillSession(tx *gorm.DB, orgID uint, id uint) (*models.TillSession, error) {
+	return FindTillSession(tx.Clauses(clause.Locking{Strength: "UPDATE"}), orgID, id)
+}
+
+// AddTillEntry records cash put into or taken out of an open session
+func AddTillEntry(tx *gorm.DB, session *models.TillSession, entry *models.TillEntry) error {
+	if session.Status != models.TillSessionOpen {
+		return ErrTillSessionClosed
+	}
+	entry.Reason = strings.TrimSpace(entry.Reason)
+	if (entry.Kind != models.TillEntryCashIn && entry.Kind != models.TillEntryCashOut) ||
+		entry.Amount <= 0 || entry.Reason == "" {
+		return ErrInvalidTillEntry
+	}
+	entry.ID = 0
+	entry.OrganizationID = session.OrganizationID
+	entry.TillSessionID = session.ID
+	entry.Amount = entry.Amount.Round(session.Currency)
+	return tx.Create(entry).Error
+}
+
+// CloseTillSession ends a shift with the cash counted in the drawer and
+// records how far it was over or short of what was expected
+func CloseTillSession(tx *gorm.DB, session *models.TillSession, counted models.Money, note string, closedBy uint) (*TillReport, error) {
+	if session.Status != models.TillSessionOpen {
+		return nil, ErrTillSessionClosed
+	}
+	if counted < 0 {
+		return nil, ErrInvalidCashAmount
+	}
+	report, err := BuildTillReport(tx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session.Status = models.TillSessionClosed
+	session.ClosedAt = &now
+	session.ClosedBy = closedBy
+	session.ExpectedCash = report.ExpectedCash
+	session.CountedCash = counted.Round(session.Currency)
+	session.OverShort = session.CountedCash - session.ExpectedCash
+	session.Note = strings.TrimSpace(note)
+	if err := tx.Model(session).Updates(map[string]interface{}{
+		"status":        session.Status,
+		"closed_at":     now,
+		"closed_by":     closedBy,
+		"expected_cash": session.ExpectedCash,
+		"counted_cash":  session.CountedCash,
+		"over_short":    session.OverShort,
+		"note":          session.Note,
+	}).Error; err != nil {
+		return nil, err
+	}
+	report.Session = *session
+	return report, nil
+}
+
+// TenderTotal is what one tender took or gave back in a session
+type TenderTotal struct {
+	Tender string       `json:"tender"`
+	Count  int64        `json:"count"`
+	Amount models.Money `json:"amount"`
+}
+
+// TillReport sums up a till session: an X-report while it is open, the
+// Z-report once it is closed. Expected cash is the float plus cash taken for
+// sales and invoices and paid in, less cash refunded and paid out.
+type TillReport struct {
+	Session       models.TillSession `json:"session"`
+	Receipts      int64              `json:"receipts"`
+	TotalSales    models.Money       `json:"total_sales"`
+	TotalTax      models.Money       `json:"total_tax"`
+	TotalDiscount models.Money       `json:"total_discount"`
+	Payments      []TenderTotal      `json:"payments"`
+	Refunds       []TenderTotal      `json:"refunds"`
+	// InvoicePayments are customers paying off their accounts
+	InvoicePayments []TenderTotal `json:"invoice_payments"`
+	CashIn          models.Money  `json:"cash_in"`
+	CashOut         models.Money  `json:"cash_out"`
+	ExpectedCash    models.Money  `json:"expected_cash"`
+}
+
+// BuildTillReport totals what went through a session so far
+func BuildTillReport(db *gorm.DB, session *models.TillSession) (*TillReport, error) {
+	report := &TillReport{Session: *session, Payments: []TenderTotal{}, Refunds: []TenderTotal{}, InvoicePayments: []TenderTotal{}}
+
+	var receipts struct {
+		Receipts      int64
+		TotalSales    models.Money
+		TotalTax      models.Money
+		TotalDiscount models.Money
+	}
+	if err := db.Model(&models.Receipt{}).Where("till_session_id = ?", session.ID).
+		Select("COUNT(*) AS receipts, COALESCE(SUM(total_amount), 0) AS total_sales, " +
+			"COALESCE(SUM(total_tax), 0) AS total_tax, COALESCE(SUM(total_discount), 0) AS total_discount").
+		Scan(&receipts).Error; err != nil {
+		return nil, err
+	}
+	report.Receipts = receipts.Receipts
+	report.TotalSales, report.TotalTax, report.TotalDiscount = receipts.TotalSales, receipts.TotalTax, receipts.TotalDiscount
+
+	if err := db.Model(&models.Payment{}).Where("till_session_id = ?", session.ID).
+		Select("tender, COUNT(*) AS count, SUM(amount) AS amount").
+		Group("tender").Order("tender").Scan(&report.Payments).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.PaymentRefund{}).Where("till_session_id = ?", session.ID).
+		Select("tender, COUNT(*) AS count, SUM(amount) AS amount").
+		Group("tender").Order("tender").Scan(&report.Refunds).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(&models.InvoicePayment{}).Where("till_session_id = ?", session.ID).
+		Select("tender, COUNT(*) AS count, SUM(amount) AS amount").
+		Group("tender").Order("tender").Scan(&report.InvoicePayments).Error; err != nil {
+		return nil, err
+	}
+
+	var entries []TenderTotal
+	if err := db.Model(&models.TillEntry{}).Where("till_session_id = ?", session.ID).
+		Select("kind AS tender, COUNT(*) AS count, SUM(amount) AS amount").
+		Group("kind").Scan(&entries).Error; err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		switch entry.Tender {
+		case models.TillEntryCashIn:
+			report.CashIn = entry.Amount
+		case models.TillEntryCashOut:
+			report.CashOut = entry.Amount
+		}
+	}
+
+	report.ExpectedCash = session.OpeningFloat + report.CashIn - report.CashOut
+	for _, payment := range report.Payments {
+		if payment.Tender == models.TenderCash {
+			report.ExpectedCash += payment.Amount
+		}
+	}
+	for _, payment := range report.InvoicePayments {
+		if payment.Tender == models.TenderCash {
+			report.ExpectedCash += payment.Amount
+		}
+	}
+	for _, refund := range report.Refunds {
+		if refund.Tender == models.TenderCash {
+			report.ExpectedCash -= refund.Amount
+		}
+	}
+	return report, nil
+}
+
+// CashierOverShort is how one cashier's closed sessions of one day counted up
+type CashierOverShort struct {
+	Day          string       `json:"day"`
+	UserID       uint         `json:"user_id"`
+	Username     string       `json:"username"`
+	Sessions     int64        `json:"sessions"`
+	ExpectedCash models.Money `json:"expected_cash"`
+	CountedCash  models.Money `json:"counted_cash"`
+	OverShort    models.Money `json:"over_short"`
+}
+
+// SummarizeOverShort totals closed till sessions by cashier and the day, in
+// the period's time zone, they were opened on
+func SummarizeOverShort(db *gorm.DB, period SalesPeriod) ([]CashierOverShort, error) {
+	local, args := localDateSQL("till_sessions.opened_at", period)
+	query := db.Model(&models.TillSession{}).
+		Joins("LEFT JOIN users ON users.id = till_sessions.user_id").
+		Where("till_sessions.status = ? AND till_sessions.opened_at >= ? AND till_sessions.opened_at < ?",
+			models.TillSessionClosed, period.From, period.To)
+	if period.OrganizationID != 0 {
+		query = query.Where("till_sessions.organization_id = ?", period.OrganizationID)
+	}
+
+	rows := []CashierOverShort{}
+	err := query.Select(fmt.Sprintf("DATE_FORMAT(%s, '%%Y-%%m-%%d') AS day, till_sessions.user_id, "+
+		"COALESCE(MAX(users.username), '') AS username, COUNT(*) AS sessions, "+
+		"SUM(till_sessions.expected_cash) AS expected_cash, SUM(till_sessions.counted_cash) AS counted_cash, "+
+		"SUM(till_sessions.over_short) AS over_short", local), args...).
+		Group("day, till_sessions.user_id").Order("day, till_sessions.user_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"stock/models"
+	"stock/utils"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+	ErrUserInactive        = errors.New("user is inactive")
+)
+
+// RefreshTokenTTL is how long a refresh token can be exchanged for a new access token
+var RefreshTokenTTL = 30 * 24 * time.Hour
+
+// TokenPair is returned on login and refresh
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// IssueTokens creates an access token and a new refresh token for the user
+func IssueTokens(db *gorm.DB, user *models.User) (*TokenPair, error) {
+	pair, _, err := issueTokens(db, user)
+	return pair, err
+}
+
+func issueTokens(db *gorm.DB, user *models.User) (*TokenPair, *models.RefreshToken, error) {
+	accessToken, err := utils.GenerateJWT(user.ID, user.RoleID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	stored := models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	if err := db.Create(&stored).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(utils.AccessTokenTTL.Seconds()),
+	}, &stored, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new token pair and
+// revokes the old refresh token. It is refused, like a login, while the user
+// is locked out, inactive or in a deactivated organization, or has not
+// enrolled in two-factor authentication their role now requires. Presenting a token that was already rotated
+// means it has leaked, so every refresh token of the user is revoked and
+// ErrRefreshTokenReused is returned; the caller must still commit tx.
+func RotateRefreshToken(tx *gorm.DB, refreshToken string) (*TokenPair, error) {
+	var stored models.RefreshToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", hashToken(refreshToken)).First(&stored).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if stored.RevokedAt != nil {
+		if stored.ReplacedByID != nil {
+			log.Printf("Refresh token reuse detected for UserID %d, revoking all sessions", stored.UserID)
+			if err := revokeRefreshTokens(tx, stored.UserID); err != nil {
+				return nil, err
+			}
+			return nil, ErrRefreshTokenReused
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var user models.User
+	if err := tx.First(&user, stored.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	// The session only lives on while the user could still sign in
+	if accountLocked(&user) {
+		return nil, ErrAccountLocked
+	}
+	if err := checkSignIn(tx, &user); err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		required, err := MFARequired(tx, &user)
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			return nil, ErrMFARequired
+		}
+	}
+
+	pair, replacement, err := issueTokens(tx, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := tx.Model(&stored).Updates(map[string]interface{}{
+		"revoked_at":     now,
+		"replaced_by_id": replacement.ID,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// RevokeAccessToken adds an access token to the revocation list and revokes
+// the refresh token presented with it, if any
+func RevokeAccessToken(db *gorm.DB, claims *utils.Claims, refreshToken string) error {
+	// Tokens issued before the jti claim existed can only be cut off per user
+	if claims.Id == "" {
+		return RevokeUserTokens(db, claims.UserID)
+	}
+
+	revoked := models.RevokedToken{
+		TokenID:   claims.Id,
+		UserID:    claims.UserID,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+		return err
+	}
+
+	if refreshToken != "" {
+		if err := db.Model(&models.RefreshToken{}).
+			Where("token_hash = ? AND user_id = ? AND revoked_at IS NULL", hashToken(refreshToken), claims.UserID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+	}
+
+	// Entries past their expiry no longer need to be kept
+	return db.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error
+}
+
+// RevokeUserTokens invalidates every access and refresh token the user holds.
+// It is used on deactivation and password change.
+func RevokeUserTokens(db *gorm.DB, userID uint) error {
+	// Compared with the microsecond issue time of tokens, so one issued right
+	// after this, such as on password change, stays valid
+	cutoff := time.Now().Truncate(time.Microsecond)
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Update("tokens_valid_after", cutoff).Error; err != nil {
+		return err
+	}
+	return revokeRefreshTokens(db, userID)
+}
+
+// TokenRevoked reports whether an access token has been logged out or issued
+// before the user's tokens were invalidated
+func TokenRevoked(db *gorm.DB, claims *utils.Claims) (bool, error) {
+	if claims.Id != "" {
+		var count int64
+		if err := db.Model(&models.RevokedToken{}).Where("token_id = ?", claims.Id).Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+
+	var user models.User
+	if err := db.Select("id", "tokens_valid_after").First(&user, claims.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	if user.TokensValidAfter == nil {
+		return false, nil
+	}
+	// Tokens from before iat_us only know the second they were issued in, so
+	// one from the second of the cutoff is treated as issued before it
+	if claims.IssuedAtMicro == 0 {
+		return claims.IssuedAt <= user.TokensValidAfter.Unix(), nil
+	}
+	return claims.IssuedAtMicro <= user.TokensValidAfter.UnixMicro(), nil
+}
+
+func revokeRefreshTokens(db *gorm.DB, userID uint) error {
+	return db.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// SigningKey is a key tokens are signed or verified with, identified by the
+// kid header of the token
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Private signs new tokens. It is nil for retired keys kept only to
+	// verify tokens issued before a rotation.
+	Private interface{}
+	Public  interface{}
+}
+
+var (
+	keysMu      sync.RWMutex
+	signingKeys = map[string]*SigningKey{}
+	activeKeyID string
+)
+
+var ErrNoSigningKey = errors.New("no JWT signing key configured")
+
+// LoadSigningKeys reads the key material from the environment:
+//
+//   - JWT_KEYS_DIR: a directory of keys named after their kid. <kid>.key holds
+//     an RSA (RS256) or Ed25519 (EdDSA) private key in PEM, <kid>.pub a public
+//     key kept to verify tokens signed by a retired key, and <kid>.secret an
+//     HMAC (HS256) secret.
+//   - JWT_SECRET: an HMAC secret, registered under JWT_ACTIVE_KID or "default".
+//   - JWT_ACTIVE_KID: the key new tokens are signed with. It may be omitted when
+//     only one key can sign.
+func LoadSigningKeys() error {
+	keys := map[string]*SigningKey{}
+
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("reading JWT_KEYS_DIR: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			ext := filepath.Ext(entry.Name())
+			kid := strings.TrimSuffix(entry.Name(), ext)
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return err
+			}
+
+			var key *SigningKey
+			switch ext {
+			case ".key":
+				key, err = parsePrivateKey(kid, data)
+			case ".pub":
+				key, err = parsePublicKey(kid, data)
+			case ".secret":
+				key = hmacKey(kid, []byte(strings.TrimSpace(string(data))))
+			default:
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("loading key %s: %w", entry.Name(), err)
+			}
+			// A private key also provides the public half, so it wins over a .pub file
+			if existing, ok := keys[kid]; ok && existing.Private != nil {
+				continue
+			}
+			keys[kid] = key
+		}
+	}
+
+	activeID := os.Getenv("JWT_ACTIVE_KID")
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		kid := activeID
+		if kid == "" {
+			kid = "default"
+		}
+		keys[kid] = hmacKey(kid, []byte(secret))
+	}
+
+	if activeID == "" {
+		for kid, key := range keys {
+			if key.Private == nil {
+				continue
+			}
+			if activeID != "" {
+				return errors.New("JWT_ACTIVE_KID must be set when several signing keys are configured")
+			}
+			activeID = kid
+		}
+	}
+	active, ok := keys[activeID]
+	if !ok || active.Private == nil {
+		return ErrNoSigningKey
+	}
+
+	keysMu.Lock()
+	signingKeys = keys
+	activeKeyID = activeID
+	keysMu.Unlock()
+
+	log.Printf("Loaded %d JWT keys, signing with kid %q (%s)", len(keys), activeID, active.Method.Alg())
+	return nil
+}
+
+func hmacKey(kid string, secret []byte) *SigningKey {
+	return &SigningKey{ID: kid, Method: jwt.SigningMethodHS256, Private: secret, Public: secret}
+}
+
+func parsePrivateKey(kid string, data []byte) (*SigningKey, error) {
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Private: key, Public: &key.PublicKey}, nil
+	}
+	key, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, errors.New("not an RSA or Ed25519 private key")
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 private key")
+	}
+	return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Private: edKey, Public: edKey.Public()}, nil
+}
+
+func parsePublicKey(kid string, data []byte) (*SigningKey, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Public: key}, nil
+	}
+	key, err := jwt.ParseEdPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, errors.New("not an RSA or Ed25519 public key")
+	}
+	return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Public: key}, nil
+}
+
+// activeSigningKey returns the key new tokens are signed with
+func activeSigningKey() (*SigningKey, error) {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	key, ok := signingKeys[activeKeyID]
+	if !ok {
+		return nil, ErrNoSigningKey
+	}
+	return key, nil
+}
+
+// verificationKey resolves the key for a token from its kid header and
+// refuses tokens signed with a different algorithm than the key's
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	keysMu.RLock()
+	key, ok := signingKeys[kid]
+	keysMu.RUnlock()
+	if !ok {
+		log.Printf("Unknown signing key ID: %q", kid)
+		return nil, ErrInvalidToken
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		log.Printf("Unexpected signing method: %v", token.Method.Alg())
+		return nil, ErrInvalidToken
+	}
+	return key.Public, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS lists the public keys other services can verify tokens with. HMAC
+// secrets are never published.
+func JWKS() []JWK {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+
+	jwks := []JWK{}
+	for kid, key := range signingKeys {
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Alg: key.Method.Alg(),
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Alg: key.Method.Alg(),
+				Use: "sig",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].Kid < jwks[j].Kid })
+	return jwks
+}
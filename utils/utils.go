@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"github.com/golang-jwt/jwt"
 	"golang.org/x/crypto/bcrypt"
@@ -9,10 +11,11 @@ import (
 	"time"
 )
 
-var (
-	JwtSecret = []byte("your-secret-key") // Replace with your actual secret key
-	DB        *gorm.DB
-)
+var DB *gorm.DB
+
+// AccessTokenTTL is how long an access token is valid. Clients renew it with
+// a refresh token.
+var AccessTokenTTL = 15 * time.Minute
 
 var ErrInvalidToken = errors.New("invalid token")
 
@@ -20,18 +23,15 @@ var ErrInvalidToken = errors.New("invalid token")
 type Claims struct {
 	UserID uint `json:"user_id"`
 	RoleID uint `json:"role_id"`
+	// IssuedAtMicro is iat in microseconds, precise enough to tell tokens
+	// issued in the same second as a revocation apart
+	IssuedAtMicro int64 `json:"iat_us,omitempty"`
 	jwt.StandardClaims
 }
 
-// ParseToken parses and validates the JWT token
+// ParseToken parses and validates the JWT token against the key named by its kid header
 func ParseToken(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			log.Printf("Unexpected signing method: %v", token.Method)
-			return nil, ErrInvalidToken
-		}
-		return JwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, verificationKey)
 	if err != nil {
 		log.Printf("Error parsing token: %v", err)
 		return nil, err
@@ -43,20 +43,34 @@ func ParseToken(tokenString string) (*jwt.Token, error) {
 	return token, nil
 }
 
-// GenerateJWT generates a JWT token with userID and roleID
+// GenerateJWT generates a short-lived access token with userID and roleID.
+// Each token carries a unique ID so it can be revoked on its own.
 func GenerateJWT(userID, roleID uint) (string, error) {
 	log.Printf("Generating JWT for userID: %d, roleID: %d", userID, roleID)
-	expirationTime := time.Now().Add(365* 24 * time.Hour)
+	tokenID, err := RandomToken(16)
+	if err != nil {
+		log.Printf("Error generating token ID: %v", err)
+		return "", err
+	}
+	now := time.Now()
 	claims := &Claims{
-		UserID: userID,
-		RoleID: roleID,
+		UserID:        userID,
+		RoleID:        roleID,
+		IssuedAtMicro: now.UnixMicro(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
+			Id:        tokenID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString(JwtSecret)
+	key, err := activeSigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	signedToken, err := token.SignedString(key.Private)
 	if err != nil {
 		log.Printf("Error signing token: %v", err)
 		return "", err
@@ -67,13 +81,7 @@ func GenerateJWT(userID, roleID uint) (string, error) {
 // VerifyJWT verifies the JWT token and returns the claims
 func VerifyJWT(tokenString string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			log.Printf("Unexpected signing method: %v", token.Method)
-			return nil, ErrInvalidToken
-		}
-		return JwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
 
 	if err != nil {
 		log.Printf("Error verifying token: %v", err)
@@ -89,6 +97,15 @@ func VerifyJWT(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
+// RandomToken returns n random bytes encoded as hex
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // HashPassword hashes a password
 func HashPassword(password string) (string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)